@@ -0,0 +1,15 @@
+module search-engine/all-in-one
+
+go 1.16
+
+require (
+	search-engine/crawler v0.0.0
+	search-engine/index v0.0.0
+	search-engine/web v0.0.0
+)
+
+replace (
+	search-engine/crawler => ../crawler
+	search-engine/index => ../index
+	search-engine/web => ../web
+)
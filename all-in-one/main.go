@@ -0,0 +1,54 @@
+// 在一个进程中运行爬虫、索引服务器和 web 服务器，用于开发和小规模部署，
+// 需要在包含 crawler.properties、indexer.properties、config.properties 的目录下运行，
+// 且三个配置文件都应使用嵌入模式（storage.mode=embedded）
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	crawlerApp "search-engine/crawler/app"
+	crawlerDB "search-engine/crawler/db"
+	indexApp "search-engine/index/app"
+	indexCore "search-engine/index/core"
+	indexDB "search-engine/index/db"
+	webApp "search-engine/web/app"
+	webDB "search-engine/web/db"
+	"time"
+)
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Llongfile)
+	restore := flag.String("restore", "", "启动前从快照恢复索引服务器的数据库：备份文件的路径，或者 http://addr/backup")
+	flag.Parse()
+	if *restore != "" {
+		if err := indexCore.RestoreFrom(*restore); err != nil {
+			log.Fatalln("恢复失败：" + err.Error())
+		}
+		log.Println("已从 " + *restore + " 恢复")
+	}
+	// 三个服务共享同一个进程内的注册中心
+	registry := crawlerDB.NewMemoryRegistry()
+	crawlerDB.Registry = registry
+	indexDB.Registry = registry
+	webDB.Registry = registry
+
+	errChan := make(chan error, 3)
+	go func() {
+		errChan <- fmt.Errorf("索引服务器退出：%w", indexApp.Run())
+	}()
+	// 等待索引服务器注册完成，爬虫启动时就能获取到索引服务器地址
+	for {
+		if addrs, _ := registry.List("indexer.addr"); len(addrs) > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	go func() {
+		errChan <- fmt.Errorf("爬虫退出：%w", crawlerApp.Run())
+	}()
+	go func() {
+		errChan <- fmt.Errorf("web 服务器退出：%w", webApp.Run())
+	}()
+	log.Fatalln(<-errChan)
+}
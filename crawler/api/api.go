@@ -22,6 +22,10 @@ type MonitorInfo struct {
 	CrawledCount int     `json:"crawled_count"`
 	FailureCount int     `json:"failure_count"`
 	FailureRate  float32 `json:"failure_rate"`
+	// 已登记的订阅源数量
+	FeedCount int64 `json:"feed_count"`
+	// 被判定为爬虫陷阱的站点
+	TrapHosts []core.TrapHost `json:"trap_hosts"`
 }
 
 type Response struct {
@@ -37,10 +41,10 @@ const (
 
 var engine *core.Engine
 
-func Serve(e *core.Engine) {
+func Serve(e *core.Engine, mux *http.ServeMux) {
 	engine = e
-	http.HandleFunc("/monitor", monitor)
-	http.HandleFunc("/seedurl", addSeedUrl)
+	mux.HandleFunc("/monitor", monitor)
+	mux.HandleFunc("/seedurl", addSeedUrl)
 }
 
 func monitor(response http.ResponseWriter, request *http.Request) {
@@ -62,6 +66,8 @@ func monitor(response http.ResponseWriter, request *http.Request) {
 		info.FailureRate = float32(info.FailureCount) / float32(info.CrawledCount)
 	}
 	info.RunningTime = int(time.Now().Unix() - engine.Birthday)
+	info.TrapHosts = engine.TrapHosts(10)
+	info.FeedCount = engine.FeedCount()
 
 	write(response, http.StatusOK, &Response{
 		Code: codeSuccess,
@@ -74,18 +80,14 @@ func addSeedUrl(response http.ResponseWriter, request *http.Request) {
 	if err != nil {
 		return
 	}
-	m := make(map[string]interface{}, 1)
-	if err = json.Unmarshal(body, &m); err != nil {
-		write(response, http.StatusBadRequest, &Response{Code: codeFail, Msg: "json format error"})
-		return
-	} else if v, ok := m["seed_urls"]; !ok {
-		write(response, http.StatusBadRequest, &Response{Code: codeFail, Msg: "json format error"})
-		return
-	} else if _, ok = v.([]string); !ok {
+	var params struct {
+		SeedUrls []string `json:"seed_urls"`
+	}
+	if err = json.Unmarshal(body, &params); err != nil || params.SeedUrls == nil {
 		write(response, http.StatusBadRequest, &Response{Code: codeFail, Msg: "json format error"})
 		return
 	}
-	seedUrls := m["seed_urls"].([]string)
+	seedUrls := params.SeedUrls
 	go func() {
 		for _, u := range seedUrls {
 			engine.SeedUrlChan <- u
@@ -0,0 +1,80 @@
+// 爬虫服务的启动流程，main 和 all-in-one 共用
+package app
+
+import (
+	"log"
+	"net/http"
+	"search-engine/crawler/api"
+	"search-engine/crawler/config"
+	"search-engine/crawler/core"
+	"search-engine/crawler/db"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 注册自己到注册中心
+func registerSelf() {
+	addr := config.GetLocal("crawler.listenAddr")
+	register := func() {
+		// addr:timestamp
+		if err := db.Registry.Register("crawler.addr", addr); err != nil {
+			log.Println(addr + "注册到注册中心失败")
+		}
+	}
+	register()
+	go func() {
+		for {
+			time.Sleep(time.Second * 30) // 每30秒报告自己的存活状态
+			register()
+		}
+	}()
+}
+
+// 启动爬虫，阻塞直到 HTTP 服务退出
+func Run() error {
+	// 退出时移除自己
+	defer func() {
+		_ = db.Registry.Deregister("crawler.addr", config.GetLocal("crawler.listenAddr"))
+	}()
+	// 初始化定时任务
+	core.InitCron()
+
+	// 获取配置
+	goroutineCount, err := strconv.Atoi(config.GetLocal("crawler.goroutineCount"))
+	if err != nil {
+		panic("goroutineCount format error")
+	}
+
+	var scheduler core.Scheduler
+	var bloomfilter core.BloomFilter
+	switch config.GetLocal("crawler.scheduler") {
+	case "single":
+		scheduler = core.NewBFScheduler()
+		bloomfilter = core.NewLocalBloomFilter(1000_0000)
+	case "distributed":
+		scheduler = core.NewDistributedScheduler()
+		if config.Embedded() {
+			bloomfilter = core.NewLocalBloomFilter(1000_0000)
+		} else {
+			bloomfilter = core.NewDistBloomFilter(1000_0000)
+		}
+	default:
+		panic("unknown scheduler")
+	}
+
+	engine := core.NewCrawlerEngine(
+		scheduler,
+		core.GlobalDl,
+		bloomfilter,
+		goroutineCount,
+		strings.Split(config.GetLocal("crawler.seedUrls"), ","),
+	)
+	engine.Run()
+
+	registerSelf()
+
+	mux := http.NewServeMux()
+	api.Serve(engine, mux)
+	return http.ListenAndServe(config.GetLocal("crawler.listenAddr"), mux)
+}
@@ -13,15 +13,26 @@ import (
 	"time"
 )
 
+// 存储模式
+const (
+	// 分布式模式，动态配置存储在 MySQL 中，服务注册、URL 队列、布隆过滤器使用 Redis
+	ModeDistributed = "distributed"
+	// 嵌入模式，不依赖 MySQL 和 Redis，动态配置存储在本地文件中，其余使用进程内的实现
+	ModeEmbedded = "embedded"
+)
+
 var (
 	dynamicConfig atomic.Value
 	db            *sql.DB
 	stmt          *sql.Stmt
+	source        dynamicConfigSource
 
 	// 本地配置项必须提供
-	localConfigItem = [...]string{"mysql.username", "mysql.password", "mysql.host",
-		"mysql.port", "mysql.dbname", "redis.addr", "indexer.addr", "crawler.goroutineCount",
+	localConfigItem = [...]string{"indexer.addr", "crawler.goroutineCount",
 		"crawler.seedUrls", "crawler.listenAddr", "crawler.scheduler"}
+	// 分布式模式下必须提供的配置项
+	distributedConfigItem = [...]string{"mysql.username", "mysql.password", "mysql.host",
+		"mysql.port", "mysql.dbname", "redis.addr"}
 
 	localConfig   = loadLocalConfig()
 	defaultConfig = CrawlerConfig{
@@ -31,6 +42,13 @@ var (
 		Timeout:        10000,
 		RetryCount:     3,
 		Useragent:      "qut_spider",
+
+		MaxUrlLength:        512,
+		MaxRepeatedSegments: 3,
+		MaxParamValues:      500,
+		MaxPatternUrls:      5000,
+
+		FeedPollInterval: 600,
 	}
 )
 
@@ -50,6 +68,45 @@ type CrawlerConfig struct {
 	Useragent string
 	// 日志级别
 	LogLevel int
+
+	// 爬虫陷阱检测
+	// URL 最大长度
+	MaxUrlLength int
+	// 路径中同一片段（或片段序列）最多重复的次数
+	MaxRepeatedSegments int
+	// 单个站点中，同一个查询参数最多允许的不同取值数量
+	MaxParamValues int
+	// 单个站点中，同一个路径模式最多允许的 URL 数量
+	MaxPatternUrls int
+
+	// 订阅源的轮询间隔，单位秒，小于等于 0 时不轮询
+	FeedPollInterval int64
+
+	// 后台设置的域名优先级 domain->priority，未设置的域名为 DefaultPriority
+	DomainPriority map[string]int
+}
+
+// 域名优先级的范围
+const (
+	DefaultPriority = 1
+	MaxPriority     = 10
+)
+
+// 获取 host（不带端口）的优先级，host 是设置的域名或其子域名时生效，有多个匹配时取最长的域名
+func (c *CrawlerConfig) Priority(host string) int {
+	host = strings.ToLower(host)
+	priority, matchedLen := DefaultPriority, 0
+	for domain, p := range c.DomainPriority {
+		if len(domain) > matchedLen && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			priority, matchedLen = p, len(domain)
+		}
+	}
+	if priority < DefaultPriority {
+		priority = DefaultPriority
+	} else if priority > MaxPriority {
+		priority = MaxPriority
+	}
+	return priority
 }
 
 func (c *CrawlerConfig) fill(name, value string) {
@@ -66,24 +123,25 @@ func (c *CrawlerConfig) fill(name, value string) {
 		util.ToInt(&c.RetryCount, value)
 	case "useragent": // string
 		c.Useragent = value
+	case "max_url_length":
+		util.ToInt(&c.MaxUrlLength, value)
+	case "max_repeated_segments":
+		util.ToInt(&c.MaxRepeatedSegments, value)
+	case "max_param_values":
+		util.ToInt(&c.MaxParamValues, value)
+	case "max_pattern_urls":
+		util.ToInt(&c.MaxPatternUrls, value)
+	case "feed_poll_interval":
+		util.ToInt64(&c.FeedPollInterval, value)
 	}
 }
 
 func init() {
-	// 初始化数据库
-	var err error
-	lc := localConfig
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8", lc["mysql.username"],
-		lc["mysql.password"], lc["mysql.host"], lc["mysql.port"], lc["mysql.dbname"])
-
-	if db, err = sql.Open("mysql", dsn); err != nil {
-		panic(err)
-	}
-	if err = db.Ping(); err != nil {
-		panic(err)
-	}
-	if stmt, err = db.Prepare("select `name`, `value` from `crawler`"); err != nil {
-		panic(err)
+	// 初始化动态配置的来源
+	if Embedded() {
+		source = newFileSource(GetLocal("embedded.adminDataPath"))
+	} else {
+		source = newMysqlSource()
 	}
 
 	// 初始化配置更新协程
@@ -117,7 +175,10 @@ func loadLocalConfig() map[string]string {
 		panic(fmt.Sprintf("读取配置文件 %s/crawler.properties 失败", pwd))
 	}
 
-	config := make(map[string]string)
+	config := map[string]string{
+		"storage.mode":           ModeDistributed,
+		"embedded.adminDataPath": "./data/admin.json",
+	}
 	for _, line := range strings.Split(string(b), "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" || line[0] == '#' {
@@ -134,7 +195,15 @@ func loadLocalConfig() map[string]string {
 }
 
 func checkLocalConfigItem(config map[string]string) {
-	for _, name := range localConfigItem {
+	required := localConfigItem[:]
+	switch config["storage.mode"] {
+	case ModeDistributed:
+		required = append(required, distributedConfigItem[:]...)
+	case ModeEmbedded:
+	default:
+		panic(fmt.Sprintf("配置项[storage.mode]错误：%s", config["storage.mode"]))
+	}
+	for _, name := range required {
 		if _, ok := config[name]; !ok {
 			panic(fmt.Sprintf("缺少配置项[%s]", name))
 		}
@@ -145,18 +214,19 @@ func loadLatestConfig() *CrawlerConfig {
 	// 拷贝一份默认配置
 	latestConfig := defaultConfig
 
-	rows, err := stmt.Query()
+	conf, err := source.loadCrawlerConfig()
 	if err != nil {
 		return &latestConfig
 	}
-
-	for rows.Next() {
-		var name, value string
-		if err = rows.Scan(&name, &value); err != nil {
-			return &latestConfig
-		}
+	for name, value := range conf {
 		latestConfig.fill(name, value)
 	}
+	if priority, err := source.loadDomainPriority(); err == nil {
+		latestConfig.DomainPriority = make(map[string]int, len(priority))
+		for domain, p := range priority {
+			latestConfig.DomainPriority[strings.ToLower(strings.TrimPrefix(domain, "*."))] = p
+		}
+	}
 	return &latestConfig
 }
 
@@ -167,3 +237,8 @@ func Get() *CrawlerConfig {
 func GetLocal(key string) string {
 	return localConfig[key]
 }
+
+// 是否运行在嵌入模式下
+func Embedded() bool {
+	return localConfig["storage.mode"] == ModeEmbedded
+}
@@ -18,3 +18,25 @@ func TestGet(t *testing.T) {
 		t.Fatal("failed")
 	}
 }
+
+func TestPriority(t *testing.T) {
+	conf := CrawlerConfig{DomainPriority: map[string]int{
+		"qut.edu.cn":     5,
+		"lib.qut.edu.cn": 8,
+		"example.com":    100,
+	}}
+	cases := map[string]int{
+		"qut.edu.cn":       5,
+		"www.qut.edu.cn":   5,
+		"lib.qut.edu.cn":   8,
+		"a.lib.qut.edu.cn": 8,
+		"xqut.edu.cn":      DefaultPriority,
+		"example.com":      MaxPriority,
+		"www.sina.com.cn":  DefaultPriority,
+	}
+	for host, want := range cases {
+		if got := conf.Priority(host); got != want {
+			t.Errorf("%s: got %d, want %d", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// 动态配置的来源，分布式模式下是 MySQL，嵌入模式下是本地的管理数据文件
+type dynamicConfigSource interface {
+	// 爬虫配置 name->value
+	loadCrawlerConfig() (map[string]string, error)
+	// 域名优先级 domain->priority
+	loadDomainPriority() (map[string]int, error)
+}
+
+type mysqlSource struct {
+	priorityStmt *sql.Stmt
+}
+
+func newMysqlSource() *mysqlSource {
+	var err error
+	lc := localConfig
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8", lc["mysql.username"],
+		lc["mysql.password"], lc["mysql.host"], lc["mysql.port"], lc["mysql.dbname"])
+
+	if db, err = sql.Open("mysql", dsn); err != nil {
+		panic(err)
+	}
+	if err = db.Ping(); err != nil {
+		panic(err)
+	}
+	if stmt, err = db.Prepare("select `name`, `value` from `crawler`"); err != nil {
+		panic(err)
+	}
+	source := &mysqlSource{}
+	if source.priorityStmt, err = db.Prepare("select `domain`, `priority` from `domain_priority`"); err != nil {
+		panic(err)
+	}
+	return source
+}
+
+func (m *mysqlSource) loadCrawlerConfig() (map[string]string, error) {
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conf := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err = rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		conf[name] = value
+	}
+	return conf, nil
+}
+
+func (m *mysqlSource) loadDomainPriority() (map[string]int, error) {
+	rows, err := m.priorityStmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	priority := make(map[string]int)
+	for rows.Next() {
+		var domain string
+		var p int
+		if err = rows.Scan(&domain, &p); err != nil {
+			return nil, err
+		}
+		priority[domain] = p
+	}
+	return priority, nil
+}
+
+// 嵌入模式下 web 后台将管理数据（爬虫配置、域名黑名单等）保存在一个 json 文件中，
+// 爬虫只读取其中和自己有关的部分
+type fileSource struct {
+	path string
+}
+
+type adminData struct {
+	Crawler        map[string]string `json:"crawler"`
+	DomainPriority map[string]int    `json:"domain_priority"`
+}
+
+func newFileSource(path string) *fileSource {
+	return &fileSource{path: path}
+}
+
+func (f *fileSource) read() (*adminData, error) {
+	data := &adminData{}
+	b, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		// 后台还没有保存过数据，使用默认配置
+		return data, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (f *fileSource) loadCrawlerConfig() (map[string]string, error) {
+	data, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return data.Crawler, nil
+}
+
+func (f *fileSource) loadDomainPriority() (map[string]int, error) {
+	data, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return data.DomainPriority, nil
+}
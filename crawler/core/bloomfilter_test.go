@@ -8,18 +8,18 @@ import (
 
 func TestNewBloomFilter(t *testing.T) {
 	bf := NewLocalBloomFilter(4)
-	bf.Add("http://baidu.com/")
-	fmt.Println(bf.Has("http://baidu.com/"))
-	bf.Add("http://google.com/")
-	fmt.Println(bf.Has("http://google.com/"))
-	bf.Add("http://bing.com/")
-	fmt.Println(bf.Has("http://bing.com/"))
-	bf.Add("http://yahoo.com/")
-	fmt.Println(bf.Has("http://yahoo.com/"))
+	bf.add("http://baidu.com/")
+	fmt.Println(bf.has("http://baidu.com/"))
+	bf.add("http://google.com/")
+	fmt.Println(bf.has("http://google.com/"))
+	bf.add("http://bing.com/")
+	fmt.Println(bf.has("http://bing.com/"))
+	bf.add("http://yahoo.com/")
+	fmt.Println(bf.has("http://yahoo.com/"))
 	println("=========")
 	count := 0
 	for i := 0; i < 10000; i++ {
-		if bf.Has(strconv.Itoa(i*61) + "base_str") {
+		if bf.has(strconv.Itoa(i*61) + "base_str") {
 			count++
 		}
 	}
@@ -5,32 +5,79 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"regexp"
 	"search-engine/crawler/config"
 	"strings"
 )
 
-var urlPattern = regexp.MustCompile(`(?is)<a.+?href.?=.?"(.+?)"`)
+var (
+	urlPattern       = regexp.MustCompile(`(?is)<a.+?href.?=.?"(.+?)"`)
+	anchorPattern    = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*"([^"]+)"[^>]*>(.*?)</a>`)
+	anchorTagPattern = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+const (
+	maxOutLinks     = 500 // 每个页面最多发送的出链、锚文本数量
+	maxAnchorLength = 100 // 每个目标的锚文本的最大字数
+)
 
 // 提取网页文档中有意义的的超链接，参数 document 是 url 对应网页的文本数据
 func ExtractUrls(rootUrl, document string) []string {
 	result := urlPattern.FindAllStringSubmatch(document, -1)
 	urls := make([]string, 0, len(result))
 	for _, res := range result {
-		u := res[1]
-		u = trimFragment(u)
-		if !isValuableUrl(u) {
+		if u, ok := absoluteUrl(rootUrl, res[1]); ok {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// 去掉链接中的片段并转换成绝对链接，没有意义的链接返回 false
+func absoluteUrl(rootUrl, u string) (string, bool) {
+	u = trimFragment(u)
+	if !isValuableUrl(u) {
+		return "", false
+	}
+	if !strings.HasPrefix(u, "http") && !strings.HasPrefix(u, "HTTP") {
+		u = fmt.Sprintf("%s/%s", rootUrl, u)
+	}
+	return u, true
+}
+
+// 提取网页中链接的锚文本，返回目标 URL（去掉会话 ID）-> 锚文本，
+// 同一个目标的多个不同的锚文本用空格连接，不包括指向自己的链接
+func ExtractAnchors(rootUrl, document string) map[string]string {
+	anchors := make(map[string]string)
+	for _, res := range anchorPattern.FindAllStringSubmatch(document, -1) {
+		u, ok := absoluteUrl(rootUrl, res[1])
+		if !ok {
+			continue
+		}
+		parsedUrl, err := url.Parse(u)
+		if err != nil {
 			continue
 		}
-		if !strings.HasPrefix(u, "http") && !strings.HasPrefix(u, "HTTP") {
-			u = fmt.Sprintf("%s/%s", rootUrl, u)
+		u = canonicalizeUrl(parsedUrl)
+		text := html.UnescapeString(anchorTagPattern.ReplaceAllString(res[2], " "))
+		text = strings.Join(strings.Fields(text), " ")
+		old, exists := anchors[u]
+		if text == "" || u == rootUrl || strings.Contains(old, text) || !exists && len(anchors) >= maxOutLinks {
+			continue
+		}
+		if exists {
+			text = old + " " + text
+		}
+		if chars := []rune(text); len(chars) > maxAnchorLength {
+			text = string(chars[:maxAnchorLength])
 		}
-		urls = append(urls, u)
+		anchors[u] = text
 	}
-	return urls
+	return anchors
 }
 
 func isValuableUrl(u string) bool {
@@ -47,24 +94,90 @@ func trimFragment(url string) string {
 	return url
 }
 
-func SendDocument(url, document string) {
+// 页面的出链，索引服务器用来计算 PageRank：去掉会话 ID 并去重，不包括指向自己的链接。
+// 没有出链时返回空切片而不是 nil，这样索引服务器也会记录这个页面
+func outLinks(rootUrl string, urls []string) []string {
+	links := make([]string, 0, len(urls))
+	seen := map[string]bool{rootUrl: true}
+	for _, u := range urls {
+		parsedUrl, err := url.Parse(u)
+		if err != nil {
+			continue
+		}
+		if u = canonicalizeUrl(parsedUrl); !seen[u] {
+			seen[u] = true
+			links = append(links, u)
+			if len(links) >= maxOutLinks {
+				break
+			}
+		}
+	}
+	return links
+}
+
+func SendDocument(url, document string, links []string) {
+	_, addrs := shardOf(url)
+	sendToReplicas(addrs, "/index", map[string]interface{}{
+		"url":      url,
+		"document": document,
+		"links":    links,
+	})
+}
+
+// 发送页面中的锚文本，索引服务器把它们作为目标页面的字段建索引。
+// 锚文本保存在目标 URL 所属的分片上，按目标所属的分片分组发送
+func SendAnchors(source string, anchors map[string]string) {
+	groups := make(map[string]map[string]string)
+	replicas := make(map[string][]string)
+	for target, text := range anchors {
+		shard, addrs := shardOf(target)
+		if groups[shard] == nil {
+			groups[shard] = make(map[string]string)
+			replicas[shard] = addrs
+		}
+		groups[shard][target] = text
+	}
+	for shard, group := range groups {
+		sendToReplicas(replicas[shard], "/anchors", map[string]interface{}{
+			"source":  source,
+			"anchors": group,
+		})
+	}
+}
+
+// URL 所属的分片和分片要写入的副本，没有索引服务器时副本为空
+func shardOf(url string) (string, []string) {
+	shards, _ := indexerRing.Load().(*indexerShards)
+	if shards == nil {
+		return "", nil
+	}
+	shard := shards.ring.get(url)
+	return shard, shards.replicas[shard]
+}
+
+// 发送到分片的所有副本
+func sendToReplicas(addrs []string, path string, data interface{}) {
+	if len(addrs) == 0 {
+		log.Println("发送失败，无索引服务器地址")
+		return
+	}
+	for _, addr := range addrs {
+		sendToIndexer(addr, path, data)
+	}
+}
+
+// 用 PUT 方法发送到索引服务器 indexerAddr
+func sendToIndexer(indexerAddr, path string, data interface{}) {
 	// 异步发送
 	go func() {
-		j, _ := json.Marshal(map[string]string{
-			"url":      url,
-			"document": document,
-		})
+		j, _ := json.Marshal(data)
 		retryCount := config.Get().RetryCount
-		addrList := indexerAddrList.Load().([]string)
-		if len(addrList) == 0 {
-			log.Println("发送失败，无索引服务器地址")
-			return
-		}
-		indexerAddr := addrList[rand.Intn(len(addrList))]
 		for i := 0; i < retryCount+1; i++ {
-			req, _ := http.NewRequest("PUT", indexerAddr+"/index", bytes.NewReader(j))
-			_, err := http.DefaultClient.Do(req)
+			// 注册中心中的地址不带协议
+			req, _ := http.NewRequest("PUT", "http://"+indexerAddr+path, bytes.NewReader(j))
+			resp, err := http.DefaultClient.Do(req)
 			if err == nil {
+				_ = resp.Body.Close()
 				break
 			}
 		}
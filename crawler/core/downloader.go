@@ -109,6 +109,24 @@ func (d *Downloader) DownloadText(url string) (string, error) {
 	if !strings.Contains(d.getDocType(url), "text/html") {
 		return "", errors.New("ignore")
 	}
+	return downloadUtf8(url)
+}
+
+// 下载订阅源，订阅源的 Content-Type 五花八门（application/rss+xml、text/xml、text/plain 等），
+// 所以不检查 Content-Type，而是检查内容
+func (d *Downloader) DownloadFeed(url string) (string, error) {
+	document, err := downloadUtf8(url)
+	if err != nil {
+		return "", err
+	}
+	if !isFeedDocument(document) {
+		return "", errors.New("not a feed")
+	}
+	return document, nil
+}
+
+// 下载并转换成 utf-8 编码的文本
+func downloadUtf8(url string) (string, error) {
 	resp, err := download(url)
 	if err != nil {
 		return "", err
@@ -1,7 +1,6 @@
 package core
 
 import (
-	"context"
 	"fmt"
 	"log"
 	"math/rand"
@@ -10,7 +9,6 @@ import (
 	"search-engine/crawler/config"
 	"search-engine/crawler/db"
 	"search-engine/crawler/util"
-	"strconv"
 	"sync/atomic"
 	"time"
 )
@@ -23,10 +21,19 @@ type Engine struct {
 	downloader Downloader
 	// 布隆过滤器
 	bloomFilter BloomFilter
+	// 爬虫陷阱检测
+	trapDetector *trapDetector
 	// 传给下载器的 URL，channel 的缓冲区要很长
 	urlChan []chan string
+	// 优先级高于默认优先级的 URL，爬虫协程优先从这里获取，
+	// 否则高优先级的 URL 要排在 urlChan 中很长的缓冲区后面
+	priorityUrlChan []chan string
 	// 调度策略
 	scheduler Scheduler
+	// 订阅源登记表
+	feeds db.FeedStore
+	// 订阅源中新发布的 URL，engine 将其交给 URL 调度器
+	freshUrlChan chan []string
 	// 协程数量
 	goroutineCount int
 	// 种子 URL
@@ -45,7 +52,10 @@ type urlGroup struct {
 	members []string
 }
 
-var indexerAddrList atomic.Value
+// 索引服务器的分片拓扑 *indexerShards，见 shard.go
+var indexerRing atomic.Value
+
+var feedStoreKey = "crawler.feeds"
 
 func InitCron() {
 	initDone := make(chan struct{})
@@ -53,17 +63,7 @@ func InitCron() {
 	go func() {
 		initialized := false
 		for {
-			if r, err := db.Redis.HGetAll(context.Background(), "indexer.addr").Result(); err == nil {
-				addrList := make([]string, 0, len(r))
-				for addr, heartbeatTime := range r {
-					t, _ := strconv.Atoi(heartbeatTime)
-					// 40秒内认为存活
-					if time.Now().Unix()-int64(t) < 40 {
-						addrList = append(addrList, addr)
-					}
-				}
-				indexerAddrList.Store(addrList)
-			} else {
+			if err := refreshIndexerRing(); err != nil {
 				log.Println("获取索引服务器地址失败：" + err.Error())
 			}
 			if !initialized {
@@ -90,11 +90,18 @@ func (e *Engine) startCrawlerGoroutine() {
 					continue
 				}
 
-				// 获取下一个 URL 并下载，优先从 seedUrlChan 获取
+				// 获取下一个 URL 并下载，优先从 seedUrlChan 获取，其次是 priorityUrlChan
 				select {
 				case u = <-e.SeedUrlChan:
 				default:
-					u = <-e.urlChan[num]
+					select {
+					case u = <-e.priorityUrlChan[num]:
+					default:
+						select {
+						case u = <-e.priorityUrlChan[num]:
+						case u = <-e.urlChan[num]:
+						}
+					}
 				}
 				document, err := e.downloader.DownloadText(u)
 				if err != nil {
@@ -104,9 +111,11 @@ func (e *Engine) startCrawlerGoroutine() {
 
 				// 发送document，从网页中提取出 URL、过滤，然后交给调度器
 				atomic.AddInt32(&e.CrawledCount, 1)
-				SendDocument(u, document)
 				urls := ExtractUrls(u, document)
-				urls = e.filterUrl(urls)
+				SendDocument(u, document, outLinks(u, urls))
+				urls, checked := e.filterUrl(urls)
+				SendAnchors(u, e.filterAnchors(ExtractAnchors(u, document), checked))
+				e.discoverFeeds(u, document)
 				// 打散 url 列表，使各个 crawler goroutine 更加均衡
 				util.ShuffleStringSlice(urls)
 				e.urlGroupChan <- urlGroup{leader: u, members: urls}
@@ -118,24 +127,58 @@ func (e *Engine) startCrawlerGoroutine() {
 	}
 }
 
-// 过滤 URL，如：robots.txt禁止爬的，手动添加的不爬的URL，已经爬过的 URL
-func (e *Engine) filterUrl(urls []string) []string {
+// 过滤 URL，如：robots.txt禁止爬的，手动添加的不爬的URL，已经爬过的 URL，爬虫陷阱。
+// 另外返回检查过的 URL（去掉会话 ID）是否通过了 robots.txt 和爬虫陷阱的检查
+func (e *Engine) filterUrl(urls []string) ([]string, map[string]bool) {
 	var filterResult []string
+	checked := make(map[string]bool, len(urls))
 
 	for _, u := range urls {
+		parsedUrl, err := url.Parse(u)
+		if err != nil {
+			continue
+		}
 		// robots
-		if !Allow(u, config.Get().Useragent) {
+		allowed := Allow(u, config.Get().Useragent)
+		// 去掉会话 ID 后再判重
+		u = canonicalizeUrl(parsedUrl)
+		if !allowed {
+			checked[u] = false
 			continue
 		}
-		// bloomFilter
+		// bloomFilter，陷阱 URL 不会加入布隆过滤器，已经爬过的 URL 之前通过了陷阱检查
 		if e.bloomFilter.has(u) {
+			checked[u] = true
 			continue
 		}
+		// 陷阱 URL 不加入布隆过滤器，避免其填满布隆过滤器
+		if reason := e.trapDetector.check(u, parsedUrl); reason != "" {
+			checked[u] = false
+			continue
+		}
+		checked[u] = true
 		e.bloomFilter.add(u)
 		// 允许爬取
 		filterResult = append(filterResult, u)
 	}
-	return filterResult
+	return filterResult, checked
+}
+
+// 锚文本的目标也要过滤 robots.txt 禁止的和爬虫陷阱，否则索引中会多出很多只有锚文本的陷阱页面；
+// 已经爬过的目标不过滤。checked 是 filterUrl 的检查结果，检查过的不再检查，避免重复计入陷阱的统计
+func (e *Engine) filterAnchors(anchors map[string]string, checked map[string]bool) map[string]string {
+	result := make(map[string]string, len(anchors))
+	for target, text := range anchors {
+		ok, found := checked[target]
+		if !found {
+			parsedUrl, err := url.Parse(target)
+			ok = err == nil && Allow(target, config.Get().Useragent) && e.trapDetector.check(target, parsedUrl) == ""
+		}
+		if ok {
+			result[target] = text
+		}
+	}
+	return result
 }
 
 func (e *Engine) crawlerWait() {
@@ -177,14 +220,29 @@ func (e *Engine) startSchedulerGoroutine() {
 				}
 				u := e.scheduler.Front()
 				to := e.getHostIpHash(u) % e.goroutineCount
+				ch := e.urlChan[to]
+				if e.scheduler.FrontFresh() || urlPriority(u) > config.DefaultPriority {
+					ch = e.priorityUrlChan[to]
+				}
 				select {
-				case e.urlChan[to] <- u:
+				case ch <- u:
 					e.scheduler.Poll()
 				case <-time.After(util.Int64ToMillisecond(config.Get().Interval + config.Get().Timeout)):
 					urlChanFull = true
 				}
 			}
 
+			// freshUrls <- freshUrlChan
+			freshUrlChanEmpty := false
+			for !freshUrlChanEmpty {
+				select {
+				case freshUrls := <-e.freshUrlChan:
+					e.scheduler.OfferFresh(freshUrls)
+				default:
+					freshUrlChanEmpty = true
+				}
+			}
+
 			// urlGroup <- urlGroupChan
 			urlGroupChanEmpty := false
 			for !urlGroupChanEmpty {
@@ -230,30 +288,42 @@ func (e *Engine) getHostIpHash(rawUrl string) int {
 	return h
 }
 
+// 被判定为爬虫陷阱的 URL 最多的 n 个站点
+func (e *Engine) TrapHosts(n int) []TrapHost {
+	return e.trapDetector.topHosts(n)
+}
+
 // 运行爬虫
 func (e *Engine) Run() {
 	e.startSchedulerGoroutine()
 	e.startCrawlerGoroutine()
+	e.startFeedGoroutine()
 }
 
 func NewCrawlerEngine(sch Scheduler, dl Downloader, bf BloomFilter, goCount int, seedUrls []string) *Engine {
 	var chanList = make([]chan string, goCount)
+	var priorityChanList = make([]chan string, goCount)
 	for i := 0; i < goCount; i++ {
+		priorityChanList[i] = make(chan string, 1000)
 		// 大容量的 buffered channel 是为了能让 crawler goroutine 都能有事干，
 		// 如果是 unbuffered channel，由于 url 过于集中，连续很多都是同一个网站的 url，
 		// 造成其他网站的 url 得不到爬取，很多协程都处于空闲状态
 		chanList[i] = make(chan string, 10000)
 	}
 	engine := &Engine{
-		scheduler:      sch,
-		downloader:     dl,
-		bloomFilter:    bf,
-		goroutineCount: goCount,
-		seedUrls:       seedUrls,
-		SeedUrlChan:    make(chan string),
-		urlChan:        chanList,
-		urlGroupChan:   make(chan urlGroup, goCount*100),
-		Birthday:       time.Now().Unix(),
+		scheduler:       sch,
+		downloader:      dl,
+		bloomFilter:     bf,
+		trapDetector:    newTrapDetector(),
+		goroutineCount:  goCount,
+		seedUrls:        seedUrls,
+		SeedUrlChan:     make(chan string),
+		urlChan:         chanList,
+		priorityUrlChan: priorityChanList,
+		urlGroupChan:    make(chan urlGroup, goCount*100),
+		feeds:           db.NewFeedStore(feedStoreKey),
+		freshUrlChan:    make(chan []string, 100),
+		Birthday:        time.Now().Unix(),
 	}
 	return engine
 }
@@ -0,0 +1,190 @@
+// RSS/Atom 订阅源的发现和轮询，订阅源中新发布的文章会排在普通链接前面抓取
+package core
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"net/url"
+	"regexp"
+	"search-engine/crawler/config"
+	"strings"
+	"time"
+)
+
+var (
+	linkTagPattern  = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	tagAttrPattern  = regexp.MustCompile(`(?is)([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	feedContentType = map[string]struct{}{
+		"application/rss+xml":  {},
+		"application/atom+xml": {},
+	}
+)
+
+// 每轮最多轮询的订阅源数量
+const feedPollBatch = 20
+
+// 提取网页中 <link rel="alternate" type="application/rss+xml" href="..."> 声明的订阅源
+func ExtractFeeds(rootUrl, document string) []string {
+	base, err := url.Parse(rootUrl)
+	if err != nil {
+		return nil
+	}
+	var feeds []string
+	for _, tag := range linkTagPattern.FindAllString(document, -1) {
+		attrs := make(map[string]string)
+		for _, m := range tagAttrPattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+		}
+		if !strings.Contains(strings.ToLower(attrs["rel"]), "alternate") {
+			continue
+		}
+		if _, ok := feedContentType[strings.ToLower(strings.TrimSpace(attrs["type"]))]; !ok {
+			continue
+		}
+		if feed := resolveUrl(base, attrs["href"]); feed != "" {
+			feeds = append(feeds, feed)
+		}
+	}
+	return feeds
+}
+
+func resolveUrl(base *url.URL, ref string) string {
+	ref = trimFragment(strings.TrimSpace(ref))
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+// RSS 2.0、RSS 1.0（RDF）、Atom 中和文章链接有关的部分
+type feedDocument struct {
+	// RSS 2.0
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	// RSS 1.0 的 item 和 channel 同级
+	Items []rssItem `xml:"item"`
+	// Atom
+	Entries []struct {
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+type rssItem struct {
+	Link string `xml:"link"`
+	Guid struct {
+		Value       string `xml:",chardata"`
+		IsPermaLink string `xml:"isPermaLink,attr"`
+	} `xml:"guid"`
+}
+
+// 解析订阅源，返回其中文章的 URL
+func ParseFeedItems(feedUrl, document string) ([]string, error) {
+	base, err := url.Parse(feedUrl)
+	if err != nil {
+		return nil, err
+	}
+	decoder := xml.NewDecoder(strings.NewReader(document))
+	decoder.Strict = false
+	// 下载时已经转换成了 utf-8，忽略 xml 声明中的编码
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	feed := new(feedDocument)
+	if err = decoder.Decode(feed); err != nil {
+		return nil, err
+	}
+
+	var items []string
+	for _, item := range append(feed.Channel.Items, feed.Items...) {
+		link := item.Link
+		// 没有 link 时，isPermaLink 不为 false 的 guid 就是文章的链接
+		if strings.TrimSpace(link) == "" && item.Guid.IsPermaLink != "false" {
+			link = item.Guid.Value
+		}
+		if u := resolveUrl(base, link); u != "" {
+			items = append(items, u)
+		}
+	}
+	for _, entry := range feed.Entries {
+		for _, link := range entry.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				if u := resolveUrl(base, link.Href); u != "" {
+					items = append(items, u)
+				}
+				break
+			}
+		}
+	}
+	return items, nil
+}
+
+// 登记网页中发现的订阅源
+func (e *Engine) discoverFeeds(u, document string) {
+	now := time.Now().Unix()
+	for _, feed := range ExtractFeeds(u, document) {
+		if err := e.feeds.Add(feed, now); err != nil {
+			log.Println("登记订阅源失败", feed, err)
+		}
+	}
+}
+
+// 已登记的订阅源数量
+func (e *Engine) FeedCount() int64 {
+	count, _ := e.feeds.Count()
+	return count
+}
+
+// 定时轮询到期的订阅源，没有抓取过的文章交给调度器优先抓取
+func (e *Engine) startFeedGoroutine() {
+	go func() {
+		defer e.fallback()
+		for {
+			time.Sleep(time.Second * 10)
+			conf := config.Get()
+			if conf.Suspend || conf.FeedPollInterval <= 0 {
+				continue
+			}
+			now := time.Now().Unix()
+			feeds, err := e.feeds.Due(now, now+conf.FeedPollInterval, feedPollBatch)
+			if err != nil {
+				log.Println("获取待轮询的订阅源失败", err)
+				continue
+			}
+			for _, feed := range feeds {
+				document, err := e.downloader.DownloadFeed(feed)
+				if err != nil {
+					continue
+				}
+				items, err := ParseFeedItems(feed, document)
+				if err != nil {
+					log.Println("解析订阅源失败", feed, err)
+					continue
+				}
+				// 布隆过滤器会过滤掉已经抓取过的文章，剩下的就是新发布的
+				if items, _ = e.filterUrl(items); len(items) > 0 {
+					e.freshUrlChan <- items
+				}
+			}
+		}
+	}()
+}
+
+// 判断下载的内容是不是订阅源，用于 DownloadFeed
+func isFeedDocument(document string) bool {
+	head := document
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	head = strings.ToLower(head)
+	return strings.Contains(head, "<rss") || strings.Contains(head, "<feed") ||
+		strings.Contains(head, "<rdf:rdf")
+}
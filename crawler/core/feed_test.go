@@ -0,0 +1,48 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractFeeds(t *testing.T) {
+	document := `<html><head>
+<link rel="stylesheet" href="/style.css">
+<link rel="alternate" type="application/rss+xml" title="RSS" href="/feed.xml">
+<link href='https://blog.example.com/atom' type='application/atom+xml' rel='alternate'>
+<link rel="alternate" hreflang="en" href="/en/">
+</head></html>`
+	feeds := ExtractFeeds("http://www.example.com/news/index.html", document)
+	want := []string{"http://www.example.com/feed.xml", "https://blog.example.com/atom"}
+	if !reflect.DeepEqual(feeds, want) {
+		t.Error(feeds)
+	}
+}
+
+func TestParseFeedItems(t *testing.T) {
+	rss := `<?xml version="1.0" encoding="gbk"?>
+<rss version="2.0"><channel>
+<title>新闻</title>
+<item><title>a</title><link>http://www.example.com/a.html</link></item>
+<item><title>b</title><link>/b.html</link></item>
+<item><title>c</title><guid>http://www.example.com/c.html</guid></item>
+<item><title>d</title><guid isPermaLink="false">d-id</guid></item>
+</channel></rss>`
+	items, err := ParseFeedItems("http://www.example.com/feed.xml", rss)
+	want := []string{"http://www.example.com/a.html", "http://www.example.com/b.html", "http://www.example.com/c.html"}
+	if err != nil || !reflect.DeepEqual(items, want) {
+		t.Error(items, err)
+	}
+
+	atom := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<link href="http://blog.example.com/atom" rel="self"/>
+<entry><link rel="edit" href="/edit/1"/><link href="http://blog.example.com/1"/></entry>
+<entry><link rel="alternate" href="2"/></entry>
+</feed>`
+	items, err = ParseFeedItems("http://blog.example.com/atom", atom)
+	want = []string{"http://blog.example.com/1", "http://blog.example.com/2"}
+	if err != nil || !reflect.DeepEqual(items, want) {
+		t.Error(items, err)
+	}
+}
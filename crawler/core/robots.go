@@ -134,9 +134,14 @@ func getRobot(parsedUrl *url.URL, useragent string) *robots {
 	return robotsMap[parsedUrl.Host]
 }
 
+// 暂时关闭 robots.txt 检查
+var robotsEnabled = false
+
 // 判断是否允许爬取 path
 func Allow(rawUrl, useragent string) bool {
-	return true
+	if !robotsEnabled {
+		return true
+	}
 	parsedUrl, err := url.Parse(rawUrl)
 	if err != nil {
 		// rawUrl 格式错误，就没必要访问了
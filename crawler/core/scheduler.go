@@ -3,11 +3,12 @@ package core
 import (
 	"container/heap"
 	"container/list"
-	"context"
-	"github.com/go-redis/redis/v8"
+	"fmt"
 	"log"
+	"net/url"
 	"search-engine/crawler/config"
 	"search-engine/crawler/db"
+	"time"
 )
 
 // Scheduler 表示爬虫的抓取 URL 的调度策略
@@ -17,45 +18,106 @@ type Scheduler interface {
 	Front() string
 	Empty() bool
 	AddSeedUrls([]string)
+	// 添加订阅源中新发布的 URL，这些 URL 排在普通 URL 前面
+	OfferFresh(urls []string)
+	// Front() 返回的 URL 是否是 OfferFresh 添加的
+	FrontFresh() bool
 }
 
-// Breath first
+// 获取 URL 所属域名的优先级
+func urlPriority(rawUrl string) int {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return config.DefaultPriority
+	}
+	return config.Get().Priority(parsedUrl.Hostname())
+}
+
+// Breath first，按域名优先级加权
+// 每个优先级一个队列，使用加权轮询（stride scheduling）选择队列：
+// 每个队列有一个虚拟时间，取出一个 URL 后增加 1/优先级，每次选择虚拟时间最小的非空队列，
+// 所以优先级为 p 的队列被选中的次数是优先级为 1 的队列的 p 倍
 type BFScheduler struct {
-	queue *list.List
+	// 订阅源中新发布的 URL，不参与加权轮询，总是最先取出
+	freshQueue *list.List
+	// 下标为优先级
+	queues [config.MaxPriority + 1]*list.List
+	pass   [config.MaxPriority + 1]float64
+	// 最近一次取出 URL 的队列的虚拟时间
+	now float64
+}
+
+// 选择下一个要取出 URL 的队列，虚拟时间相同时优先级高的优先
+func (b *BFScheduler) pick() int {
+	best := -1
+	for p := config.MaxPriority; p >= config.DefaultPriority; p-- {
+		if b.queues[p].Len() > 0 && (best == -1 || b.pass[p] < b.pass[best]) {
+			best = p
+		}
+	}
+	return best
+}
+
+func (b *BFScheduler) push(url string) {
+	p := urlPriority(url)
+	// 队列由空变为非空时，虚拟时间不能落后太多，否则会连续取出很多这个队列中的 URL
+	if b.queues[p].Len() == 0 && b.pass[p] < b.now {
+		b.pass[p] = b.now
+	}
+	b.queues[p].PushBack(url)
 }
 
 func (b *BFScheduler) Poll() string {
-	e := b.queue.Front()
-	url := b.queue.Remove(e).(string)
+	if b.freshQueue.Len() > 0 {
+		return b.freshQueue.Remove(b.freshQueue.Front()).(string)
+	}
+	p := b.pick()
+	url := b.queues[p].Remove(b.queues[p].Front()).(string)
+	b.now = b.pass[p]
+	b.pass[p] += 1 / float64(p)
 	return url
 }
 
 func (b *BFScheduler) Offer(group urlGroup) {
 	for _, url := range group.members {
-		b.queue.PushBack(url)
+		b.push(url)
 	}
 }
 
 func (b *BFScheduler) Front() string {
-	return b.queue.Front().Value.(string)
+	if b.freshQueue.Len() > 0 {
+		return b.freshQueue.Front().Value.(string)
+	}
+	return b.queues[b.pick()].Front().Value.(string)
 }
 
 func (b *BFScheduler) Empty() bool {
-	return b.queue.Len() == 0
+	return b.freshQueue.Len() == 0 && b.pick() == -1
+}
+
+func (b *BFScheduler) OfferFresh(urls []string) {
+	for _, url := range urls {
+		b.freshQueue.PushBack(url)
+	}
+}
+
+func (b *BFScheduler) FrontFresh() bool {
+	return b.freshQueue.Len() > 0
 }
 
 func (b *BFScheduler) AddSeedUrls(seedUrls []string) {
 	for _, seedUrl := range seedUrls {
 		// 初始化种子 url 的 robots.txt
 		if Allow(seedUrl, config.Get().Useragent) {
-			b.queue.PushBack(seedUrl)
+			b.push(seedUrl)
 		}
 	}
 }
 
 func NewBFScheduler() Scheduler {
-	scheduler := &BFScheduler{
-		queue: list.New(),
+	scheduler := &BFScheduler{freshQueue: list.New()}
+	for p := range scheduler.queues {
+		scheduler.queues[p] = list.New()
 	}
 	return scheduler
 }
@@ -92,7 +154,9 @@ func (p *priorityQueue) Pop() interface{} {
 // Online page importance computation
 // 规定每个链接初始 cash 值为 1
 type OPICScheduler struct {
-	pq priorityQueue
+	// 订阅源中新发布的 URL，总是最先取出
+	freshQueue *list.List
+	pq         priorityQueue
 	// 保存 scheduler 对应的 cash，为了节省内存，
 	// 下载完某个 URL 对应的页面后，要删除 cashMap 中的 k、v
 	cashMap map[string]float32
@@ -111,6 +175,9 @@ func (o *OPICScheduler) Offer(group urlGroup) {
 }
 
 func (o *OPICScheduler) Poll() string {
+	if o.freshQueue.Len() > 0 {
+		return o.freshQueue.Remove(o.freshQueue.Front()).(string)
+	}
 	url := o.pq.Pop().(string)
 	// Offer scheduler 对应的 urlGroup 的时候再删除，因为还需要 scheduler 的 value
 	//delete(o.pq.cashMap, scheduler)
@@ -118,11 +185,24 @@ func (o *OPICScheduler) Poll() string {
 }
 
 func (o *OPICScheduler) Front() string {
+	if o.freshQueue.Len() > 0 {
+		return o.freshQueue.Front().Value.(string)
+	}
 	return o.pq.array[0]
 }
 
 func (o *OPICScheduler) Empty() bool {
-	return o.pq.Len() == 0
+	return o.freshQueue.Len() == 0 && o.pq.Len() == 0
+}
+
+func (o *OPICScheduler) OfferFresh(urls []string) {
+	for _, url := range urls {
+		o.freshQueue.PushBack(url)
+	}
+}
+
+func (o *OPICScheduler) FrontFresh() bool {
+	return o.freshQueue.Len() > 0
 }
 
 func (o *OPICScheduler) AddSeedUrls(seedUrls []string) {
@@ -134,7 +214,8 @@ func (o *OPICScheduler) AddSeedUrls(seedUrls []string) {
 
 func NewOPICScheduler() Scheduler {
 	opic := &OPICScheduler{
-		cashMap: make(map[string]float32, 10000),
+		freshQueue: list.New(),
+		cashMap:    make(map[string]float32, 10000),
 		pq: priorityQueue{
 			array: make([]string, 10000),
 		},
@@ -146,81 +227,159 @@ func NewOPICScheduler() Scheduler {
 
 /////////////////// 简单的分布式调度 //////////////////////
 
+// 每个优先级对应一个共享队列（优先级通道），从共享队列获取 URL 时，
+// 各通道按优先级加权分配数量，高优先级通道没有 URL 时由其他通道补足
 type DistributedScheduler struct {
 	localQueue *list.List
-	redis      *redis.Client
+	// 下标为优先级
+	lanes [config.MaxPriority + 1]db.Frontier
+	// 订阅源中新发布的 URL 使用单独的共享队列，总是最先获取
+	freshLane       db.Frontier
+	localFreshQueue *list.List
+	// 上次从共享队列获取新发布的 URL 的时间，本地队列不为空时每秒最多检查一次
+	lastFreshFetch time.Time
 }
 
 var (
-	ctx          = context.Background()
-	distQueueKey = "dist_url_queue"
+	distQueueKey      = "dist_url_queue"
+	distFreshQueueKey = "dist_url_queue:fresh"
 )
 
-func (d *DistributedScheduler) fetch() {
-	var result []*redis.StringCmd
-	pipeline := d.redis.Pipeline()
-	// 每次最多100个
-	for i := 0; i < 100; i++ {
-		result = append(result, pipeline.LPop(ctx, distQueueKey))
+// 每次从共享队列获取的 URL 数量
+const distFetchCount = 100
+
+// 默认优先级使用原来的队列，其他优先级的队列加上优先级后缀
+func laneKey(priority int) string {
+	if priority == config.DefaultPriority {
+		return distQueueKey
 	}
-	if _, err := pipeline.Exec(ctx); err != nil && err != redis.Nil {
+	return fmt.Sprintf("%s:%d", distQueueKey, priority)
+}
+
+func (d *DistributedScheduler) fetch() {
+	d.lastFreshFetch = time.Now()
+	freshUrls, err := d.freshLane.Pop(distFetchCount)
+	if err != nil {
 		log.Println("从 redis 队列获取 url 时发生错误", err)
 	}
-	for _, r := range result {
-		if r.Err() == nil {
-			d.localQueue.PushBack(r.Val())
+	for _, u := range freshUrls {
+		d.localFreshQueue.PushBack(u)
+	}
+	if d.localQueue.Len() != 0 {
+		return
+	}
+
+	totalWeight := 0
+	for p := config.DefaultPriority; p <= config.MaxPriority; p++ {
+		totalWeight += p
+	}
+	fetched := 0
+	popLane := func(p, n int) {
+		urls, err := d.lanes[p].Pop(n)
+		if err != nil {
+			log.Println("从 redis 队列获取 url 时发生错误", err)
+		}
+		for _, u := range urls {
+			d.localQueue.PushBack(u)
+		}
+		fetched += len(urls)
+	}
+	// 按权重分配，优先级高的先放入本地队列
+	for p := config.MaxPriority; p >= config.DefaultPriority && fetched < distFetchCount; p-- {
+		quota := (distFetchCount*p + totalWeight - 1) / totalWeight
+		if quota > distFetchCount-fetched {
+			quota = distFetchCount - fetched
 		}
+		popLane(p, quota)
+	}
+	// 补足剩余的数量
+	for p := config.MaxPriority; p >= config.DefaultPriority && fetched < distFetchCount; p-- {
+		popLane(p, distFetchCount-fetched)
 	}
 }
 
-func (d *DistributedScheduler) Offer(group urlGroup) {
-	var urlList []interface{}
-	for _, u := range group.members {
-		urlList = append(urlList, u)
+// 按优先级将 URL 发送到对应的通道
+func (d *DistributedScheduler) push(urls []string) error {
+	var laneUrls [config.MaxPriority + 1][]string
+	for _, u := range urls {
+		p := urlPriority(u)
+		laneUrls[p] = append(laneUrls[p], u)
+	}
+	for p, urls := range laneUrls {
+		if len(urls) == 0 {
+			continue
+		}
+		if err := d.lanes[p].Push(urls); err != nil {
+			return err
+		}
 	}
-	if d.redis.RPush(ctx, distQueueKey, urlList).Err() != nil {
+	return nil
+}
+
+func (d *DistributedScheduler) Offer(group urlGroup) {
+	if d.push(group.members) != nil {
 		log.Println("发送 urlList 到 redis 队列时发生错误")
 	}
 }
 
 func (d *DistributedScheduler) Poll() string {
-	if d.localQueue.Len() == 0 {
+	if d.localFreshQueue.Len() == 0 && d.localQueue.Len() == 0 {
 		d.fetch()
 	}
+	if d.localFreshQueue.Len() > 0 {
+		return d.localFreshQueue.Remove(d.localFreshQueue.Front()).(string)
+	}
 	e := d.localQueue.Front()
 	url := d.localQueue.Remove(e).(string)
 	return url
 }
 
 func (d *DistributedScheduler) Front() string {
+	if d.localFreshQueue.Len() > 0 {
+		return d.localFreshQueue.Front().Value.(string)
+	}
 	return d.localQueue.Front().Value.(string)
 }
 
+// 本地队列为空时从共享队列获取，同时也借此检查有没有新发布的 URL
 func (d *DistributedScheduler) Empty() bool {
-	if d.localQueue.Len() != 0 {
-		return false
+	if d.localFreshQueue.Len() == 0 && (d.localQueue.Len() == 0 || time.Since(d.lastFreshFetch) > time.Second) {
+		d.fetch()
 	}
-	d.fetch()
-	return d.localQueue.Len() == 0
+	return d.localFreshQueue.Len() == 0 && d.localQueue.Len() == 0
+}
+
+func (d *DistributedScheduler) OfferFresh(urls []string) {
+	if d.freshLane.Push(urls) != nil {
+		log.Println("发送 urlList 到 redis 队列时发生错误")
+	}
+}
+
+func (d *DistributedScheduler) FrontFresh() bool {
+	return d.localFreshQueue.Len() > 0
 }
 
 func (d *DistributedScheduler) AddSeedUrls(seedUrls []string) {
-	var urlList []interface{}
+	var urlList []string
 	for _, seedUrl := range seedUrls {
 		// 初始化种子 url 的 robots.txt
 		if Allow(seedUrl, config.Get().Useragent) {
 			urlList = append(urlList, seedUrl)
 		}
 	}
-	if d.redis.RPush(ctx, distQueueKey, urlList...).Err() != nil {
+	if d.push(urlList) != nil {
 		log.Fatalln("添加种子 URL 失败")
 	}
 }
 
 func NewDistributedScheduler() Scheduler {
 	scheduler := &DistributedScheduler{
-		localQueue: list.New(),
-		redis:      db.Redis,
+		localQueue:      list.New(),
+		freshLane:       db.NewFrontier(distFreshQueueKey),
+		localFreshQueue: list.New(),
+	}
+	for p := config.DefaultPriority; p <= config.MaxPriority; p++ {
+		scheduler.lanes[p] = db.NewFrontier(laneKey(p))
 	}
 	return scheduler
 }
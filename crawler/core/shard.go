@@ -0,0 +1,127 @@
+// 分片：文档按 URL 的一致性哈希发送到固定的分片，规则和索引服务器的 core/shard.go 一致。
+// 文档发送给分片的所有副本，正在下线的索引服务器（indexer.draining）不再写入，所有副本都在下线的分片不再分配 URL
+package core
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"search-engine/crawler/db"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const virtualNodeCount = 160 // 每个索引服务器的虚拟节点数量，要和索引服务器的一致
+
+// 一致性哈希环
+type hashRing struct {
+	hashes []uint32
+	nodes  map[uint32]string
+}
+
+func newHashRing(addrs []string) *hashRing {
+	r := &hashRing{nodes: make(map[uint32]string, len(addrs)*virtualNodeCount)}
+	// 排序后哈希冲突时的结果与地址的顺序无关
+	addrs = append([]string(nil), addrs...)
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		for i := 0; i < virtualNodeCount; i++ {
+			h := ringHash(addr + "#" + strconv.Itoa(i))
+			if _, ok := r.nodes[h]; !ok {
+				r.nodes[h] = addr
+				r.hashes = append(r.hashes, h)
+			}
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+	return r
+}
+
+func ringHash(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// URL 所属的索引服务器，环为空时返回空字符串
+func (r *hashRing) get(url string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := ringHash(url)
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
+
+// 索引服务器的分片拓扑
+type indexerShards struct {
+	ring     *hashRing
+	replicas map[string][]string // 分片名 -> 要写入的副本
+}
+
+// 根据注册中心重建分片拓扑。没有登记分片的索引服务器单独是一个分片，分片名就是地址
+func refreshIndexerRing() error {
+	alive := func(service string) (map[string]bool, error) {
+		r, err := db.Registry.List(service)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make(map[string]bool, len(r))
+		for addr, heartbeatTime := range r {
+			// 40秒内认为存活
+			if time.Now().Unix()-heartbeatTime < 40 {
+				addrs[addr] = true
+			}
+		}
+		return addrs, nil
+	}
+	indexers, err := alive("indexer.addr")
+	if err != nil {
+		return err
+	}
+	draining, err := alive("indexer.draining")
+	if err != nil {
+		return err
+	}
+	names, err := alive("indexer.shards")
+	if err != nil {
+		return err
+	}
+	shards := &indexerShards{replicas: make(map[string][]string)}
+	grouped := make(map[string]bool)
+	add := func(shard, addr string) {
+		grouped[addr] = true
+		if !draining[addr] {
+			shards.replicas[shard] = append(shards.replicas[shard], addr)
+		}
+	}
+	for name := range names {
+		members, err := alive("indexer.shard:" + name)
+		if err != nil {
+			return err
+		}
+		for addr := range members {
+			if indexers[addr] && !grouped[addr] {
+				add(name, addr)
+			}
+		}
+	}
+	for addr := range indexers {
+		if !grouped[addr] {
+			add(addr, addr)
+		}
+	}
+	shardList := make([]string, 0, len(shards.replicas))
+	for name := range shards.replicas {
+		shardList = append(shardList, name)
+	}
+	shards.ring = newHashRing(shardList)
+	indexerRing.Store(shards)
+	return nil
+}
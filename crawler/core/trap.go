@@ -0,0 +1,220 @@
+// 爬虫陷阱检测，防止无限的 URL 空间（无限翻页的日历、路径中的会话 ID、
+// 重复的路径片段、程序生成的查询参数等）填满 URL 队列和布隆过滤器
+package core
+
+import (
+	"hash/fnv"
+	"net/url"
+	"regexp"
+	"search-engine/crawler/config"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 陷阱类型
+const (
+	trapUrlTooLong       = "url_too_long"
+	trapRepeatedSegments = "repeated_segments"
+	trapParamValues      = "param_values"
+	trapPatternBudget    = "pattern_budget"
+)
+
+var (
+	// 路径中的会话 ID，如 /a;jsessionid=xxx
+	pathSessionPattern = regexp.MustCompile(`(?i);(jsessionid|phpsessid|sid|sessionid)=[^/?]*`)
+	// 常见的会话 ID 参数名
+	sessionParams = map[string]struct{}{
+		"jsessionid": {}, "phpsessid": {}, "sid": {}, "sessionid": {}, "session_id": {}, "sessid": {},
+	}
+	digitsPattern = regexp.MustCompile(`\d+`)
+	// 较长的十六进制串或字母数字混合串，一般是 ID、哈希
+	idPattern = regexp.MustCompile(`^[0-9a-fA-F]{16,}$|^[0-9A-Za-z_\-]{24,}$`)
+)
+
+// 被判定为陷阱的站点
+type TrapHost struct {
+	Host   string         `json:"host"`
+	Count  int            `json:"count"`  // 被拦截的 URL 数量
+	Reason map[string]int `json:"reason"` // 陷阱类型->次数
+}
+
+type trapDetector struct {
+	// host -> 参数名 -> 不同取值的哈希集合
+	paramValues map[string]map[string]map[uint64]struct{}
+	// host + 路径模式 -> 已放行的 URL 数量
+	patternCount map[string]int
+	flagged      map[string]*TrapHost
+	lock         sync.Mutex
+}
+
+func newTrapDetector() *trapDetector {
+	return &trapDetector{
+		paramValues:  make(map[string]map[string]map[uint64]struct{}),
+		patternCount: make(map[string]int),
+		flagged:      make(map[string]*TrapHost),
+	}
+}
+
+// 去掉 URL 中的会话 ID，使同一个页面只对应一个 URL
+func canonicalizeUrl(parsedUrl *url.URL) string {
+	parsedUrl.Path = pathSessionPattern.ReplaceAllString(parsedUrl.Path, "")
+	parsedUrl.RawPath = ""
+	if parsedUrl.RawQuery != "" {
+		query := parsedUrl.Query()
+		for name := range query {
+			if _, ok := sessionParams[strings.ToLower(name)]; ok {
+				query.Del(name)
+			}
+		}
+		parsedUrl.RawQuery = query.Encode()
+	}
+	return parsedUrl.String()
+}
+
+// 路径中是否有重复出现的片段或片段序列，如 /a/b/a/b/a/b
+func hasRepeatedSegments(segments []string, maxRepeated int) bool {
+	if maxRepeated <= 0 {
+		return false
+	}
+	count := make(map[string]int, len(segments))
+	for _, s := range segments {
+		count[s]++
+		if count[s] > maxRepeated {
+			return true
+		}
+	}
+	// 长度为 p 的片段序列连续重复 maxRepeated 次
+	for p := 2; p*maxRepeated <= len(segments); p++ {
+		for i := 0; i+p*maxRepeated <= len(segments); i++ {
+			repeated := true
+			for j := i + p; j < i+p*maxRepeated && repeated; j++ {
+				repeated = segments[j] == segments[j-p]
+			}
+			if repeated {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 路径模式，数字替换成 {n}，ID 替换成 {id}，再加上排序后的参数名，
+// 如 /calendar/2021/03?day=1 => /calendar/{n}/{n}?day
+func pathPattern(segments []string, query url.Values) string {
+	builder := strings.Builder{}
+	for _, s := range segments {
+		builder.WriteByte('/')
+		if idPattern.MatchString(s) {
+			builder.WriteString("{id}")
+		} else {
+			builder.WriteString(digitsPattern.ReplaceAllString(s, "{n}"))
+		}
+	}
+	if len(query) > 0 {
+		names := make([]string, 0, len(query))
+		for name := range query {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		builder.WriteByte('?')
+		builder.WriteString(strings.Join(names, "&"))
+	}
+	return builder.String()
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// 判断 URL 是否是陷阱，不是则返回 ""，并计入站点的参数取值、路径模式统计
+func (t *trapDetector) check(rawUrl string, parsedUrl *url.URL) string {
+	conf := config.Get()
+	if conf.MaxUrlLength > 0 && len(rawUrl) > conf.MaxUrlLength {
+		return t.flag(parsedUrl.Host, trapUrlTooLong)
+	}
+	segments := strings.FieldsFunc(parsedUrl.Path, func(r rune) bool { return r == '/' })
+	if hasRepeatedSegments(segments, conf.MaxRepeatedSegments) {
+		return t.flag(parsedUrl.Host, trapRepeatedSegments)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	host := parsedUrl.Host
+	query := parsedUrl.Query()
+	// 参数取值：只有新的取值才会受限制，已经出现过的取值不受影响
+	hostParams := t.paramValues[host]
+	if hostParams == nil {
+		hostParams = make(map[string]map[uint64]struct{})
+		t.paramValues[host] = hostParams
+	}
+	newValues := make(map[string][]uint64)
+	for name, values := range query {
+		set := hostParams[name]
+		for _, v := range values {
+			h := hashString(v)
+			if _, ok := set[h]; ok {
+				continue
+			}
+			if conf.MaxParamValues > 0 && len(set) >= conf.MaxParamValues {
+				return t.flagLocked(host, trapParamValues)
+			}
+			newValues[name] = append(newValues[name], h)
+		}
+	}
+	// 路径模式预算
+	pattern := host + pathPattern(segments, query)
+	if conf.MaxPatternUrls > 0 && t.patternCount[pattern] >= conf.MaxPatternUrls {
+		return t.flagLocked(host, trapPatternBudget)
+	}
+	t.patternCount[pattern]++
+	for name, hashes := range newValues {
+		if hostParams[name] == nil {
+			hostParams[name] = make(map[uint64]struct{})
+		}
+		for _, h := range hashes {
+			hostParams[name][h] = struct{}{}
+		}
+	}
+	return ""
+}
+
+func (t *trapDetector) flag(host, reason string) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.flagLocked(host, reason)
+}
+
+func (t *trapDetector) flagLocked(host, reason string) string {
+	info, ok := t.flagged[host]
+	if !ok {
+		info = &TrapHost{Host: host, Reason: make(map[string]int)}
+		t.flagged[host] = info
+	}
+	info.Count++
+	info.Reason[reason]++
+	return reason
+}
+
+// 被拦截 URL 最多的 n 个站点
+func (t *trapDetector) topHosts(n int) []TrapHost {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	hosts := make([]TrapHost, 0, len(t.flagged))
+	for _, info := range t.flagged {
+		reason := make(map[string]int, len(info.Reason))
+		for k, v := range info.Reason {
+			reason[k] = v
+		}
+		hosts = append(hosts, TrapHost{Host: info.Host, Count: info.Count, Reason: reason})
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Count > hosts[j].Count
+	})
+	if len(hosts) > n {
+		hosts = hosts[:n]
+	}
+	return hosts
+}
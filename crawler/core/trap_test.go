@@ -0,0 +1,96 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHasRepeatedSegments(t *testing.T) {
+	cases := []struct {
+		path string
+		want bool
+	}{
+		{"/a/b/c", false},
+		{"/a/b/a/b", false},
+		{"/a/b/a/b/a/b", true},
+		{"/x/a/a/a/a", true},
+		{"/2021/03/03", false},
+	}
+	for _, c := range cases {
+		segments := strings.FieldsFunc(c.path, func(r rune) bool { return r == '/' })
+		if got := hasRepeatedSegments(segments, 3); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestCanonicalizeUrl(t *testing.T) {
+	cases := map[string]string{
+		"http://a.com/index.jsp;jsessionid=ABC123?page=1": "http://a.com/index.jsp?page=1",
+		"http://a.com/list?PHPSESSID=abc&page=2":          "http://a.com/list?page=2",
+		"http://a.com/list?page=2":                        "http://a.com/list?page=2",
+	}
+	for raw, want := range cases {
+		parsedUrl, _ := url.Parse(raw)
+		if got := canonicalizeUrl(parsedUrl); got != want {
+			t.Errorf("%s: got %s, want %s", raw, got, want)
+		}
+	}
+}
+
+func TestPathPattern(t *testing.T) {
+	parsedUrl, _ := url.Parse("http://a.com/calendar/2021/03?day=1&month=3")
+	segments := strings.FieldsFunc(parsedUrl.Path, func(r rune) bool { return r == '/' })
+	if got := pathPattern(segments, parsedUrl.Query()); got != "/calendar/{n}/{n}?day&month" {
+		t.Error(got)
+	}
+}
+
+func TestTrapDetector(t *testing.T) {
+	detector := newTrapDetector()
+	check := func(rawUrl string) string {
+		parsedUrl, _ := url.Parse(rawUrl)
+		return detector.check(rawUrl, parsedUrl)
+	}
+	if reason := check("http://a.com/" + strings.Repeat("x", 1000)); reason != trapUrlTooLong {
+		t.Error(reason)
+	}
+	if reason := check("http://a.com/a/b/a/b/a/b"); reason != trapRepeatedSegments {
+		t.Error(reason)
+	}
+	// 无限翻页的日历
+	blocked := 0
+	for i := 0; i < 10000; i++ {
+		if check(fmt.Sprintf("http://b.com/calendar?date=%d", i)) != "" {
+			blocked++
+		}
+	}
+	if blocked == 0 {
+		t.Error("calendar not blocked")
+	}
+	hosts := detector.topHosts(10)
+	if len(hosts) != 2 || hosts[0].Host != "b.com" {
+		t.Error(hosts)
+	}
+}
+
+func TestFilterAnchors(t *testing.T) {
+	e := &Engine{trapDetector: newTrapDetector()}
+	long := "http://a.com/" + strings.Repeat("x", 1000)
+	anchors := map[string]string{
+		"http://a.com/ok":          "正常页面",
+		"http://a.com/denied":      "robots 禁止",
+		"http://a.com/crawled":     "已经爬过",
+		long:                       "太长的 URL",
+		"http://a.com/a/b/a/b/a/b": "重复的路径",
+	}
+	checked := map[string]bool{"http://a.com/denied": false, "http://a.com/crawled": true}
+	got := e.filterAnchors(anchors, checked)
+	want := map[string]string{"http://a.com/ok": "正常页面", "http://a.com/crawled": "已经爬过"}
+	if !reflect.DeepEqual(got, want) {
+		t.Error(got)
+	}
+}
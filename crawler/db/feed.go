@@ -0,0 +1,123 @@
+// 订阅源（RSS/Atom）登记表，记录已发现的订阅源和下次轮询的时间
+package db
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type FeedStore interface {
+	// 登记订阅源，已登记过的不会改变下次轮询的时间
+	Add(feedUrl string, now int64) error
+	// 取出最多 n 个到期（下次轮询时间 <= now）的订阅源，并将它们的下次轮询时间设为 next，
+	// 多个爬虫共享登记表时，同一个订阅源在一个周期内只会被一个爬虫取到
+	Due(now, next int64, n int) ([]string, error)
+	// 已登记的订阅源数量
+	Count() (int64, error)
+}
+
+// 根据存储模式创建订阅源登记表，分布式模式下多个爬虫共享 redis 中的登记表
+func NewFeedStore(key string) FeedStore {
+	if Redis == nil {
+		return NewMemoryFeedStore()
+	}
+	return NewRedisFeedStore(Redis, key)
+}
+
+// 使用 redis 有序集合保存，member 为订阅源 URL，score 为下次轮询的时间
+type redisFeedStore struct {
+	redis   *redis.Client
+	key     string
+	dueSHA1 string
+}
+
+var feedDueLuaScript = `
+local feeds = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "limit", 0, ARGV[3])
+for i, v in ipairs(feeds)
+do
+    redis.call("zadd", KEYS[1], ARGV[2], v)
+end
+return feeds
+`
+
+func NewRedisFeedStore(client *redis.Client, key string) FeedStore {
+	r := client.ScriptLoad(context.Background(), feedDueLuaScript)
+	if r.Err() != nil {
+		panic("加载脚本失败：" + r.Err().Error())
+	}
+	return &redisFeedStore{redis: client, key: key, dueSHA1: r.Val()}
+}
+
+func (r *redisFeedStore) Add(feedUrl string, now int64) error {
+	return r.redis.ZAddNX(context.Background(), r.key, &redis.Z{Score: float64(now), Member: feedUrl}).Err()
+}
+
+func (r *redisFeedStore) Due(now, next int64, n int) ([]string, error) {
+	c := r.redis.EvalSha(context.Background(), r.dueSHA1, []string{r.key},
+		strconv.FormatInt(now, 10), strconv.FormatInt(next, 10), n)
+	if c.Err() != nil && c.Err() != redis.Nil {
+		return nil, c.Err()
+	}
+	values, _ := c.Val().([]interface{})
+	feeds := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			feeds = append(feeds, s)
+		}
+	}
+	return feeds, nil
+}
+
+func (r *redisFeedStore) Count() (int64, error) {
+	return r.redis.ZCard(context.Background(), r.key).Result()
+}
+
+type memoryFeedStore struct {
+	// 订阅源 -> 下次轮询的时间
+	feeds map[string]int64
+	lock  sync.Mutex
+}
+
+func NewMemoryFeedStore() FeedStore {
+	return &memoryFeedStore{feeds: make(map[string]int64)}
+}
+
+func (m *memoryFeedStore) Add(feedUrl string, now int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.feeds[feedUrl]; !ok {
+		m.feeds[feedUrl] = now
+	}
+	return nil
+}
+
+func (m *memoryFeedStore) Due(now, next int64, n int) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var feeds []string
+	for feedUrl, t := range m.feeds {
+		if t <= now {
+			feeds = append(feeds, feedUrl)
+		}
+	}
+	// 和 redis 一样，等待时间最长的先轮询
+	sort.Slice(feeds, func(i, j int) bool {
+		return m.feeds[feeds[i]] < m.feeds[feeds[j]]
+	})
+	if len(feeds) > n {
+		feeds = feeds[:n]
+	}
+	for _, feedUrl := range feeds {
+		m.feeds[feedUrl] = next
+	}
+	return feeds, nil
+}
+
+func (m *memoryFeedStore) Count() (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return int64(len(m.feeds)), nil
+}
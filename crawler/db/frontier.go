@@ -0,0 +1,92 @@
+// 待抓取的 URL 队列
+package db
+
+import (
+	"container/list"
+	"context"
+	"github.com/go-redis/redis/v8"
+	"sync"
+)
+
+type Frontier interface {
+	Push(urls []string) error
+	// 最多取出 n 个 URL
+	Pop(n int) ([]string, error)
+}
+
+// 根据存储模式创建 URL 队列，分布式模式下多个爬虫共享 redis 中的队列
+func NewFrontier(key string) Frontier {
+	if Redis == nil {
+		return NewMemoryFrontier()
+	}
+	return NewRedisFrontier(Redis, key)
+}
+
+type redisFrontier struct {
+	redis *redis.Client
+	key   string
+}
+
+func NewRedisFrontier(client *redis.Client, key string) Frontier {
+	return &redisFrontier{redis: client, key: key}
+}
+
+func (r *redisFrontier) Push(urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	urlList := make([]interface{}, 0, len(urls))
+	for _, u := range urls {
+		urlList = append(urlList, u)
+	}
+	return r.redis.RPush(context.Background(), r.key, urlList...).Err()
+}
+
+func (r *redisFrontier) Pop(n int) ([]string, error) {
+	ctx := context.Background()
+	var result []*redis.StringCmd
+	pipeline := r.redis.Pipeline()
+	defer pipeline.Close()
+	for i := 0; i < n; i++ {
+		result = append(result, pipeline.LPop(ctx, r.key))
+	}
+	_, err := pipeline.Exec(ctx)
+	if err == redis.Nil {
+		err = nil
+	}
+	urls := make([]string, 0, n)
+	for _, r := range result {
+		if r.Err() == nil {
+			urls = append(urls, r.Val())
+		}
+	}
+	return urls, err
+}
+
+type memoryFrontier struct {
+	queue *list.List
+	lock  sync.Mutex
+}
+
+func NewMemoryFrontier() Frontier {
+	return &memoryFrontier{queue: list.New()}
+}
+
+func (m *memoryFrontier) Push(urls []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, u := range urls {
+		m.queue.PushBack(u)
+	}
+	return nil
+}
+
+func (m *memoryFrontier) Pop(n int) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	urls := make([]string, 0, n)
+	for i := 0; i < n && m.queue.Len() > 0; i++ {
+		urls = append(urls, m.queue.Remove(m.queue.Front()).(string))
+	}
+	return urls, nil
+}
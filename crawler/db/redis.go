@@ -3,9 +3,27 @@ package db
 import (
 	"github.com/go-redis/redis/v8"
 	"search-engine/crawler/config"
+	"strings"
 )
 
-var Redis = NewRedis()
+// 分布式模式下使用，嵌入模式下为 nil
+var Redis *redis.Client
+
+func init() {
+	if config.Embedded() {
+		// 嵌入模式下单独部署时，通过配置指定索引服务器地址
+		registry := NewMemoryRegistry()
+		for _, addr := range strings.Split(config.GetLocal("embedded.indexerAddrs"), ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				registry.AddStatic("indexer.addr", addr)
+			}
+		}
+		Registry = registry
+		return
+	}
+	Redis = NewRedis()
+	Registry = NewRedisRegistry(Redis)
+}
 
 func NewRedis() *redis.Client {
 	rdb := redis.NewClient(&redis.Options{
@@ -4,6 +4,7 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"os"
 	"search-engine/index/config"
 	"search-engine/index/core"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -21,6 +23,19 @@ var engine *core.Engine
 const (
 	codeSuccess = iota
 	codeFail
+	codeQueryError // 查询语法错误，data 为 core.QueryError
+)
+
+// 检索参数的默认值和限制，offset+limit 越大，检索时堆越大
+const (
+	defaultSearchLimit = 50
+	maxSearchWindow    = 1000 // offset+limit 的最大值
+)
+
+// 获取标题时 limit 的默认值和最大值
+const (
+	defaultTitleLimit = 10000
+	maxTitleLimit     = 100000
 )
 
 type Response struct {
@@ -38,15 +53,27 @@ type MonitorInfo struct {
 	IndexFileSize   int     `json:"index_size"`
 	IndexedDocCount int     `json:"indexed_doc_count"`
 	TokenCount      int     `json:"token_count"`
+	SegmentCount    int     `json:"segment_count"`
+	Shard           string  `json:"shard"`
+	Draining        bool    `json:"draining"`
+	Recovering      bool    `json:"recovering"` // 正在从其他副本同步数据
 }
 
-func Serve(listenAddr string) {
+func Serve(listenAddr string) error {
 	engine = core.NewEngine()
-	http.HandleFunc("/search", searchHandler)
-	http.HandleFunc("/index", indexHandler)
-	http.HandleFunc("/monitor", monitor)
-	err := http.ListenAndServe(listenAddr, nil)
-	log.Fatal(err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", searchHandler)
+	mux.HandleFunc("/index", indexHandler)
+	mux.HandleFunc("/monitor", monitor)
+	mux.HandleFunc("/titles", titlesHandler)
+	mux.HandleFunc("/links", linksHandler)
+	mux.HandleFunc("/anchors", anchorsHandler)
+	mux.HandleFunc("/migrate", migrateHandler)
+	mux.HandleFunc("/drain", drainHandler)
+	mux.HandleFunc("/replica/documents", replicaDocumentsHandler)
+	mux.HandleFunc("/backup", backupHandler)
+	mux.HandleFunc("/backups", backupsHandler)
+	return http.ListenAndServe(listenAddr, mux)
 }
 
 func searchHandler(writer http.ResponseWriter, request *http.Request) {
@@ -59,37 +86,241 @@ func searchHandler(writer http.ResponseWriter, request *http.Request) {
 		write(writer, http.StatusBadRequest, &Response{Code: codeFail, Msg: "param error"})
 		return
 	}
+	options, ok := parseSearchOptions(request)
+	if !ok {
+		write(writer, http.StatusBadRequest, &Response{Code: codeFail, Msg: "param error"})
+		return
+	}
 	start := time.Now()
-	searchResults := engine.Search(query)
+	searchResults, err := engine.Search(query, options)
+	if err != nil {
+		if queryError, ok := err.(*core.QueryError); ok {
+			write(writer, http.StatusBadRequest, &Response{Code: codeQueryError, Msg: queryError.Error(), Data: queryError})
+		} else {
+			log.Println(err.Error())
+			write(writer, http.StatusInternalServerError, &Response{Code: codeFail, Msg: "internal server error"})
+		}
+		return
+	}
 	searchResults.Duration = time.Now().Sub(start).Milliseconds()
 	write(writer, http.StatusOK, &Response{Code: codeSuccess, Data: searchResults})
 }
 
+// offset 默认为 0，limit 默认为 defaultSearchLimit，sort 为 score（默认）或 date
+func parseSearchOptions(request *http.Request) (*core.SearchOptions, bool) {
+	options := &core.SearchOptions{Limit: defaultSearchLimit, Sort: core.SortByScore}
+	var err error
+	if offset := request.FormValue("offset"); offset != "" {
+		if options.Offset, err = strconv.Atoi(offset); err != nil {
+			return nil, false
+		}
+	}
+	if limit := request.FormValue("limit"); limit != "" {
+		if options.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, false
+		}
+	}
+	if sortBy := request.FormValue("sort"); sortBy != "" {
+		options.Sort = sortBy
+	}
+	if options.Offset < 0 || options.Limit <= 0 || options.Offset > maxSearchWindow ||
+		options.Limit > maxSearchWindow || options.Offset+options.Limit > maxSearchWindow ||
+		(options.Sort != core.SortByScore && options.Sort != core.SortByDate) {
+		return nil, false
+	}
+	return options, true
+}
+
+// PUT 建立（替换）索引，DELETE 删除索引
 func indexHandler(writer http.ResponseWriter, request *http.Request) {
-	if request.Method != http.MethodPut {
+	switch request.Method {
+	case http.MethodPut:
+		putDocument(writer, request)
+	case http.MethodDelete:
+		deleteDocument(writer, request)
+	default:
 		write(writer, http.StatusMethodNotAllowed, &Response{Code: codeFail, Msg: "method not allowed"})
-		return
 	}
+}
+
+func putDocument(writer http.ResponseWriter, request *http.Request) {
 	data, err := io.ReadAll(request.Body)
 	if err != nil {
 		log.Println(err.Error())
 		write(writer, http.StatusInternalServerError, &Response{Code: codeFail, Msg: "internal server error"})
 		return
 	}
-	var params map[string]string
+	var params struct {
+		Url      string   `json:"url"`
+		Document string   `json:"document"`
+		Links    []string `json:"links"` // 页面中的出链，爬虫发送的文档才有
+	}
 	if err = json.Unmarshal(data, &params); err != nil {
 		log.Println(err.Error())
 		write(writer, http.StatusBadRequest, &Response{Code: codeFail, Msg: "json format error"})
 		return
 	}
-	url, document := params["url"], params["document"]
-	if url == "" || document == "" {
+	if params.Url == "" || params.Document == "" {
+		write(writer, http.StatusBadRequest, &Response{Code: codeFail, Msg: "param error"})
+		return
+	}
+	engine.AddDocument(params.Url, params.Document, params.Links)
+	write(writer, http.StatusOK, &Response{Code: codeSuccess})
+}
+
+// DELETE /index?url=xxx
+func deleteDocument(writer http.ResponseWriter, request *http.Request) {
+	url := request.FormValue("url")
+	if url == "" {
+		write(writer, http.StatusBadRequest, &Response{Code: codeFail, Msg: "param error"})
+		return
+	}
+	ok, err := engine.DeleteDocument(url)
+	if err != nil {
+		log.Println(err.Error())
+		write(writer, http.StatusInternalServerError, &Response{Code: codeFail, Msg: "internal server error"})
+		return
+	}
+	if !ok {
+		write(writer, http.StatusNotFound, &Response{Code: codeFail, Msg: "document not found"})
+		return
+	}
+	write(writer, http.StatusOK, &Response{Code: codeSuccess})
+}
+
+// GET /titles?limit=xxx 使用最多的标题，web 用来建立搜索建议的前缀树
+func titlesHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		write(writer, http.StatusMethodNotAllowed, &Response{Code: codeFail, Msg: "method not allowed"})
+		return
+	}
+	limit := defaultTitleLimit
+	if l := request.FormValue("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 || limit > maxTitleLimit {
+			write(writer, http.StatusBadRequest, &Response{Code: codeFail, Msg: "param error"})
+			return
+		}
+	}
+	write(writer, http.StatusOK, &Response{Code: codeSuccess, Data: engine.PopularTitles(limit)})
+}
+
+// PUT /anchors 记录页面中链接的锚文本，{"source": 页面 URL, "anchors": {目标 URL: 锚文本}}
+func anchorsHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPut {
+		write(writer, http.StatusMethodNotAllowed, &Response{Code: codeFail, Msg: "method not allowed"})
+		return
+	}
+	var params struct {
+		Source  string            `json:"source"`
+		Anchors map[string]string `json:"anchors"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		write(writer, http.StatusBadRequest, &Response{Code: codeFail, Msg: "json format error"})
+		return
+	}
+	if params.Source == "" {
 		write(writer, http.StatusBadRequest, &Response{Code: codeFail, Msg: "param error"})
+		return
+	}
+	if err := engine.AddAnchors(params.Source, params.Anchors); err != nil {
+		log.Println(err.Error())
+		write(writer, http.StatusInternalServerError, &Response{Code: codeFail, Msg: "internal server error"})
+		return
 	}
-	engine.AddDocument(url, document)
 	write(writer, http.StatusOK, &Response{Code: codeSuccess})
 }
 
+// GET /links 本地保存的所有页面的出链，其他索引服务器用来计算 PageRank
+func linksHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		write(writer, http.StatusMethodNotAllowed, &Response{Code: codeFail, Msg: "method not allowed"})
+		return
+	}
+	write(writer, http.StatusOK, &Response{Code: codeSuccess, Data: engine.LocalLinks()})
+}
+
+// PUT /migrate 接收其他索引服务器迁移过来的文档，请求体为 JSON Lines，每行一个 core.MigratedDocument，
+// 文档写入磁盘后才返回成功
+func migrateHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPut {
+		write(writer, http.StatusMethodNotAllowed, &Response{Code: codeFail, Msg: "method not allowed"})
+		return
+	}
+	count, err := engine.ReceiveDocuments(request.Body)
+	if err != nil {
+		log.Println(err.Error())
+		write(writer, http.StatusBadRequest, &Response{Code: codeFail, Msg: err.Error()})
+		return
+	}
+	write(writer, http.StatusOK, &Response{Code: codeSuccess, Data: count})
+}
+
+// PUT /drain 下线：不再分配 URL，把所有文档迁移到其他索引服务器。GET 返回是否正在下线
+func drainHandler(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodPut:
+		engine.Drain()
+		write(writer, http.StatusOK, &Response{Code: codeSuccess})
+	case http.MethodGet:
+		write(writer, http.StatusOK, &Response{Code: codeSuccess, Data: engine.Draining()})
+	default:
+		write(writer, http.StatusMethodNotAllowed, &Response{Code: codeFail, Msg: "method not allowed"})
+	}
+}
+
+// GET /replica/documents 本机的所有文档，JSON Lines，同一分片中正在恢复的副本用来同步数据
+func replicaDocumentsHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		write(writer, http.StatusMethodNotAllowed, &Response{Code: codeFail, Msg: "method not allowed"})
+		return
+	}
+	writer.Header().Set("Content-Type", "application/x-ndjson")
+	// 已经开始写响应，出错时只能中断，对方因为没有结束标记会认为同步失败
+	if err := engine.StreamDocuments(writer); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// GET /backup 索引库和文档库的一致快照（tar），可以保存为备份文件，或者在其他索引服务器启动时用 -restore 恢复
+func backupHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		write(writer, http.StatusMethodNotAllowed, &Response{Code: codeFail, Msg: "method not allowed"})
+		return
+	}
+	writer.Header().Set("Content-Type", "application/x-tar")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"indexer-%s.tar\"",
+		time.Now().Format("20060102-150405")))
+	// 已经开始写响应，出错时只能中断，tar 文件不完整，恢复时会失败
+	if err := engine.WriteSnapshot(writer); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// GET /backups 本机保存的备份，PUT 立即备份一次，完成后返回备份的信息
+func backupsHandler(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		backups, err := engine.Backups()
+		if err != nil {
+			log.Println(err.Error())
+			write(writer, http.StatusInternalServerError, &Response{Code: codeFail, Msg: "internal server error"})
+			return
+		}
+		write(writer, http.StatusOK, &Response{Code: codeSuccess, Data: backups})
+	case http.MethodPut:
+		info, err := engine.Backup()
+		if err != nil {
+			log.Println(err.Error())
+			write(writer, http.StatusInternalServerError, &Response{Code: codeFail, Msg: "backup failed"})
+			return
+		}
+		write(writer, http.StatusOK, &Response{Code: codeSuccess, Data: info})
+	default:
+		write(writer, http.StatusMethodNotAllowed, &Response{Code: codeFail, Msg: "method not allowed"})
+	}
+}
+
 func monitor(writer http.ResponseWriter, request *http.Request) {
 	info := new(MonitorInfo)
 	info.Addr = config.Get("indexer.listenAddr")
@@ -109,6 +340,10 @@ func monitor(writer http.ResponseWriter, request *http.Request) {
 	}
 	info.IndexedDocCount = engine.DB.GetDocumentsCount()
 	info.TokenCount = engine.DB.GetTokenCount()
+	info.SegmentCount = len(engine.DB.Segments())
+	info.Shard = core.ShardName()
+	info.Draining = engine.Draining()
+	info.Recovering = engine.Recovering()
 
 	write(writer, http.StatusOK, &Response{Code: codeSuccess, Data: info})
 }
@@ -0,0 +1,44 @@
+// 索引服务的启动流程，main 和 all-in-one 共用
+package app
+
+import (
+	"log"
+	"search-engine/index/api"
+	"search-engine/index/config"
+	"search-engine/index/core"
+	"search-engine/index/db"
+	"time"
+)
+
+// 注册自己到注册中心
+func registerSelf() {
+	addr := config.Get("indexer.listenAddr")
+	register := func() {
+		// addr:timestamp
+		if err := db.Registry.Register("indexer.addr", addr); err != nil {
+			log.Println(addr + "注册到注册中心失败")
+		}
+		// 分片拓扑：所有分片的名称，以及每个分片的副本
+		shard := core.ShardName()
+		_ = db.Registry.Register("indexer.shards", shard)
+		_ = db.Registry.Register("indexer.shard:"+shard, addr)
+	}
+	register()
+	go func() {
+		for {
+			time.Sleep(time.Second * 30) // 每30秒报告自己的存活状态
+			register()
+		}
+	}()
+}
+
+// 启动索引服务，阻塞直到 HTTP 服务退出
+func Run() error {
+	defer func() {
+		// 退出时移除自己
+		_ = db.Registry.Deregister("indexer.addr", config.Get("indexer.listenAddr"))
+		_ = db.Registry.Deregister("indexer.shard:"+core.ShardName(), config.Get("indexer.listenAddr"))
+	}()
+	registerSelf()
+	return api.Serve(config.Get("indexer.listenAddr"))
+}
@@ -8,14 +8,66 @@ import (
 	"strings"
 )
 
+// 存储模式
+const (
+	// 分布式模式，使用 Redis 做服务注册
+	ModeDistributed = "distributed"
+	// 嵌入模式，不依赖 MySQL 和 Redis
+	ModeEmbedded = "embedded"
+)
+
 // 本地配置项必须提供
-var localConfigItem = [...]string{"mysql.username", "mysql.password", "mysql.host",
-	"mysql.port", "mysql.dbname", "boltdb.indexPath", "boltdb.docPath", "indexer.listenAddr",
+var localConfigItem = [...]string{"boltdb.indexPath", "boltdb.docPath", "indexer.listenAddr",
 	"indexer.docUrlBufferSize", "indexer.postingsBufferSize", "indexer.indexWorkerCount",
 	"indexer.indexChannelLength", "indexer.mergeChannelLength", "indexer.flushChannelLength",
-	"indexer.flushWorkerCount", "indexer.postingsBufferFlushThreshold", "redis.addr",
+	"indexer.flushWorkerCount", "indexer.postingsBufferFlushThreshold",
 	"indexer.tokenDocCountBufferSize"}
 
+// 分布式模式下必须提供的配置项
+var distributedConfigItem = [...]string{"mysql.username", "mysql.password", "mysql.host",
+	"mysql.port", "mysql.dbname", "redis.addr"}
+
+// 可选配置项的默认值
+var defaultConfig = map[string]string{
+	"storage.mode": ModeDistributed,
+	// 嵌入模式下 web 后台的管理数据文件，从中读取同义词
+	"embedded.adminDataPath": "./data/admin.json",
+	// 分析器：standard（中文词典分词 + 英文单词）、chinese、english、bigram（旧版本的二元分词）
+	"indexer.analyzer": "standard",
+	// 清理倒排列表中已删除文档的间隔（秒），0 表示不清理
+	"indexer.purgeInterval": "3600",
+	// 每一层段的数量达到该值时合并，小于 2 表示不合并
+	"indexer.compactThreshold": "4",
+	// 重建拼写纠错词典的间隔（秒），0 表示只在启动时建一次
+	"indexer.spellRebuildInterval": "600",
+	// 计算 PageRank 的间隔（秒），0 表示不计算
+	"indexer.pageRankInterval": "3600",
+	// 锚文本变化后重新建索引的间隔（秒），0 表示不重新建索引（新抓取的页面仍然会使用已有的锚文本）
+	"indexer.anchorReindexInterval": "60",
+	// 检查并迁移不属于本机的文档的间隔（秒），0 表示不迁移
+	"indexer.rebalanceInterval": "60",
+	// 所属的分片，分片名相同的索引服务器互为副本，为空时每个索引服务器单独是一个分片
+	"indexer.shard": "",
+	// 本机备份的目录、自动备份的间隔（秒，0 表示不自动备份）和保留的备份数量（0 表示全部保留）
+	"indexer.backupDir":       "./data/backup",
+	"indexer.backupInterval":  "86400",
+	"indexer.backupRetention": "7",
+	// 排序模型，bm25f 或 tfidf
+	"ranking.model": "bm25f",
+	// BM25F 参数，k1 控制词频的饱和速度，b 控制文档长度归一化的程度，weight 是字段的权重
+	"ranking.bm25.k1":                "1.2",
+	"ranking.bm25.titleB":            "0.75",
+	"ranking.bm25.bodyB":             "0.75",
+	"ranking.bm25.titleWeight":       "3",
+	"ranking.bm25.bodyWeight":        "1",
+	"ranking.bm25.anchorB":           "0.5",
+	"ranking.bm25.anchorWeight":      "2",
+	"ranking.bm25.boilerplateB":      "0.75",
+	"ranking.bm25.boilerplateWeight": "0.3",
+	// PageRank 的权重，文档分数乘以 PageRank^weight（PageRank 的平均值为 1），0 表示不使用
+	"ranking.pageRankWeight": "0.2",
+}
+
 var config map[string]string
 
 func init() {
@@ -31,6 +83,9 @@ func init() {
 	}
 
 	config = make(map[string]string)
+	for name, value := range defaultConfig {
+		config[name] = value
+	}
 	for _, line := range strings.Split(string(b), "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" || line[0] == '#' {
@@ -46,7 +101,15 @@ func init() {
 }
 
 func checkLocalConfig(config map[string]string) {
-	for _, name := range localConfigItem {
+	required := localConfigItem[:]
+	switch config["storage.mode"] {
+	case ModeDistributed:
+		required = append(required, distributedConfigItem[:]...)
+	case ModeEmbedded:
+	default:
+		panic(fmt.Sprintf("配置项[storage.mode]错误：%s", config["storage.mode"]))
+	}
+	for _, name := range required {
 		if _, ok := config[name]; !ok {
 			panic(fmt.Sprintf("缺少配置项[%s]", name))
 		}
@@ -64,3 +127,16 @@ func GetInt(name string) int {
 	}
 	return i
 }
+
+func GetFloat(name string) float64 {
+	f, err := strconv.ParseFloat(config[name], 64)
+	if err != nil {
+		panic("配置项错误：" + name)
+	}
+	return f
+}
+
+// 是否运行在嵌入模式下
+func Embedded() bool {
+	return config["storage.mode"] == ModeEmbedded
+}
@@ -0,0 +1,342 @@
+// 分析器，将文本切分成词元，建立索引和检索时必须使用同一个分析器
+package core
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"log"
+	"strings"
+)
+
+type Analyzer interface {
+	// 分析器的名称，会记录在索引中
+	Name() string
+	// 建索引时将文本切分成词元，pos 是词元在文本中的位置（字符下标）
+	Analyze(text string, consumer func(token string, pos int) error)
+	// 检索时将查询切分成词元。索引中额外保存了汉字、假名的单字词元，用于回答单字查询，
+	// 较长的查询仍然使用词（或二元词元），所以两者的结果不完全相同
+	AnalyzeQuery(query string, consumer func(token string, pos int) error)
+}
+
+// 分析器名称 -> 构造函数
+var analyzers = map[string]func() Analyzer{
+	"bigram":   func() Analyzer { return &nGramAnalyzer{n: 2} },
+	"chinese":  func() Analyzer { return &chineseAnalyzer{dict: defaultChineseDict()} },
+	"english":  func() Analyzer { return &englishAnalyzer{} },
+	"standard": func() Analyzer { return newStandardAnalyzer() },
+}
+
+func newAnalyzer(name string) (Analyzer, error) {
+	constructor, ok := analyzers[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的分析器：%s", name)
+	}
+	return constructor(), nil
+}
+
+func callConsumer(consumer func(token string, pos int) error, token string, pos int) {
+	if err := consumer(token, pos); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// 对规范化后文本中的一个同类字符片段 chars[start:end] 分词，不处理的类别直接忽略，
+// emit 的位置是规范化后文本中的下标，query 表示是否是检索时分词
+type runAnalyzer interface {
+	analyzeRun(chars []rune, class, start, end int, query bool, emit func(token string, pos int))
+}
+
+// 规范化文本，按字符类别切分后交给 a 分词，并将词元位置换算成原文本中的位置
+func analyzeNormalized(a runAnalyzer, text string, query bool, consumer func(token string, pos int) error) {
+	chars, offsets := normalizeText(text)
+	emit := func(token string, pos int) {
+		callConsumer(consumer, token, offsets[pos])
+	}
+	splitRuns(chars, func(class, start, end int) {
+		a.analyzeRun(chars, class, start, end, query, emit)
+	})
+}
+
+// 建索引时为每个字输出一个单字词元
+func emitUnigrams(chars []rune, start, end int, emit func(token string, pos int)) {
+	for i := start; i < end; i++ {
+		emit(string(chars[i]), i)
+	}
+}
+
+///////////////////// n-gram //////////////////////
+
+// 旧版本使用的 n-gram 分析器，英文也会被切分成 n-gram。
+// 为了兼容旧版本建立的索引，这里不做规范化，也只处理常用汉字和英文字母、数字
+type nGramAnalyzer struct {
+	n int
+}
+
+func (a *nGramAnalyzer) Name() string {
+	return "bigram"
+}
+
+// 除了 n-gram，还为每个字建立单字词元
+func (a *nGramAnalyzer) Analyze(text string, consumer func(token string, pos int) error) {
+	nGramSplit(text, 1, consumer)
+	nGramSplit(text, a.n, consumer)
+}
+
+// 不足 n 个字的片段（如单字查询）无法切分出 n-gram，整个片段作为一个词元
+func (a *nGramAnalyzer) AnalyzeQuery(query string, consumer func(token string, pos int) error) {
+	nGramSplit(query, a.n, consumer)
+	shortFragmentSplit(query, a.n, consumer)
+}
+
+///////////////////// 中文 //////////////////////
+
+//go:embed dict/chinese.txt
+var chineseDictData string
+
+type chineseDict struct {
+	words  map[string]struct{}
+	maxLen int // 最长的词的字数
+}
+
+var sharedChineseDict *chineseDict
+
+// 内置词典只加载一次
+func defaultChineseDict() *chineseDict {
+	if sharedChineseDict == nil {
+		sharedChineseDict = loadChineseDict(chineseDictData)
+	}
+	return sharedChineseDict
+}
+
+// 每行一个词，# 开头的是注释
+func loadChineseDict(data string) *chineseDict {
+	dict := &chineseDict{words: make(map[string]struct{}, 150000)}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || word[0] == '#' {
+			continue
+		}
+		dict.words[word] = struct{}{}
+		if l := len([]rune(word)); l > dict.maxLen {
+			dict.maxLen = l
+		}
+	}
+	return dict
+}
+
+func (d *chineseDict) has(word []rune) bool {
+	_, ok := d.words[string(word)]
+	return ok
+}
+
+// 基于词典的中文分词，使用双向最大匹配，非汉字字符都被忽略
+type chineseAnalyzer struct {
+	dict *chineseDict
+}
+
+func (a *chineseAnalyzer) Name() string {
+	return "chinese"
+}
+
+func (a *chineseAnalyzer) Analyze(text string, consumer func(token string, pos int) error) {
+	analyzeNormalized(a, text, false, consumer)
+}
+
+func (a *chineseAnalyzer) AnalyzeQuery(query string, consumer func(token string, pos int) error) {
+	analyzeNormalized(a, query, true, consumer)
+}
+
+// 建索引时每个汉字都有单字词元，所以分词结果中的单字词就不用再输出了；
+// 检索时分词结果中的单字词（包括单字查询）使用的就是单字词元
+func (a *chineseAnalyzer) analyzeRun(chars []rune, class, start, end int, query bool, emit func(token string, pos int)) {
+	if class != charHan {
+		return
+	}
+	if !query {
+		emitUnigrams(chars, start, end, emit)
+	}
+	a.segment(chars[start:end], start, query, emit)
+}
+
+// 对一段连续的汉字分词，base 是这段汉字在文本中的位置。
+// 除了分词结果，还会输出长词中包含的词典词，如“搜索引擎” => 搜索引擎、搜索、引擎，
+// 这样检索“引擎”时也能找到包含“搜索引擎”的文档
+func (a *chineseAnalyzer) segment(chars []rune, base int, query bool, emit func(token string, pos int)) {
+	for _, word := range a.bidirectionalMaximumMatch(chars) {
+		start, end := word[0], word[1]
+		if end-start > 1 || query {
+			emit(string(chars[start:end]), base+start)
+		}
+		if end-start <= 2 {
+			continue
+		}
+		for i := start; i < end; i++ {
+			for j := i + 2; j <= end && j-i < end-start; j++ {
+				if a.dict.has(chars[i:j]) {
+					emit(string(chars[i:j]), base+i)
+				}
+			}
+		}
+	}
+}
+
+// 正向最大匹配，返回各个词的区间 [start, end)
+func (a *chineseAnalyzer) forwardMaximumMatch(chars []rune) [][2]int {
+	var words [][2]int
+	for i := 0; i < len(chars); {
+		l := a.dict.maxLen
+		if l > len(chars)-i {
+			l = len(chars) - i
+		}
+		// 词典中没有的单字也作为一个词
+		for ; l > 1 && !a.dict.has(chars[i:i+l]); l-- {
+		}
+		words = append(words, [2]int{i, i + l})
+		i += l
+	}
+	return words
+}
+
+// 逆向最大匹配
+func (a *chineseAnalyzer) backwardMaximumMatch(chars []rune) [][2]int {
+	var words [][2]int
+	for i := len(chars); i > 0; {
+		l := a.dict.maxLen
+		if l > i {
+			l = i
+		}
+		for ; l > 1 && !a.dict.has(chars[i-l:i]); l-- {
+		}
+		words = append(words, [2]int{i - l, i})
+		i -= l
+	}
+	// 反转
+	for i, j := 0, len(words)-1; i < j; i, j = i+1, j-1 {
+		words[i], words[j] = words[j], words[i]
+	}
+	return words
+}
+
+// 双向最大匹配：取词数少的结果，词数相同时取单字少的结果，都相同时取逆向匹配的结果
+func (a *chineseAnalyzer) bidirectionalMaximumMatch(chars []rune) [][2]int {
+	fmm, bmm := a.forwardMaximumMatch(chars), a.backwardMaximumMatch(chars)
+	if len(fmm) != len(bmm) {
+		if len(fmm) < len(bmm) {
+			return fmm
+		}
+		return bmm
+	}
+	singleCount := func(words [][2]int) int {
+		count := 0
+		for _, w := range words {
+			if w[1]-w[0] == 1 {
+				count++
+			}
+		}
+		return count
+	}
+	if singleCount(fmm) < singleCount(bmm) {
+		return fmm
+	}
+	return bmm
+}
+
+///////////////////// 英文 //////////////////////
+
+// 英文停用词
+var englishStopwords = map[string]struct{}{}
+
+func init() {
+	words := `a about above after again against all am an and any are as at be because been before being
+below between both but by can did do does doing down during each few for from further had has have having
+he her here hers herself him himself his how i if in into is it its itself just me more most my myself no
+nor not of off on once only or other our ours ourselves out over own same she should so some such than that
+the their theirs them themselves then there these they this those through to too under until up very was
+we were what when where which while who whom why will with you your yours yourself yourselves s t`
+	for _, w := range strings.Fields(words) {
+		englishStopwords[w] = struct{}{}
+	}
+}
+
+// 单词分词：用于英文以及俄文、韩文等单词之间有空格的文字，按字母、数字切分单词并转为小写，
+// 英文单词还会去掉停用词、提取词干；汉字、假名都被忽略
+type englishAnalyzer struct{}
+
+func (a *englishAnalyzer) Name() string {
+	return "english"
+}
+
+func (a *englishAnalyzer) Analyze(text string, consumer func(token string, pos int) error) {
+	analyzeNormalized(a, text, false, consumer)
+}
+
+func (a *englishAnalyzer) AnalyzeQuery(query string, consumer func(token string, pos int) error) {
+	analyzeNormalized(a, query, true, consumer)
+}
+
+// 单个字母、数字本身就是一个单词，不需要单字词元
+func (a *englishAnalyzer) analyzeRun(chars []rune, class, start, end int, query bool, emit func(token string, pos int)) {
+	if class != charWord {
+		return
+	}
+	if token, ok := normalizeWord(string(chars[start:end])); ok {
+		emit(token, start)
+	}
+}
+
+// 转为小写，英文单词还要去停用词、提取词干，ok=false 表示是停用词
+func normalizeWord(word string) (string, bool) {
+	word = strings.ToLower(word)
+	if _, ok := englishStopwords[word]; ok {
+		return "", false
+	}
+	return porterStem(word), true
+}
+
+///////////////////// 多语言混合 //////////////////////
+
+// 默认的分析器，汉字使用中文分词，假名使用二元分词，其他文字使用单词分词
+type standardAnalyzer struct {
+	chinese *chineseAnalyzer
+	english *englishAnalyzer
+}
+
+func newStandardAnalyzer() *standardAnalyzer {
+	return &standardAnalyzer{
+		chinese: &chineseAnalyzer{dict: defaultChineseDict()},
+		english: &englishAnalyzer{},
+	}
+}
+
+func (a *standardAnalyzer) Name() string {
+	return "standard"
+}
+
+func (a *standardAnalyzer) Analyze(text string, consumer func(token string, pos int) error) {
+	analyzeNormalized(a, text, false, consumer)
+}
+
+func (a *standardAnalyzer) AnalyzeQuery(query string, consumer func(token string, pos int) error) {
+	analyzeNormalized(a, query, true, consumer)
+}
+
+func (a *standardAnalyzer) analyzeRun(chars []rune, class, start, end int, query bool, emit func(token string, pos int)) {
+	switch class {
+	case charHan:
+		a.chinese.analyzeRun(chars, class, start, end, query, emit)
+	case charKana:
+		// 日文没有词典，假名按二元切分，建索引时还有单字词元，检索时只有一个假名才使用单字词元
+		if !query {
+			emitUnigrams(chars, start, end, emit)
+		} else if end-start == 1 {
+			emit(string(chars[start:end]), start)
+		}
+		for i := start; i+2 <= end; i++ {
+			emit(string(chars[i:i+2]), i)
+		}
+	case charWord:
+		a.english.analyzeRun(chars, class, start, end, query, emit)
+	}
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 检索时的分词结果
+func analyze(a Analyzer, text string) []string {
+	var tokens []string
+	a.AnalyzeQuery(text, func(token string, pos int) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	return tokens
+}
+
+func TestChineseAnalyzer(t *testing.T) {
+	dict := loadChineseDict("# test\n研究\n研究生\n生命\n起源\n搜索\n引擎\n搜索引擎\n")
+	a := &chineseAnalyzer{dict: dict}
+	// 正向：研究生/命/起源，逆向：研究/生命/起源，词数相同时取单字少的逆向结果
+	if tokens := analyze(a, "研究生命起源"); !reflect.DeepEqual(tokens, []string{"研究", "生命", "起源"}) {
+		t.Error(tokens)
+	}
+	// 长词中的词典词也会输出
+	if tokens := analyze(a, "搜索引擎，Go"); !reflect.DeepEqual(tokens, []string{"搜索引擎", "搜索", "引擎"}) {
+		t.Error(tokens)
+	}
+}
+
+func TestEnglishAnalyzer(t *testing.T) {
+	a := &englishAnalyzer{}
+	var positions []int
+	a.AnalyzeQuery("The Search-Engines are RUNNING 中文 2021", func(token string, pos int) error {
+		positions = append(positions, pos)
+		return nil
+	})
+	if tokens := analyze(a, "The Search-Engines are RUNNING 中文 2021"); !reflect.DeepEqual(tokens, []string{"search", "engin", "run", "2021"}) {
+		t.Error(tokens)
+	}
+	if !reflect.DeepEqual(positions, []int{4, 11, 23, 34}) {
+		t.Error(positions)
+	}
+}
+
+func TestPorterStem(t *testing.T) {
+	cases := map[string]string{
+		"caresses":        "caress",
+		"ponies":          "poni",
+		"cats":            "cat",
+		"agreed":          "agre",
+		"hopping":         "hop",
+		"filing":          "file",
+		"happy":           "happi",
+		"relational":      "relat",
+		"conditional":     "condit",
+		"hopeful":         "hope",
+		"goodness":        "good",
+		"adjustment":      "adjust",
+		"controll":        "control",
+		"generalizations": "gener",
+		"connection":      "connect",
+		"is":              "is",
+	}
+	for word, want := range cases {
+		if got := porterStem(word); got != want {
+			t.Errorf("%s => %s, want %s", word, got, want)
+		}
+	}
+}
+
+func TestStandardAnalyzer(t *testing.T) {
+	a := &standardAnalyzer{
+		chinese: &chineseAnalyzer{dict: loadChineseDict("搜寻\n引擎\n")},
+		english: &englishAnalyzer{},
+	}
+	var tokens []string
+	var positions []int
+	// 全角字母、繁体字、兼容字符（㎏ => kg）、俄文、假名
+	a.AnalyzeQuery("ＧＯ搜尋引擎 ㎏ Поиск カタカナ", func(token string, pos int) error {
+		tokens = append(tokens, token)
+		positions = append(positions, pos)
+		return nil
+	})
+	want := []string{"go", "搜寻", "引擎", "kg", "поиск", "カタ", "タカ", "カナ"}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Error(tokens)
+	}
+	// 位置是原文本中的位置
+	if !reflect.DeepEqual(positions, []int{0, 2, 4, 7, 9, 15, 16, 17}) {
+		t.Error(positions)
+	}
+}
+
+func TestUnigram(t *testing.T) {
+	a := &standardAnalyzer{
+		chinese: &chineseAnalyzer{dict: loadChineseDict("水果\n")},
+		english: &englishAnalyzer{},
+	}
+	// 建索引时每个汉字、假名都有单字词元
+	var tokens []string
+	a.Analyze("吃水果の C", func(token string, pos int) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	if want := []string{"吃", "水", "果", "水果", "の", "c"}; !reflect.DeepEqual(tokens, want) {
+		t.Error(tokens)
+	}
+	// 单字查询使用单字词元，较长的查询使用词
+	for query, want := range map[string][]string{"水": {"水"}, "C": {"c"}, "吃水果": {"吃", "水果"}} {
+		if tokens := analyze(a, query); !reflect.DeepEqual(tokens, want) {
+			t.Error(query, tokens)
+		}
+	}
+
+	b := &nGramAnalyzer{n: 2}
+	for query, want := range map[string][]string{"水": {"水"}, "水果": {"水果"}, "水，果": {"水", "果"}} {
+		if tokens := analyze(b, query); !reflect.DeepEqual(tokens, want) {
+			t.Error(query, tokens)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// 锚文本：爬虫把页面中每个链接的文字发给索引服务器，作为目标页面的一个字段建索引。
+// 目标页面的锚文本变化后，后台任务用保存的标题、正文和最新的锚文本重新建索引，
+// 还没有抓取的页面也会建立一个只有锚文本的文档，抓取后被替换
+package core
+
+import (
+	"log"
+	"search-engine/index/db"
+	"time"
+)
+
+// 每次最多重新建索引的页面数量
+const anchorReindexBatch = 1000
+
+// 记录 source 页面中的锚文本，anchors 为目标 URL -> 锚文本
+func (e *Engine) AddAnchors(source string, anchors map[string]string) error {
+	return e.DB.AddAnchors(source, anchors)
+}
+
+// 定时为锚文本变化的页面重新建索引，interval 为间隔（秒），小于等于 0 时不重新建索引
+func (e *Engine) startAnchorGoroutine(interval int) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(interval) * time.Second)
+			if count := e.reindexAnchorTargets(); count > 0 {
+				log.Printf("锚文本变化，重新建索引 %d 个页面\n", count)
+			}
+		}
+	}()
+}
+
+// 返回重新建索引的页面数量
+func (e *Engine) reindexAnchorTargets() int {
+	urls, err := e.DB.TakeDirtyAnchorTargets(anchorReindexBatch)
+	if err != nil {
+		log.Println(err.Error())
+		return 0
+	}
+	for _, url := range urls {
+		e.indexManager.indexChannel <- anchorTargetDocument(e.DB, url)
+	}
+	return len(urls)
+}
+
+// 用保存的标题、正文和模板文字重新建索引，没有抓取的页面这些都为空
+func anchorTargetDocument(db *db.IndexDB, url string) *rawDocument {
+	doc := &parsedDocument{}
+	if docId := db.GetDocumentId(url); docId >= 0 {
+		_, doc.title, doc.body = db.GetDocument(docId)
+		doc.boilerplate = db.GetDocumentBoilerplate(docId)
+	}
+	return &rawDocument{url: url, parsed: doc}
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldTf(t *testing.T) {
+	cursor := &postingsList{positions: []int{1, 5, 9, anchorBase + 2}, titleEnd: 1}
+	if tf := fieldTf(cursor); tf != [fieldCount]int{1, 2, 1, 0} {
+		t.Error(tf)
+	}
+	cursor = &postingsList{positions: []int{anchorBase, anchorBase + 3}, titleEnd: 0}
+	if tf := fieldTf(cursor); tf != [fieldCount]int{0, 0, 2, 0} {
+		t.Error(tf)
+	}
+	cursor = &postingsList{positions: []int{3, boilerplateBase + 1, boilerplateBase + 7, anchorBase + 1}, titleEnd: 0}
+	if tf := fieldTf(cursor); tf != [fieldCount]int{0, 1, 1, 2} {
+		t.Error(tf)
+	}
+}
+
+func TestAnchorText(t *testing.T) {
+	s := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com": {"搜索引擎", "倒排索引"},
+	})
+	s.bm25.b[fieldAnchor], s.bm25.weight[fieldAnchor] = 0.5, 2
+	err := s.db.AddAnchors("http://src.com", map[string]string{
+		"http://a.com":   "搜索引擎 教程",
+		"http://new.com": "还没有抓取的 页面",
+		"http://src.com": "指向自己的链接",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 锚文本变化的页面重新建索引
+	m := &indexManager{db: s.db, textProcessor: s.textProcessor}
+	targets, _ := s.db.TakeDirtyAnchorTargets(anchorReindexBatch)
+	if len(targets) != 2 {
+		t.Fatal(targets)
+	}
+	index := invertedIndex{}
+	for _, url := range targets {
+		index.merge(m.indexDocument(anchorTargetDocument(s.db, url)))
+	}
+	flushIndex(s.db, index)
+
+	results := searchQuery(t, s, "教程", 10, SortByScore)
+	results.applyHighlight(s.db)
+	if len(results.Items) != 1 {
+		t.Fatal(results.Items)
+	}
+	item := results.Items[0]
+	if item.Url != "http://a.com" || item.Title != "搜索引擎" || len(item.Snippets[0].Highlights) != 0 {
+		t.Errorf("%+v", item)
+	}
+	if want := []snippet{{Text: "搜索引擎 教程", Highlights: [][2]int{{5, 7}}}}; !reflect.DeepEqual(item.Anchors, want) {
+		t.Errorf("%+v", item.Anchors)
+	}
+
+	// 还没有抓取的页面只有锚文本
+	results = searchQuery(t, s, "抓取", 10, SortByScore)
+	results.applyHighlight(s.db)
+	if len(results.Items) != 1 || results.Items[0].Url != "http://new.com" || results.Items[0].Title != "" {
+		t.Errorf("%+v", results.Items)
+	}
+	if results = searchQuery(t, s, "自己", 10, SortByScore); len(results.Items) != 0 {
+		t.Error("指向自己的链接")
+	}
+}
@@ -0,0 +1,166 @@
+// 备份：GET /backup 流式返回索引库和文档库的一致快照（tar），PUT /backups 在本机的 indexer.backupDir 下保存一份备份，
+// 另外每隔 indexer.backupInterval 秒自动备份一次，只保留最新的 indexer.backupRetention 份。
+// 用 -restore 参数启动时先从快照（备份文件或者其他索引服务器的 /backup 地址）恢复数据库再启动
+package core
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"search-engine/index/config"
+	"search-engine/index/db"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 备份文件名为 indexer-20060102-150405.tar
+const (
+	backupPrefix     = "indexer-"
+	backupSuffix     = ".tar"
+	backupTimeLayout = "20060102-150405"
+)
+
+type BackupInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Time int64  `json:"time"` // 备份完成的时间
+}
+
+// 把快照写入 w，见 db.IndexDB.WriteSnapshot
+func (e *Engine) WriteSnapshot(w io.Writer) error {
+	return e.DB.WriteSnapshot(w)
+}
+
+// 定时备份，interval 为间隔（秒），小于等于 0 时不备份
+func (e *Engine) startBackupGoroutine(interval int) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(interval) * time.Second)
+			begin := time.Now()
+			if info, err := e.Backup(); err != nil {
+				log.Println("备份失败：" + err.Error())
+			} else {
+				log.Printf("备份完成 %s，%d 字节，耗时 %.1fs\n", info.Name, info.Size, time.Since(begin).Seconds())
+			}
+		}
+	}()
+}
+
+// 在本机保存一份备份并删除多余的旧备份，同一时间只有一个备份在进行
+func (e *Engine) Backup() (*BackupInfo, error) {
+	e.backupLock.Lock()
+	defer e.backupLock.Unlock()
+
+	dir := config.Get("indexer.backupDir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := backupPrefix + time.Now().Format(backupTimeLayout) + backupSuffix
+	path := filepath.Join(dir, name)
+	// 先写临时文件，列出备份时不会看到写了一半的文件
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.DB.WriteSnapshot(file); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	if err = pruneBackups(dir, config.GetInt("indexer.backupRetention")); err != nil {
+		log.Println("删除旧备份失败：" + err.Error())
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupInfo{Name: name, Size: fileInfo.Size(), Time: fileInfo.ModTime().Unix()}, nil
+}
+
+// 本机的备份，新的在前
+func (e *Engine) Backups() ([]*BackupInfo, error) {
+	return listBackups(config.Get("indexer.backupDir"))
+}
+
+func listBackups(dir string) ([]*BackupInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var backups []*BackupInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, &BackupInfo{Name: name, Size: fileInfo.Size(), Time: fileInfo.ModTime().Unix()})
+	}
+	// 文件名中的时间可以直接按字符串比较
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name > backups[j].Name
+	})
+	return backups, nil
+}
+
+// 只保留最新的 retention 份备份，retention 小于等于 0 时不删除
+func pruneBackups(dir string, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+	backups, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	for i := retention; i < len(backups); i++ {
+		if err = os.Remove(filepath.Join(dir, backups[i].Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从快照恢复数据库，src 是备份文件的路径或者其他索引服务器的 /backup 地址（http:// 开头），必须在 NewEngine 之前调用
+func RestoreFrom(src string) error {
+	var r io.Reader
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := replicaClient.Get(src)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", src, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		file, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	return db.RestoreSnapshot(r, config.Get("boltdb.indexPath"), config.Get("boltdb.docPath"))
+}
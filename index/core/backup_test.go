@@ -0,0 +1,80 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"search-engine/index/db"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com": {"搜索引擎", "倒排索引"},
+		"http://b.com": {"网络爬虫", "抓取网页"},
+	})
+	buf := &bytes.Buffer{}
+	if err := s.db.WriteSnapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	indexPath, docPath := filepath.Join(dir, "index.db"), filepath.Join(dir, "doc.db")
+	_ = os.WriteFile(indexPath, []byte("旧的数据库"), 0600)
+	// 不完整的快照不替换原来的数据库
+	if err := db.RestoreSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), indexPath, docPath); err == nil {
+		t.Error("不完整的快照")
+	}
+	if b, _ := os.ReadFile(indexPath); string(b) != "旧的数据库" {
+		t.Error("替换了原来的数据库")
+	}
+	if err := db.RestoreSnapshot(buf, indexPath, docPath); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(indexPath + ".before-restore"); string(b) != "旧的数据库" {
+		t.Error("没有保留原来的数据库")
+	}
+
+	restored := db.NewIndexDB(&db.IndexDBOptions{
+		DocUrlBufferSize:         10,
+		PostingsBufferSize:       10,
+		TokenDocsCountBufferSize: 10,
+		DocumentDBPath:           docPath,
+		IndexDBPath:              indexPath,
+	})
+	r := &searcher{db: restored, textProcessor: newTextProcessor(s.textProcessor.analyzer, restored),
+		rankingModel: rankingBM25F, bm25: s.bm25}
+	results := searchQuery(t, r, "爬虫", 10, SortByScore)
+	results.applyHighlight(restored)
+	if len(results.Items) != 1 || results.Items[0].Url != "http://b.com" {
+		t.Errorf("%+v", results.Items)
+	}
+	if restored.GetDocumentsCount() != 2 {
+		t.Error(restored.GetDocumentsCount())
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"indexer-20260101-000000.tar", "indexer-20260103-000000.tar",
+		"indexer-20260102-000000.tar", "indexer-20260104-000000.tar.tmp", "other.tar"} {
+		_ = os.WriteFile(filepath.Join(dir, name), []byte(name), 0600)
+	}
+	if err := pruneBackups(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	backups, _ := listBackups(dir)
+	var names []string
+	for _, b := range backups {
+		names = append(names, b.Name)
+	}
+	if len(names) != 2 || names[0] != "indexer-20260103-000000.tar" || names[1] != "indexer-20260102-000000.tar" {
+		t.Error(names)
+	}
+	// 其他文件不删除
+	for _, name := range []string{"indexer-20260104-000000.tar.tmp", "other.tar"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// 段的合并：按段的大小分层（size-tiered），某一层的段数量达到阈值时，将这一层的段合并成一个更大的段，
+// 这样段的数量保持在对数级别，检索时需要读取的段也就不会太多
+package core
+
+import (
+	"bytes"
+	"github.com/boltdb/bolt"
+	"log"
+	"search-engine/index/db"
+	"time"
+)
+
+const (
+	compactionMinSize  = 1 << 20 // 小于 1MB 的段都在第 0 层
+	compactionTierBase = 4       // 每一层段的大小是上一层的 4 倍
+)
+
+// 段所在的层
+func segmentTier(size int) int {
+	tier := 0
+	for s := compactionMinSize; size >= s; s *= compactionTierBase {
+		tier++
+	}
+	return tier
+}
+
+// 定时检查是否需要合并，threshold 为每层段数量的阈值，小于 2 时不合并
+func (e *Engine) startCompactionGoroutine(threshold int) {
+	if threshold < 2 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Second * 10)
+			// 合并后上一层的段可能也达到了阈值
+			for e.compactSegments(threshold) {
+			}
+		}
+	}()
+}
+
+// 找到段数量达到阈值的最低一层，将这一层的段合并成一个段，返回是否进行了合并
+func (e *Engine) compactSegments(threshold int) bool {
+	e.segmentLock.Lock()
+	defer e.segmentLock.Unlock()
+
+	tiers := make(map[int][]db.SegmentInfo)
+	for _, segment := range e.DB.Segments() {
+		tier := segmentTier(segment.Size)
+		tiers[tier] = append(tiers[tier], segment)
+	}
+	lowest := -1
+	for tier, segments := range tiers {
+		if len(segments) >= threshold && (lowest < 0 || tier < lowest) {
+			lowest = tier
+		}
+	}
+	if lowest < 0 {
+		return false
+	}
+	// 在一个事务中完成，合并期间检索读到的仍然是旧的段
+	err := e.DB.UpdatePostings(func(tx *bolt.Tx) error {
+		return mergeSegments(tx, tiers[lowest])
+	})
+	if err != nil {
+		log.Println("合并段失败", err.Error())
+		return false
+	}
+	return true
+}
+
+// 多路归并：各个段中的词元都是有序的，每次取最小的词元，合并它在各个段中的倒排列表
+func mergeSegments(tx *bolt.Tx, segments []db.SegmentInfo) error {
+	bucket, name, err := db.NewSegment(tx)
+	if err != nil {
+		return err
+	}
+	// 合并后的段使用新的格式，旧格式的段合并一次就会转换过来
+	info := &db.SegmentInfo{Name: name, MinDocId: segments[0].MinDocId, Format: postingsFormatBlock}
+	cursors := make([]*bolt.Cursor, len(segments))
+	keys, values := make([][]byte, len(segments)), make([][]byte, len(segments))
+	for i, segment := range segments {
+		cursors[i] = tx.Bucket([]byte(segment.Name)).Cursor()
+		keys[i], values[i] = cursors[i].First()
+		if segment.MinDocId < info.MinDocId {
+			info.MinDocId = segment.MinDocId
+		}
+		if segment.MaxDocId > info.MaxDocId {
+			info.MaxDocId = segment.MaxDocId
+		}
+	}
+	for {
+		var minKey []byte
+		for _, key := range keys {
+			if key != nil && (minKey == nil || bytes.Compare(key, minKey) < 0) {
+				minKey = key
+			}
+		}
+		if minKey == nil {
+			break
+		}
+		// 旧的段在事务结束前会被删除，复制一份
+		token := append([]byte(nil), minKey...)
+		var postings *postingsList
+		for i := range keys {
+			if bytes.Equal(keys[i], token) {
+				p, _ := decodeSegmentPostings(values[i], segments[i].Format)
+				postings = postings.merge(p)
+				keys[i], values[i] = cursors[i].Next()
+			}
+		}
+		data := postings.encode()
+		if err = bucket.Put(token, data); err != nil {
+			return err
+		}
+		info.Size += len(data)
+	}
+	if err = db.PutSegmentInfo(tx, info); err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if err = db.DropSegment(tx, segment.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
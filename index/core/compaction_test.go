@@ -0,0 +1,61 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestSegmentTier(t *testing.T) {
+	for size, want := range map[int]int{0: 0, compactionMinSize - 1: 0, compactionMinSize: 1,
+		compactionMinSize * compactionTierBase: 2} {
+		if tier := segmentTier(size); tier != want {
+			t.Error(size, tier)
+		}
+	}
+}
+
+func TestCompactSegments(t *testing.T) {
+	indexDB := newTestIndexDB(t)
+	e := &Engine{DB: indexDB}
+	// 4 次刷新写入 4 个段，词元 a 在每个段中都有
+	for i := 0; i < 4; i++ {
+		index := invertedIndex{"a": {token: "a", documentCount: 1, postings: &postingsList{documentId: i, positions: []int{i}}}}
+		if i%2 == 0 {
+			index["b"] = &tokenIndexItem{token: "b", documentCount: 1, postings: &postingsList{documentId: i}}
+		}
+		flushIndex(indexDB, index)
+	}
+	if len(indexDB.Segments()) != 4 {
+		t.Fatal(indexDB.Segments())
+	}
+	if e.compactSegments(5) {
+		t.Error("段的数量没有达到阈值")
+	}
+	if !e.compactSegments(4) {
+		t.Fatal("没有合并段")
+	}
+	segments := indexDB.Segments()
+	if len(segments) != 1 || segments[0].MinDocId != 0 || segments[0].MaxDocId != 3 {
+		t.Fatal(segments)
+	}
+	// 缓存中是合并前各个段的数据
+	indexDB.PostingsBuffer.Del("a")
+	indexDB.PostingsBuffer.Del("b")
+	for token, want := range map[string][]int{"a": {0, 1, 2, 3}, "b": {0, 2}} {
+		var docIds []int
+		for p := fetchPostings(indexDB, token); p != nil; p = p.next {
+			docIds = append(docIds, p.documentId)
+		}
+		if len(docIds) != len(want) || len(indexDB.FetchPostings(token)) != 1 {
+			t.Error(token, docIds)
+			continue
+		}
+		for i := range want {
+			if docIds[i] != want[i] {
+				t.Error(token, docIds)
+			}
+		}
+	}
+	if indexDB.GetDocsCountOfToken("a") != 4 {
+		t.Error(indexDB.GetDocsCountOfToken("a"))
+	}
+}
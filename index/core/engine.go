@@ -1,9 +1,13 @@
 package core
 
 import (
+	"log"
 	"search-engine/index/config"
 	"search-engine/index/db"
-	"search-engine/index/util"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,8 +16,16 @@ const searchDocId = -1024
 type Engine struct {
 	indexManager *indexManager
 	searcher     *searcher
+	spellChecker *spellChecker
+	synonyms     atomic.Value // synonymDict，还没加载时为空
 	DB           *db.IndexDB
 	Birthday     int64
+	// 合并段和清理删除的文档都会修改已有的段，不能同时进行
+	segmentLock sync.Mutex
+	draining    int32 // 正在下线，见 shard.go
+	migrating   int32 // 正在迁移文档
+	recovering  int32 // 正在从其他副本同步数据，见 replica.go
+	backupLock  sync.Mutex
 }
 
 func NewEngine() *Engine {
@@ -24,46 +36,127 @@ func NewEngine() *Engine {
 		DocumentDBPath:           config.Get("boltdb.docPath"),
 		IndexDBPath:              config.Get("boltdb.indexPath"),
 	})
-	tp := newTextProcessor(2, indexDB)
+	tp := newTextProcessor(indexAnalyzer(indexDB), indexDB)
 	e := &Engine{
 		indexManager: newIndexManager(indexDB, tp, config.GetInt("indexer.postingsBufferFlushThreshold")),
 		searcher:     newSearcher(indexDB, tp),
+		spellChecker: newSpellChecker(indexDB, tp.analyzer),
 		DB:           indexDB,
 		Birthday:     time.Now().Unix(),
 	}
+	e.startPurgeGoroutine(config.GetInt("indexer.purgeInterval"))
+	e.startCompactionGoroutine(config.GetInt("indexer.compactThreshold"))
+	e.startSpellGoroutine(config.GetInt("indexer.spellRebuildInterval"))
+	e.startSynonymGoroutine()
+	e.startPageRankGoroutine(config.GetInt("indexer.pageRankInterval"))
+	e.startAnchorGoroutine(config.GetInt("indexer.anchorReindexInterval"))
+	e.startRebalanceGoroutine(config.GetInt("indexer.rebalanceInterval"))
+	e.startCatchUpGoroutine()
+	e.startBackupGoroutine(config.GetInt("indexer.backupInterval"))
 	return e
 }
 
-// 为一个文档构建索引
-func (e *Engine) AddDocument(url, document string) {
-	e.indexManager.indexChannel <- [2]string{url, document}
+// 索引中记录的分析器名称
+const metaAnalyzer = "analyzer"
+
+// 确定索引使用的分析器：索引中有记录时以记录为准，否则使用配置的分析器并记录下来。
+// 没有记录但已经有词元的索引是旧版本建立的，使用的是 bigram
+func indexAnalyzer(indexDB *db.IndexDB) Analyzer {
+	name := indexDB.GetIndexMeta(metaAnalyzer)
+	configured := config.Get("indexer.analyzer")
+	if name == "" {
+		name = configured
+		if indexDB.GetTokenCount() > 0 {
+			name = "bigram"
+		}
+		if err := indexDB.SetIndexMeta(metaAnalyzer, name); err != nil {
+			log.Fatalln(err.Error())
+		}
+	}
+	if name != configured {
+		log.Printf("索引使用的分析器是 %s，忽略配置的 %s，更换分析器需要重建索引\n", name, configured)
+	}
+	analyzer, err := newAnalyzer(name)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	return analyzer
+}
+
+// 为一个文档构建索引，links 是页面中的出链，用于计算 PageRank，没有时为 nil
+func (e *Engine) AddDocument(url, document string, links []string) {
+	e.indexManager.indexChannel <- &rawDocument{url: url, document: document, links: links}
+}
+
+// 删除 URL 对应的文档，文档不存在时返回 false。
+// 注意还在 indexChannel 中等待建索引的文档是删除不了的
+func (e *Engine) DeleteDocument(url string) (bool, error) {
+	return e.DB.DeleteDocument(url)
+}
+
+// 检索参数，返回排好序的结果中 [Offset, Offset+Limit) 区间的结果
+type SearchOptions struct {
+	Offset int
+	Limit  int
+	Sort   string // SortByScore 或 SortByDate
 }
 
-// 并发安全
-func (e *Engine) Search(query string) SearchResults {
-	var searchResults SearchResults
-	// 检索并合并结果
-	parsedQuery := parseQuery(query)
-	if len(parsedQuery.keywords) == 0 {
-		return searchResults
+// 并发安全，查询有语法错误时返回 *QueryError
+func (e *Engine) Search(query string, options *SearchOptions) (SearchResults, error) {
+	parsedQuery, err := parseQuery(query)
+	if err != nil {
+		return SearchResults{}, err
 	}
-	for _, keyword := range parsedQuery.keywords {
-		r := e.searcher.searchDocs(keyword, parsedQuery.site)
-		searchResults.and(r)
-		if len(searchResults.Items) == 0 {
-			return searchResults
+	if synonyms, ok := e.synonyms.Load().(synonymDict); ok {
+		parsedQuery = synonyms.expand(parsedQuery)
+	}
+	// 检索排在前面的 Offset+Limit 个结果
+	searchResults, err := e.searcher.search(parsedQuery, options.Offset+options.Limit, options.Sort)
+	if err != nil {
+		return SearchResults{}, err
+	}
+	searchResults.sortResults()
+	// 没有需要纠正的词并且有结果时，记录查询中的词用于以后的纠错
+	searchResults.Suggestion = e.spellChecker.suggest(query)
+	if searchResults.Suggestion == "" && searchResults.Total > 0 {
+		e.spellChecker.observe(query)
+	}
+	if options.Offset >= len(searchResults.Items) {
+		searchResults.Items = nil
+	} else {
+		searchResults.Items = searchResults.Items[options.Offset:]
+	}
+	// 只对这一页的结果获取文档信息及高亮结果
+	searchResults.applyHighlight(e.DB)
+	return *searchResults, nil
+}
+
+// 标题及使用它的文档数量
+type TitleCount struct {
+	Title string `json:"title"`
+	Count int    `json:"count"`
+}
+
+// 未删除的文档中使用最多的 limit 个标题，按文档数量降序排列，用于 web 的搜索建议
+func (e *Engine) PopularTitles(limit int) []TitleCount {
+	counts := make(map[string]int)
+	e.DB.ForEachDocumentTitle(func(docId int, title string) {
+		if title = strings.TrimSpace(title); title != "" && !e.DB.IsDeleted(docId) {
+			counts[title]++
 		}
+	})
+	titles := make([]TitleCount, 0, len(counts))
+	for title, count := range counts {
+		titles = append(titles, TitleCount{Title: title, Count: count})
 	}
-	for _, exclusion := range parsedQuery.exclusions {
-		r := e.searcher.searchDocs(exclusion, parsedQuery.site)
-		searchResults.not(r)
-		if len(searchResults.Items) == 0 {
-			return searchResults
+	sort.Slice(titles, func(i, j int) bool {
+		if titles[i].Count != titles[j].Count {
+			return titles[i].Count > titles[j].Count
 		}
+		return titles[i].Title < titles[j].Title
+	})
+	if len(titles) > limit {
+		titles = titles[:limit]
 	}
-	// 每个索引服务器最多仅返回 100 / index_server_count 条结果
-	searchResults.Items = searchResults.Items[:util.MinInt(50, len(searchResults.Items))]
-	// 获取文档信息及高亮结果
-	searchResults.applyHighlight(e.DB)
-	return searchResults
+	return titles
 }
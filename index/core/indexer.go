@@ -16,7 +16,7 @@ import (
 //   ...   ---mergeChannel--> merger ---flushChannel--> ...
 // indexer                                              flusher
 type indexManager struct {
-	indexChannel chan [2]string
+	indexChannel chan *rawDocument
 	flushChannel chan invertedIndex
 	mergeChannel chan invertedIndex
 
@@ -28,6 +28,14 @@ type indexManager struct {
 	mergerCount          int32 // 并发检测，确保 merger 只被一个 goroutine 执行
 }
 
+// 待建索引的文档
+type rawDocument struct {
+	url      string
+	document string          // HTML
+	parsed   *parsedDocument // 不为 nil 时直接使用，不再解析 document，用于锚文本变化后重新建索引
+	links    []string        // 页面中的出链，为 nil 表示没有出链信息（不是爬虫发送的文档）
+}
+
 // 倒排索引 token->tokenIndexItem
 type invertedIndex map[string]*tokenIndexItem
 
@@ -49,20 +57,20 @@ type postingsList struct {
 
 // 文本处理器
 type textProcessor struct {
-	db *db.IndexDB
-	n  int // n-gram
+	db       *db.IndexDB
+	analyzer Analyzer // 建索引和检索使用同一个分析器
 }
 
-func newTextProcessor(n int, db *db.IndexDB) *textProcessor {
+func newTextProcessor(analyzer Analyzer, db *db.IndexDB) *textProcessor {
 	return &textProcessor{
-		db: db,
-		n:  n,
+		db:       db,
+		analyzer: analyzer,
 	}
 }
 
 func newIndexManager(db *db.IndexDB, textProcessor *textProcessor, bufferFlushThreshold int) *indexManager {
 	m := &indexManager{
-		indexChannel:         make(chan [2]string, config.GetInt("indexer.indexChannelLength")),
+		indexChannel:         make(chan *rawDocument, config.GetInt("indexer.indexChannelLength")),
 		mergeChannel:         make(chan invertedIndex, config.GetInt("indexer.mergeChannelLength")),
 		flushChannel:         make(chan invertedIndex, config.GetInt("indexer.flushChannelLength")),
 		indexBuffer:          make(invertedIndex),
@@ -82,21 +90,55 @@ func newIndexManager(db *db.IndexDB, textProcessor *textProcessor, bufferFlushTh
 	return m
 }
 
-func (p *textProcessor) textToInvertedIndex(documentId int, document *parsedDocument) invertedIndex {
+// 文档的字段，用于计算 BM25F 的字段长度
+const (
+	fieldTitle = iota
+	fieldBody
+	fieldAnchor      // 其他页面链接到这个页面时使用的文字
+	fieldBoilerplate // 导航、页脚等模板文字
+	fieldCount
+)
+
+// 模板文字、锚文本的位置分别从 boilerplateBase、anchorBase 开始，和正文的位置放在一起（positions[titleEnd:]），
+// 这样不用修改倒排列表的格式，短语也不会跨越不同的字段。超过下一个字段开始位置的部分不建索引
+const (
+	boilerplateBase = 1 << 25
+	anchorBase      = 1 << 26
+)
+
+// 将文档转换成倒排索引，同时返回各字段的长度（词元数量）
+func (p *textProcessor) textToInvertedIndex(documentId int, document *parsedDocument) (invertedIndex, []int) {
 	index := invertedIndex{}
-	nGramSplit(document.title, p.n, func(token string, pos int) error {
+	fieldLengths := make([]int, fieldCount)
+	p.analyzer.Analyze(document.title, func(token string, pos int) error {
+		fieldLengths[fieldTitle]++
 		return p.tokenToPostingsLists(index, documentId, token, pos, true)
 	})
-	nGramSplit(document.body, p.n, func(token string, pos int) error {
+	p.analyzer.Analyze(document.body, func(token string, pos int) error {
+		if pos >= boilerplateBase {
+			return nil
+		}
+		fieldLengths[fieldBody]++
 		return p.tokenToPostingsLists(index, documentId, token, pos, false)
 	})
-	return index
+	p.analyzer.Analyze(document.boilerplate, func(token string, pos int) error {
+		if boilerplateBase+pos >= anchorBase {
+			return nil
+		}
+		fieldLengths[fieldBoilerplate]++
+		return p.tokenToPostingsLists(index, documentId, token, boilerplateBase+pos, false)
+	})
+	p.analyzer.Analyze(document.anchor, func(token string, pos int) error {
+		fieldLengths[fieldAnchor]++
+		return p.tokenToPostingsLists(index, documentId, token, anchorBase+pos, false)
+	})
+	return index, fieldLengths
 }
 
 // 将查询内容转换成倒排索引形式
 func (p *textProcessor) queryToTokens(query string) []*tokenIndexItem {
 	index := invertedIndex{}
-	nGramSplit(query, p.n, func(token string, pos int) error {
+	p.analyzer.AnalyzeQuery(query, func(token string, pos int) error {
 		return p.tokenToPostingsLists(index, searchDocId, token, pos, false)
 	})
 	ret := make([]*tokenIndexItem, 0, len(index))
@@ -135,6 +177,15 @@ func (p *textProcessor) tokenToPostingsLists(index invertedIndex, documentId int
 	return nil
 }
 
+// 设置单个文档的倒排索引的文档 ID
+func (i invertedIndex) setDocumentId(documentId int) {
+	for _, item := range i {
+		for p := item.postings; p != nil; p = p.next {
+			p.documentId = documentId
+		}
+	}
+}
+
 // 合并倒排索引
 func (i invertedIndex) merge(index invertedIndex) {
 	for tokenId, item := range index {
@@ -172,8 +223,9 @@ func (p *postingsList) merge(postings *postingsList) *postingsList {
 	return head.next
 }
 
-// 将倒排列表编码成二进制数据，使用 variable-byte 编码压缩索引
-func (p *postingsList) encode() []byte {
+// 旧版本（格式 0）的编码：依次写入每个文档的 ID、位置数量、titleEnd 和位置，都是绝对值，
+// 只用于读写旧版本的段，新的段使用 encode，见 postings.go
+func (p *postingsList) encodeLegacy() []byte {
 	var buf []byte
 	tmp := make([]byte, binary.MaxVarintLen64)
 	for p := p; p != nil; p = p.next {
@@ -191,8 +243,8 @@ func (p *postingsList) encode() []byte {
 	return buf
 }
 
-// 将二进制数据解码成倒排列表
-func decodePostings(data []byte) (*postingsList, int) {
+// 将旧版本（格式 0）的二进制数据解码成倒排列表
+func decodeLegacyPostings(data []byte) (*postingsList, int) {
 	head := new(postingsList)
 	p := head
 	postingsLength := 0
@@ -223,18 +275,41 @@ func decodePostings(data []byte) (*postingsList, int) {
 
 func (m *indexManager) indexer() {
 	for doc := range m.indexChannel {
-		parsedDocument := parseDocument(doc[1])
-		if parsedDocument == nil {
-			continue
+		if index := m.indexDocument(doc); index != nil {
+			m.mergeChannel <- index
 		}
-		docId, err := m.db.AddDocument(doc[0], parsedDocument.title, parsedDocument.body)
-		if err != nil {
+	}
+}
+
+// 解析并保存文档，返回文档的倒排索引，文档无法解析或保存失败时返回 nil
+func (m *indexManager) indexDocument(doc *rawDocument) invertedIndex {
+	parsedDocument := doc.parsed
+	if parsedDocument == nil {
+		if parsedDocument = parseDocument(doc.document); parsedDocument == nil {
+			return nil
+		}
+	}
+	parsedDocument.anchor = m.db.GetAnchorText(doc.url)
+	// 字段长度和文档一起保存，文档 ID 在保存以后才知道
+	index, fieldLengths := m.textProcessor.textToInvertedIndex(0, parsedDocument)
+	docId, err := m.db.AddDocument(doc.url, parsedDocument.title, parsedDocument.body, fieldLengths)
+	if err != nil {
+		log.Println(err.Error())
+		return nil
+	}
+	index.setDocumentId(docId)
+	if err = m.db.SetDocumentAnchor(doc.url, docId, parsedDocument.anchor); err != nil {
+		log.Println(err.Error())
+	}
+	if err = m.db.SetDocumentBoilerplate(docId, parsedDocument.boilerplate); err != nil {
+		log.Println(err.Error())
+	}
+	if doc.links != nil {
+		if err = m.db.SetDocumentLinks(doc.url, doc.links); err != nil {
 			log.Println(err.Error())
-			continue
 		}
-		index := m.textProcessor.textToInvertedIndex(docId, parsedDocument)
-		m.mergeChannel <- index
 	}
+	return index
 }
 
 // 将 index 合并进索引管理器，只能被单个 goroutine 执行
@@ -259,25 +334,46 @@ func (m *indexManager) merger() {
 	}
 }
 
-// 将内存中的缓冲的索引与存储器中的索引合并后刷新到存储器中
+// 将内存中缓冲的索引写成新的段
 func (m *indexManager) flusher() {
-	buf := make([]byte, binary.MaxVarintLen64)
 	for index := range m.flushChannel {
-		m.db.UpdatePostings(func(tx *bolt.Tx) error {
-			bucketPostings := tx.Bucket(db.BucketTokenPostings)
-			bucketDocCount := tx.Bucket(db.BucketTokenDocCount)
+		if err := m.writeSegment(index); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
 
-			for token, item := range index {
-				tokenKey := []byte(token)
-				// 获取 token 对应的倒排列表 -> 解码 -> 内存中合并 -> 编码 -> 写回
-				data := bucketPostings.Get(tokenKey)
-				postings, docCount := decodePostings(data)
-				postings = postings.merge(item.postings)
-				docCount += item.documentCount
-				_ = bucketPostings.Put(tokenKey, postings.encode())
-				_ = bucketDocCount.Put(tokenKey, util.EncodeVarInt(buf, int64(docCount)))
+// 把索引写成一个新的段，不需要读取已有的倒排列表，写入开销和索引规模无关
+func (m *indexManager) writeSegment(index invertedIndex) error {
+	return m.db.UpdatePostings(func(tx *bolt.Tx) error {
+		bucketSegment, name, err := db.NewSegment(tx)
+		if err != nil {
+			return err
+		}
+		bucketDocCount := tx.Bucket(db.BucketTokenDocCount)
+		info := &db.SegmentInfo{Name: name, MinDocId: -1, Format: postingsFormatBlock}
+		for token, item := range index {
+			tokenKey := []byte(token)
+			data := item.postings.encode()
+			if err = bucketSegment.Put(tokenKey, data); err != nil {
+				return err
 			}
-			return nil
-		})
-	}
+			info.Size += len(data)
+			// 文档在段中是有序的
+			if info.MinDocId < 0 || item.postings.documentId < info.MinDocId {
+				info.MinDocId = item.postings.documentId
+			}
+			for p := item.postings; p != nil; p = p.next {
+				info.MaxDocId = util.MaxInt(info.MaxDocId, p.documentId)
+			}
+			// 词元的文档数量只需要读写一个整数
+			docCount, _ := binary.Varint(bucketDocCount.Get(tokenKey))
+			docCount += int64(item.documentCount)
+			buf := make([]byte, binary.MaxVarintLen64)
+			if err = bucketDocCount.Put(tokenKey, util.EncodeVarInt(buf, docCount)); err != nil {
+				return err
+			}
+		}
+		return db.PutSegmentInfo(tx, info)
+	})
 }
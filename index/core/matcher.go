@@ -0,0 +1,440 @@
+// 查询语法树的求值：按文档 ID 递增的顺序找出匹配的文档（document-at-a-time）。
+// 关键词、短语以及由它们组成的 AND、OR 可以遍历匹配的文档（docMatcher），
+// site:、inurl: 和排除条件只能判断一个文档是否满足（docFilter），要和可遍历的条件一起用（AND）。
+// MaxScore：每个关键词根据倒排列表头部记录的各词元的最大词频算出分数的上界，检索时告诉 docMatcher
+// 进入前 k 个需要的最低分数（阈值），OR 把子条件按上界升序排列，上界之和不超过阈值的那部分子条件是非必要的，
+// 只匹配它们的文档不可能进入前 k 个，只用其余（必要的）子条件找候选文档，非必要的子条件只在 collect 时跟上，
+// 不用遍历它们的倒排列表中的每个文档。AND 把阈值减去其他子条件的上界后传给子条件
+package core
+
+import (
+	"math"
+	"search-engine/index/db"
+	"search-engine/index/util"
+	"sort"
+	"strings"
+)
+
+// 没有更多的文档了
+const noMoreDocs = math.MaxInt32
+
+type docMatcher interface {
+	// 移动到第一个不小于 target 的匹配的文档，返回其 ID，没有时返回 noMoreDocs。
+	// 当前文档已经不小于 target 时不移动，target 要递增
+	advance(target int) int
+	// 当前文档为 docId 时，将文档匹配的关键词追加到 matches 中，用于打分和高亮
+	collect(docId int, matches []keywordMatch) []keywordMatch
+	// 文档在这个条件中的分数（不含静态分数）的上界，段没有记录最大词频时为 +Inf
+	maxScore() float64
+	// 之后只需要找出分数（不含静态分数）大于 score 的文档，分数不超过 score 的文档可以跳过，score 只增不减
+	setMinScore(score float64)
+}
+
+type docFilter interface {
+	// docId 要递增
+	accept(docId int) bool
+}
+
+// 将语法树编译成 docMatcher 或 docFilter，两者只有一个不为 nil；关键词全是停用词或标点时都为 nil
+// docsCount 是计算分数时使用的文档总数
+func (s *searcher) compile(node *queryNode, docsCount int) (docMatcher, docFilter, error) {
+	switch node.typ {
+	case nodeKeyword, nodePhrase:
+		q := s.newKeywordQuery(node.text, docsCount)
+		if q == nil {
+			return nil, nil, nil
+		}
+		q.phrase, q.inTitle = node.typ == nodePhrase, node.inTitle
+		if node.synonym {
+			q.weight = synonymWeight
+		}
+		return q, nil, nil
+	case nodeSite:
+		return nil, &siteFilter{db: s.db, sites: node.sites}, nil
+	case nodeInUrl:
+		return nil, &inUrlFilter{db: s.db, text: strings.ToLower(node.text)}, nil
+	case nodeNot:
+		m, f, err := s.compile(node.children[0], docsCount)
+		if m != nil {
+			return nil, &notFilter{matcher: m}, err
+		} else if f != nil {
+			return nil, &notFilter{filter: f}, err
+		}
+		return nil, nil, err
+	}
+
+	var matchers []docMatcher
+	var filters []docFilter
+	for _, child := range node.children {
+		m, f, err := s.compile(child, docsCount)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m != nil {
+			matchers = append(matchers, m)
+		} else if f != nil {
+			filters = append(filters, f)
+		}
+	}
+	if node.typ == nodeOr {
+		if len(matchers) > 0 && len(filters) > 0 {
+			return nil, nil, &QueryError{Pos: node.pos, Msg: "OR 不能连接关键词和 site:、inurl:、排除条件"}
+		} else if len(matchers) == 1 {
+			return matchers[0], nil, nil
+		} else if len(matchers) > 1 {
+			return newOrMatcher(matchers), nil, nil
+		} else if len(filters) > 0 {
+			return nil, &orFilter{filters: filters}, nil
+		}
+		return nil, nil, nil
+	}
+	// AND
+	if len(matchers) == 0 {
+		if len(filters) > 0 {
+			return nil, &andFilter{filters: filters}, nil
+		}
+		return nil, nil, nil
+	} else if len(matchers) == 1 && len(filters) == 0 {
+		return matchers[0], nil, nil
+	}
+	return &andMatcher{matchers: matchers, filters: filters, cur: -1}, nil, nil
+}
+
+///////////////////// 关键词 //////////////////////
+
+// 一个关键词的检索条件，关键词中的所有词元都要出现
+type keywordQuery struct {
+	tokens    []*tokenIndexItem
+	iterators []postingsIterator // 和 tokens 一一对应
+	phrase    bool               // 词元要相邻
+	inTitle   bool               // 要出现在标题中
+	weight    float64            // 分数的权重，同义词低于原词
+	bound     float64            // 权重为 1 时分数的上界
+	cur       int
+}
+
+// 文档在一个关键词中各个词元的倒排列表项
+type keywordMatch struct {
+	tokens  []*tokenIndexItem
+	cursors []docSearchCursor
+	weight  float64
+}
+
+// 关键词全是停用词或标点时返回 nil
+func (s *searcher) newKeywordQuery(keyword string, docsCount int) *keywordQuery {
+	tokens := s.textProcessor.queryToTokens(keyword)
+	if len(tokens) == 0 {
+		return nil
+	}
+	// 将词元按文档数量升序排序，以第一个词元的倒排列表（最短）为基准，这样可以尽早结束比较
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].documentCount < tokens[j].documentCount
+	})
+	q := &keywordQuery{tokens: tokens, iterators: make([]postingsIterator, len(tokens)), weight: 1, cur: -1}
+	for i, item := range tokens {
+		q.iterators[i] = newPostingsIterator(s.db.FetchPostings(item.token))
+	}
+	q.bound = s.keywordBound(tokens, q.iterators, docsCount)
+	return q
+}
+
+// 由各词元的最大词频计算关键词分数的上界，有词元没有记录最大词频时为 +Inf
+func (s *searcher) keywordBound(tokens []*tokenIndexItem, iterators []postingsIterator, docsCount int) float64 {
+	tfs := make([][fieldCount]int, len(iterators))
+	for i, iterator := range iterators {
+		tf, ok := iterator.MaxTf()
+		if !ok {
+			return math.Inf(1)
+		}
+		total := 0
+		for _, n := range tf {
+			total += n
+		}
+		// 词元不在任何文档中，关键词不会匹配任何文档
+		if total == 0 {
+			return 0
+		}
+		tfs[i] = tf
+	}
+	return s.keywordMaxScore(tokens, 1, tfs, docsCount)
+}
+
+func (q *keywordQuery) advance(target int) int {
+	if q.cur >= target {
+		return q.cur
+	}
+	for {
+		q.cur = q.nextCandidate(target)
+		if q.cur == noMoreDocs || q.verify() {
+			return q.cur
+		}
+		target = q.cur + 1
+	}
+}
+
+// 第一个不小于 target 且包含所有词元的文档
+func (q *keywordQuery) nextCandidate(target int) int {
+	for {
+		// 以第一个词元为基准，其他词元跳到第一个不小于基准 docId 的文档，可以根据跳表跳过整块
+		q.iterators[0].Advance(target)
+		if q.iterators[0].Current() == nil {
+			return noMoreDocs
+		}
+		target = q.iterators[0].Current().documentId
+		found := true
+		for _, iterator := range q.iterators[1:] {
+			iterator.Advance(target)
+			// iterator 中的所有文档id都小于target，则不可能有结果
+			if iterator.Current() == nil {
+				return noMoreDocs
+			}
+			if docId := iterator.Current().documentId; docId > target {
+				target, found = docId, false
+				break
+			}
+		}
+		if found {
+			return target
+		}
+	}
+}
+
+// 检查短语和标题的要求
+func (q *keywordQuery) verify() bool {
+	if !q.phrase && !q.inTitle {
+		return true
+	}
+	m := q.match()
+	if !q.phrase {
+		for _, cursor := range m.cursors {
+			if cursor.titleEnd <= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return searchPhrase(m.tokens, m.cursors, true) > 0 || (!q.inTitle && searchPhrase(m.tokens, m.cursors, false) > 0)
+}
+
+func (q *keywordQuery) match() keywordMatch {
+	m := keywordMatch{tokens: q.tokens, cursors: make([]docSearchCursor, len(q.iterators)), weight: q.weight}
+	for i, iterator := range q.iterators {
+		m.cursors[i] = iterator.Current()
+	}
+	return m
+}
+
+func (q *keywordQuery) collect(docId int, matches []keywordMatch) []keywordMatch {
+	if q.cur != docId {
+		return matches
+	}
+	return append(matches, q.match())
+}
+
+func (q *keywordQuery) maxScore() float64 {
+	return q.weight * q.bound
+}
+
+// 关键词的所有词元都要出现，不能跳过
+func (q *keywordQuery) setMinScore(float64) {}
+
+///////////////////// AND、OR //////////////////////
+
+type andMatcher struct {
+	matchers []docMatcher
+	filters  []docFilter
+	cur      int
+}
+
+func (n *andMatcher) advance(target int) int {
+	if n.cur >= target {
+		return n.cur
+	}
+	for {
+		n.cur = n.matchers[0].advance(target)
+		if n.cur == noMoreDocs {
+			return n.cur
+		}
+		found := true
+		for _, m := range n.matchers[1:] {
+			if docId := m.advance(n.cur); docId > n.cur {
+				target, found = docId, false
+				break
+			}
+		}
+		if found {
+			if n.acceptAll(n.cur) {
+				return n.cur
+			}
+			target = n.cur + 1
+		}
+	}
+}
+
+func (n *andMatcher) acceptAll(docId int) bool {
+	for _, f := range n.filters {
+		if !f.accept(docId) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *andMatcher) collect(docId int, matches []keywordMatch) []keywordMatch {
+	if n.cur != docId {
+		return matches
+	}
+	for _, m := range n.matchers {
+		matches = m.collect(docId, matches)
+	}
+	return matches
+}
+
+func (n *andMatcher) maxScore() float64 {
+	return sumMaxScore(n.matchers, -1)
+}
+
+// 文档要匹配所有子条件，子条件的分数要大于 score 减去其他子条件的上界
+func (n *andMatcher) setMinScore(score float64) {
+	for i, m := range n.matchers {
+		m.setMinScore(score - sumMaxScore(n.matchers, i))
+	}
+}
+
+// 除了 matchers[skip] 以外的子条件的上界之和
+func sumMaxScore(matchers []docMatcher, skip int) float64 {
+	var sum float64
+	for i, m := range matchers {
+		if i != skip {
+			sum += m.maxScore()
+		}
+	}
+	return sum
+}
+
+type orMatcher struct {
+	matchers []docMatcher
+	// 按分数上界升序排列的子条件的下标，order[:skipped] 是非必要的子条件，它们的上界之和不超过阈值
+	order   []int
+	skipped int
+	cur     int
+}
+
+func newOrMatcher(matchers []docMatcher) *orMatcher {
+	n := &orMatcher{matchers: matchers, order: make([]int, len(matchers)), cur: -1}
+	for i := range n.order {
+		n.order[i] = i
+	}
+	sort.SliceStable(n.order, func(i, j int) bool {
+		return matchers[n.order[i]].maxScore() < matchers[n.order[j]].maxScore()
+	})
+	return n
+}
+
+// 只用必要的子条件找候选文档，都是非必要的子条件时结束
+func (n *orMatcher) advance(target int) int {
+	if n.cur >= target {
+		return n.cur
+	}
+	n.cur = noMoreDocs
+	for _, i := range n.order[n.skipped:] {
+		n.cur = util.MinInt(n.cur, n.matchers[i].advance(target))
+	}
+	return n.cur
+}
+
+// 只有当前文档是 docId 的子条件匹配了，非必要的子条件在这时才移动到 docId
+func (n *orMatcher) collect(docId int, matches []keywordMatch) []keywordMatch {
+	if n.cur != docId {
+		return matches
+	}
+	for _, m := range n.matchers {
+		if m.advance(docId) == docId {
+			matches = m.collect(docId, matches)
+		}
+	}
+	return matches
+}
+
+func (n *orMatcher) maxScore() float64 {
+	return sumMaxScore(n.matchers, -1)
+}
+
+// 阈值不传给子条件：非必要的子条件在 collect 时要准确地判断是否匹配，不能跳过文档
+func (n *orMatcher) setMinScore(score float64) {
+	var sum float64
+	for skipped, i := range n.order {
+		if sum += n.matchers[i].maxScore(); sum > score {
+			n.skipped = util.MaxInt(n.skipped, skipped)
+			return
+		}
+	}
+	n.skipped = len(n.order)
+}
+
+type andFilter struct {
+	filters []docFilter
+}
+
+func (f *andFilter) accept(docId int) bool {
+	for _, filter := range f.filters {
+		if !filter.accept(docId) {
+			return false
+		}
+	}
+	return true
+}
+
+type orFilter struct {
+	filters []docFilter
+}
+
+func (f *orFilter) accept(docId int) bool {
+	for _, filter := range f.filters {
+		if filter.accept(docId) {
+			return true
+		}
+	}
+	return false
+}
+
+///////////////////// 排除、site:、inurl: //////////////////////
+
+// matcher 和 filter 只有一个不为 nil
+type notFilter struct {
+	matcher docMatcher
+	filter  docFilter
+}
+
+func (f *notFilter) accept(docId int) bool {
+	if f.matcher != nil {
+		return f.matcher.advance(docId) != docId
+	}
+	return !f.filter.accept(docId)
+}
+
+type siteFilter struct {
+	db    *db.IndexDB
+	sites []string
+}
+
+// 文档的域名是 site 或其子域名
+func (f *siteFilter) accept(docId int) bool {
+	host := strings.ToLower(util.UrlToHost(f.db.GetDocumentUrl(docId)))
+	for _, site := range f.sites {
+		// 按域名的标号比较，site:a.com 不能匹配 data.com
+		if host == site || strings.HasSuffix(host, "."+site) {
+			return true
+		}
+	}
+	return false
+}
+
+type inUrlFilter struct {
+	db   *db.IndexDB
+	text string // 小写
+}
+
+func (f *inUrlFilter) accept(docId int) bool {
+	return strings.Contains(strings.ToLower(f.db.GetDocumentUrl(docId)), f.text)
+}
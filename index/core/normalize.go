@@ -0,0 +1,87 @@
+// 文本规范化和字符分类，建索引和检索时都要先规范化，这样全角/半角、繁体/简体都能互相检索到
+package core
+
+import (
+	"bufio"
+	_ "embed"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed dict/t2s.txt
+var t2sData string
+
+// 繁体字 -> 简体字
+var t2s = make(map[rune]rune, 3000)
+
+func init() {
+	scanner := bufio.NewScanner(strings.NewReader(t2sData))
+	for scanner.Scan() {
+		line := []rune(strings.TrimSpace(scanner.Text()))
+		if len(line) != 2 || line[0] == '#' {
+			continue
+		}
+		t2s[line[0]] = line[1]
+	}
+}
+
+// 规范化文本：NFKC（全角字母数字、标点转为半角，半角片假名转为全角，兼容字符分解如 ㎏ => kg，
+// 组合字符合并如 e+´ => é），然后繁体字转为简体字。
+// 规范化可能改变字符数量，所以同时返回每个字符在原文本中的位置（字符下标），词元的位置始终是原文本中的位置，用于高亮
+func normalizeText(text string) ([]rune, []int) {
+	chars := make([]rune, 0, len(text))
+	offsets := make([]int, 0, len(text))
+	var it norm.Iter
+	it.InitString(norm.NFKC, text)
+	runeIdx, bytePos := 0, 0 // 当前片段在原文本中的字符下标、字节下标
+	for !it.Done() {
+		start := it.Pos()
+		runeIdx += utf8.RuneCountInString(text[bytePos:start])
+		bytePos = start
+		for _, ch := range string(it.Next()) {
+			if simplified, ok := t2s[ch]; ok {
+				ch = simplified
+			}
+			chars = append(chars, ch)
+			offsets = append(offsets, runeIdx)
+		}
+	}
+	return chars, offsets
+}
+
+// 字符类别
+const (
+	charOther = iota // 标点、空白、符号等，不建索引
+	charHan          // 汉字，包括扩展区
+	charKana         // 日文假名
+	charWord         // 其他文字的字母、数字，如拉丁字母、西里尔字母、谚文，单词之间有空格
+)
+
+func charClass(ch rune) int {
+	switch {
+	case unicode.Is(unicode.Han, ch):
+		return charHan
+	case unicode.In(ch, unicode.Hiragana, unicode.Katakana) || ch == 'ー':
+		return charKana
+	case unicode.IsLetter(ch) || unicode.IsNumber(ch) || unicode.IsMark(ch):
+		return charWord
+	}
+	return charOther
+}
+
+// 将文本切分成由同类字符组成的片段，fn 的参数为片段的类别和区间 [start, end)
+func splitRuns(chars []rune, fn func(class, start, end int)) {
+	for i := 0; i < len(chars); {
+		class := charClass(chars[i])
+		j := i + 1
+		for j < len(chars) && charClass(chars[j]) == class {
+			j++
+		}
+		if class != charOther {
+			fn(class, i, j)
+		}
+		i = j
+	}
+}
@@ -0,0 +1,190 @@
+// PageRank：爬虫随文档发送页面的出链，各个索引服务器只保存自己的文档的出链，
+// 计算时从其他索引服务器获取它们的出链，在整个链接图上迭代计算，然后保存本地文档的 PageRank。
+// 检索时文档的分数乘以 PageRank^ranking.pageRankWeight
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"search-engine/index/db"
+	"time"
+)
+
+const (
+	pageRankDamping       = 0.85 // 阻尼系数，沿链接跳转的概率
+	pageRankMaxIterations = 50
+	pageRankTolerance     = 1e-6 // 两次迭代的差（各节点差的绝对值之和，按平均值为 1 计算前）小于该值时停止
+)
+
+// 页面及其出链
+type PageLinks struct {
+	Url   string   `json:"url"`
+	Links []string `json:"links"`
+}
+
+var peerClient = &http.Client{Timeout: time.Minute}
+
+// 定时计算 PageRank，interval 为计算间隔（秒），小于等于 0 时不计算
+func (e *Engine) startPageRankGoroutine(interval int) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			// 启动时其他索引服务器可能还没有注册，等一个间隔再计算
+			time.Sleep(time.Duration(interval) * time.Second)
+			if err := e.updatePageRank(); err != nil {
+				log.Println("计算 PageRank 失败：" + err.Error())
+			}
+		}
+	}()
+}
+
+// 本地保存的所有页面的出链，供其他索引服务器计算 PageRank
+func (e *Engine) LocalLinks() []PageLinks {
+	var pages []PageLinks
+	e.DB.ForEachDocumentLinks(func(url string, links []string) {
+		pages = append(pages, PageLinks{Url: url, Links: links})
+	})
+	return pages
+}
+
+func (e *Engine) updatePageRank() error {
+	// 缺少任何一个索引服务器的出链都会使结果偏差很大，这时放弃本次计算
+	peers, err := peerIndexers()
+	if err != nil {
+		return err
+	}
+	graph := make(map[string][]string)
+	e.DB.ForEachDocumentLinks(func(url string, links []string) {
+		graph[url] = links
+	})
+	for _, addr := range peers {
+		pages, err := fetchPeerLinks(addr)
+		if err != nil {
+			return err
+		}
+		for _, page := range pages {
+			graph[page.Url] = page.Links
+		}
+	}
+	if len(graph) == 0 {
+		return nil
+	}
+	begin := time.Now()
+	ranks := pageRank(graph)
+	log.Printf("PageRank 计算完成，%d 个页面，耗时 %.1fs\n", len(ranks), time.Since(begin).Seconds())
+	return e.DB.SetPageRanks(ranks)
+}
+
+// 其他分片的索引服务器地址，每个分片取一个副本，同一分片的副本和本机的出链相同
+func peerIndexers() ([]string, error) {
+	if db.Registry == nil {
+		return nil, nil
+	}
+	topology, err := loadTopology()
+	if err != nil {
+		return nil, err
+	}
+	self := ShardName()
+	var peers []string
+	for shard, replicas := range topology.replicas {
+		if shard == self {
+			continue
+		}
+		if healthy := topology.healthyReplicas(shard); len(healthy) > 0 {
+			replicas = healthy
+		}
+		peers = append(peers, replicas[rand.Intn(len(replicas))])
+	}
+	return peers, nil
+}
+
+func fetchPeerLinks(addr string) ([]PageLinks, error) {
+	resp, err := peerClient.Get(fmt.Sprintf("http://%s/links", addr))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var r struct {
+		Code int         `json:"code"`
+		Msg  string      `json:"msg"`
+		Data []PageLinks `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	} else if r.Code != 0 {
+		return nil, fmt.Errorf("%s: %s", addr, r.Msg)
+	}
+	return r.Data, nil
+}
+
+// 在链接图上迭代计算 PageRank，graph 为页面 -> 出链，出链指向的页面也是节点。
+// 没有出链的页面的 PageRank 平均分给所有页面。返回值乘以了节点数量，平均值为 1
+func pageRank(graph map[string][]string) map[string]float64 {
+	ids := make(map[string]int)
+	var urls []string
+	id := func(url string) int {
+		if i, ok := ids[url]; ok {
+			return i
+		}
+		ids[url] = len(urls)
+		urls = append(urls, url)
+		return len(urls) - 1
+	}
+	var edges [][2]int
+	for url, links := range graph {
+		from := id(url)
+		seen := make(map[int]bool, len(links))
+		for _, link := range links {
+			// 重复的链接和指向自己的链接只算一次、不算
+			if to := id(link); to != from && !seen[to] {
+				seen[to] = true
+				edges = append(edges, [2]int{from, to})
+			}
+		}
+	}
+
+	n := len(urls)
+	outDegree := make([]int, n)
+	for _, edge := range edges {
+		outDegree[edge[0]]++
+	}
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	for iteration := 0; iteration < pageRankMaxIterations; iteration++ {
+		dangling := 0.0
+		for i := range next {
+			next[i] = 0
+			if outDegree[i] == 0 {
+				dangling += rank[i]
+			}
+		}
+		for _, edge := range edges {
+			next[edge[1]] += rank[edge[0]] / float64(outDegree[edge[0]])
+		}
+		base := (1-pageRankDamping)/float64(n) + pageRankDamping*dangling/float64(n)
+		delta := 0.0
+		for i := range next {
+			next[i] = base + pageRankDamping*next[i]
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if delta < pageRankTolerance {
+			break
+		}
+	}
+
+	ret := make(map[string]float64, n)
+	for i, url := range urls {
+		ret[url] = rank[i] * float64(n)
+	}
+	return ret
+}
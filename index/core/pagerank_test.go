@@ -0,0 +1,57 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPageRank(t *testing.T) {
+	ranks := pageRank(map[string][]string{
+		"a": {"b", "c"},
+		"b": {"c", "b"}, // 指向自己的链接不算
+		"c": {"a"},
+		"d": {"c", "c"}, // 重复的链接只算一次
+		// e 只是链接的目标，没有出链
+		"f": {"e"},
+	})
+	sum := 0.0
+	for _, rank := range ranks {
+		sum += rank
+	}
+	if len(ranks) != 6 || math.Abs(sum-6) > 1e-6 {
+		t.Fatal(ranks)
+	}
+	// c 的入链最多，d、f 没有入链
+	if !(ranks["c"] > ranks["a"] && ranks["a"] > ranks["b"] && ranks["b"] > ranks["d"]) {
+		t.Error(ranks)
+	}
+	if math.Abs(ranks["d"]-ranks["f"]) > 1e-9 || ranks["e"] <= ranks["f"] {
+		t.Error(ranks)
+	}
+}
+
+func TestSearcher_PageRankBoost(t *testing.T) {
+	s := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com": {"搜索引擎", "倒排索引"},
+		"http://b.com": {"搜索引擎", "倒排索引"},
+	})
+	top := func() string {
+		r := searchQuery(t, s, "搜索引擎", 1, SortByScore)
+		r.sortResults()
+		return s.db.GetDocumentUrl(r.Items[0].docId)
+	}
+	s.pageRankWeight = 0.5
+	for _, url := range []string{"http://a.com", "http://b.com"} {
+		if err := s.db.SetPageRanks(map[string]float64{url: 4, "http://c.com": 0.5}); err != nil {
+			t.Fatal(err)
+		}
+		if got := top(); got != url {
+			t.Error(url, got)
+		}
+	}
+	// 重新抓取的文档沿用 URL 的 PageRank
+	docId, _ := s.db.AddDocument("http://b.com", "搜索引擎", "倒排索引", nil)
+	if s.db.GetPageRank(docId) != 4 {
+		t.Error(s.db.GetPageRank(docId))
+	}
+}
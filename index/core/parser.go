@@ -1,37 +1,384 @@
-// 解析 HTML 文档
+// 解析 HTML 文档：用 DOM 解析器解码实体，去掉脚本、样式和隐藏的内容，然后用类似 readability 的
+// 文本密度算法找出正文所在的元素。导航、页脚、cookie 提示等正文以外的文字作为模板文字单独保存，建索引时权重较低
 package core
 
 import (
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
 type parsedDocument struct {
-	title string
-	body  string
+	title       string
+	body        string // 正文
+	boilerplate string // 导航、页脚等模板文字
+	anchor      string // 链接到这个页面的锚文本，每个来源一行，不是从文档中解析的
 	// <meta name="keywords" content="xxx">
 	// h1 []string // h1标签 权重高
 }
 
+const (
+	minParagraphLength  = 25  // 少于这个字数的文本块不参与正文元素的评分
+	maxLinkDensity      = 0.5 // 链接文字占比超过这个值的文本块当作模板文字（导航、相关链接等）
+	siblingScoreRatio   = 0.2 // 分数达到最佳元素的这个比例的兄弟元素也算正文
+	classWeight         = 25  // class、id 看起来像正文的元素的加分
+	maxTitleFallbackLen = 200 // 没有 <title> 时用 <h1> 作为标题，最多这么多字
+)
+
 var (
-	titlePattern     = regexp.MustCompile(`(?ism)<title.*?>(.*?)</title>`)
-	trimTagPattern   = regexp.MustCompile(`(?ism)<!--.*?-->|<script.*?>.*?</script>|<style.*?>.*?</style>|<title.*?>.*?</title>|<.+?>`)
-	trimSpacePattern = regexp.MustCompile(`(?m)\s+`)
+	trimSpacePattern = regexp.MustCompile(`\s+`)
+	// class、id 中的单词
+	classWordPattern = regexp.MustCompile(`[a-zA-Z]+`)
 )
 
+// 不包含正文的元素，连同子元素一起跳过
+var skippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Iframe: true, atom.Svg: true, atom.Math: true, atom.Canvas: true, atom.Object: true,
+	atom.Embed: true, atom.Select: true, atom.Textarea: true,
+}
+
+// 块级元素，前后的文字属于不同的文本块
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true, atom.Body: true,
+	atom.Br: true, atom.Caption: true, atom.Center: true, atom.Dd: true, atom.Details: true,
+	atom.Dialog: true, atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Fieldset: true,
+	atom.Figcaption: true, atom.Figure: true, atom.Footer: true, atom.Form: true, atom.H1: true,
+	atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true,
+	atom.Hr: true, atom.Html: true, atom.Li: true, atom.Main: true, atom.Menu: true, atom.Nav: true,
+	atom.Ol: true, atom.P: true, atom.Pre: true, atom.Section: true, atom.Summary: true,
+	atom.Table: true, atom.Tbody: true, atom.Td: true, atom.Tfoot: true, atom.Th: true,
+	atom.Thead: true, atom.Tr: true, atom.Ul: true,
+}
+
+// 模板元素：导航、侧栏等。页眉、页脚在 <article>、<main> 中时是正文的一部分，见 isBoilerplateElement
+var boilerplateElements = map[atom.Atom]bool{
+	atom.Nav: true, atom.Aside: true, atom.Menu: true, atom.Header: true, atom.Footer: true,
+}
+
+var boilerplateRoles = map[string]bool{
+	"navigation": true, "banner": true, "contentinfo": true, "complementary": true, "menu": true,
+	"menubar": true, "search": true, "dialog": true, "alertdialog": true,
+}
+
+// class、id 中出现这些单词时是模板元素
+var boilerplateClassWords = map[string]bool{
+	"nav": true, "navbar": true, "navigation": true, "menu": true, "header": true, "footer": true,
+	"masthead": true, "topbar": true, "sidebar": true, "breadcrumb": true, "breadcrumbs": true,
+	"cookie": true, "cookies": true, "consent": true, "gdpr": true, "banner": true, "share": true,
+	"social": true, "related": true, "comment": true, "comments": true, "copyright": true,
+	"ad": true, "ads": true, "advert": true, "advertisement": true, "sponsor": true, "popup": true,
+	"modal": true, "subscribe": true, "newsletter": true, "pagination": true, "pager": true,
+	"toolbar": true, "widget": true,
+}
+
+// class、id 中出现这些单词时可能是正文
+var contentClassWords = map[string]bool{
+	"article": true, "content": true, "main": true, "post": true, "entry": true, "story": true,
+	"text": true, "body": true, "blog": true, "detail": true,
+}
+
+// 文本块：块级元素之间的一段连续文字
+type textBlock struct {
+	text        string
+	length      int        // 字数
+	linkLength  int        // 链接文字的字数
+	element     *html.Node // 所在的块级元素
+	boilerplate bool
+}
+
+type blockBuilder struct {
+	blocks      []*textBlock
+	buf         strings.Builder
+	linkLength  int
+	element     *html.Node
+	boilerplate bool
+}
+
+// 结束当前的文本块
+func (b *blockBuilder) flush() {
+	text := strings.TrimSpace(trimSpacePattern.ReplaceAllString(b.buf.String(), " "))
+	if text != "" {
+		b.blocks = append(b.blocks, &textBlock{text: text, length: utf8.RuneCountInString(text),
+			linkLength: b.linkLength, element: b.element, boilerplate: b.boilerplate})
+	}
+	b.buf.Reset()
+	b.linkLength = 0
+}
+
+// 遍历 DOM 树，element 为最近的块级元素，inLink 表示在链接中，inArticle 表示在 <article>、<main> 中
+func (b *blockBuilder) walk(n *html.Node, element *html.Node, inLink, inArticle, boilerplate bool) {
+	switch n.Type {
+	case html.TextNode:
+		b.buf.WriteString(n.Data)
+		if inLink {
+			b.linkLength += utf8.RuneCountInString(strings.TrimSpace(n.Data))
+		}
+		return
+	case html.ElementNode:
+		if skippedElements[n.DataAtom] || isHidden(n) {
+			return
+		}
+	case html.DocumentNode:
+	default:
+		return
+	}
+	childBoilerplate := boilerplate || n.Type == html.ElementNode && isBoilerplateElement(n, inArticle)
+	isBlock := blockElements[n.DataAtom] || childBoilerplate != boilerplate
+	if isBlock {
+		b.flush()
+		element = n
+	}
+	inLink = inLink || n.DataAtom == atom.A
+	inArticle = inArticle || n.DataAtom == atom.Article || n.DataAtom == atom.Main
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		// 子元素中的块级元素结束后，剩下的文字仍然属于 element
+		b.element, b.boilerplate = element, childBoilerplate
+		b.walk(c, element, inLink, inArticle, childBoilerplate)
+	}
+	if isBlock {
+		b.flush()
+	}
+}
+
+func getAttr(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func isHidden(n *html.Node) bool {
+	if _, ok := getAttr(n, "hidden"); ok {
+		return true
+	}
+	if v, _ := getAttr(n, "aria-hidden"); strings.EqualFold(strings.TrimSpace(v), "true") {
+		return true
+	}
+	style, _ := getAttr(n, "style")
+	style = strings.ToLower(strings.ReplaceAll(style, " ", ""))
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+func isBoilerplateElement(n *html.Node, inArticle bool) bool {
+	if n.DataAtom == atom.Html || n.DataAtom == atom.Body {
+		return false
+	}
+	if boilerplateElements[n.DataAtom] && !(inArticle && (n.DataAtom == atom.Header || n.DataAtom == atom.Footer)) {
+		return true
+	}
+	if role, _ := getAttr(n, "role"); boilerplateRoles[strings.ToLower(role)] {
+		return true
+	}
+	boilerplate, content := classWords(n)
+	return boilerplate && !content
+}
+
+// class、id 中是否有模板元素、正文元素的单词，如 main-nav、article_content
+func classWords(n *html.Node) (bool, bool) {
+	var boilerplate, content bool
+	for _, key := range []string{"class", "id"} {
+		v, _ := getAttr(n, key)
+		for _, word := range classWordPattern.FindAllString(v, -1) {
+			word = strings.ToLower(word)
+			boilerplate = boilerplate || boilerplateClassWords[word]
+			content = content || contentClassWords[word]
+		}
+	}
+	return boilerplate, content
+}
+
 func parseDocument(document string) *parsedDocument {
-	parsedDocument := &parsedDocument{}
-	// title
-	result := titlePattern.FindStringSubmatch(document)
-	if len(result) == 0 {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
 		return nil
 	}
-	parsedDocument.title = strings.TrimSpace(result[1])
+	b := &blockBuilder{}
+	b.walk(root, root, false, false, false)
+	body, boilerplate := extractContent(b.blocks)
+	title := findTitle(root)
+	if title == "" && body == "" && boilerplate == "" {
+		return nil
+	}
+	return &parsedDocument{title: title, body: body, boilerplate: boilerplate}
+}
+
+// 标题：<title>，没有时依次使用 og:title、第一个 <h1>
+func findTitle(root *html.Node) string {
+	var title, ogTitle, h1 string
+	var find func(n *html.Node, inSvg bool)
+	find = func(n *html.Node, inSvg bool) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				if !inSvg && title == "" {
+					title = nodeText(n)
+				}
+			case atom.Meta:
+				if property, _ := getAttr(n, "property"); property == "og:title" && ogTitle == "" {
+					content, _ := getAttr(n, "content")
+					ogTitle = strings.TrimSpace(trimSpacePattern.ReplaceAllString(content, " "))
+				}
+			case atom.H1:
+				if h1 == "" {
+					h1 = nodeText(n)
+				}
+			case atom.Svg:
+				inSvg = true
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c, inSvg)
+		}
+	}
+	find(root, false)
+	if title != "" {
+		return title
+	} else if ogTitle != "" {
+		return ogTitle
+	}
+	if runes := []rune(h1); len(runes) > maxTitleFallbackLen {
+		return string(runes[:maxTitleFallbackLen])
+	}
+	return h1
+}
+
+// 元素中的文字，空白合并为一个空格
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteByte(' ')
+		} else if n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style) {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(trimSpacePattern.ReplaceAllString(sb.String(), " "))
+}
 
-	// body
-	document = trimTagPattern.ReplaceAllString(document, " ")
-	document = trimSpacePattern.ReplaceAllString(document, " ")
-	document = strings.TrimSpace(document)
-	parsedDocument.body = document
-	return parsedDocument
+// 元素的评分和统计，包括所有子元素中的文本块
+type elementStats struct {
+	score      float64
+	length     int
+	linkLength int
+}
+
+// 把文本块分为正文和模板文字。每个足够长的文本块按字数、标点数量评分，分数加给所在的元素，
+// 一半加给父元素、三分之一加给祖父元素，再按链接文字占比和 class 扣分、加分，分数最高的元素
+// 及分数较高的兄弟元素中的文本块是正文。没有足够长的文本块时，所有不是模板的文字都是正文
+func extractContent(blocks []*textBlock) (string, string) {
+	stats := make(map[*html.Node]*elementStats)
+	var elements []*html.Node // 按出现的顺序，使结果确定
+	get := func(n *html.Node) *elementStats {
+		s, ok := stats[n]
+		if !ok {
+			s = &elementStats{}
+			stats[n] = s
+			elements = append(elements, n)
+		}
+		return s
+	}
+	for _, block := range blocks {
+		if block.boilerplate || block.linkLength*2 > block.length {
+			continue
+		}
+		for n := block.element; n != nil; n = n.Parent {
+			s := get(n)
+			s.length += block.length
+			s.linkLength += block.linkLength
+		}
+		if block.length < minParagraphLength {
+			continue
+		}
+		score := 1 + float64(countPunctuation(block.text))
+		if s := float64(block.length) / 100; s < 3 {
+			score += s
+		} else {
+			score += 3
+		}
+		for i, n := 0, block.element; i < 3 && n != nil; i, n = i+1, n.Parent {
+			get(n).score += score / float64(i+1)
+		}
+	}
+
+	var best *html.Node
+	bestScore := 0.0
+	finalScore := func(n *html.Node) float64 {
+		s := stats[n]
+		if s == nil || s.score == 0 {
+			return 0
+		}
+		score := s.score
+		if n.Type == html.ElementNode {
+			if _, content := classWords(n); content || n.DataAtom == atom.Article || n.DataAtom == atom.Main {
+				score += classWeight
+			}
+		}
+		return score * (1 - float64(s.linkLength)/float64(s.length))
+	}
+	for _, n := range elements {
+		if score := finalScore(n); score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+
+	content := make(map[*html.Node]bool)
+	if best != nil {
+		content[best] = true
+		if best.Parent != nil {
+			for n := best.Parent.FirstChild; n != nil; n = n.NextSibling {
+				if n != best && finalScore(n) >= bestScore*siblingScoreRatio {
+					content[n] = true
+				}
+			}
+		}
+	}
+	isContent := func(block *textBlock) bool {
+		if block.boilerplate || block.linkLength > int(float64(block.length)*maxLinkDensity) {
+			return false
+		}
+		if best == nil {
+			return true
+		}
+		for n := block.element; n != nil; n = n.Parent {
+			if content[n] {
+				return true
+			}
+		}
+		return false
+	}
+	var body, boilerplate []string
+	for _, block := range blocks {
+		if isContent(block) {
+			body = append(body, block.text)
+		} else {
+			boilerplate = append(boilerplate, block.text)
+		}
+	}
+	// 整个页面都像模板（例如只有一个链接列表）时，都当作正文
+	if len(body) == 0 {
+		body, boilerplate = boilerplate, nil
+	}
+	return strings.Join(body, " "), strings.Join(boilerplate, " ")
+}
+
+func countPunctuation(text string) int {
+	count := 0
+	for _, r := range text {
+		switch r {
+		case ',', '，', '、', '。', ';', '；', '!', '！', '?', '？':
+			count++
+		}
+	}
+	return count
 }
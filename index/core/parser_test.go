@@ -24,3 +24,74 @@ func TestParseDocument(t *testing.T) {
 		t.Error("|"+pd.title+"|", "\n", "|"+pd.body+"|")
 	}
 }
+
+func TestParseDocument_Entities(t *testing.T) {
+	pd := parseDocument(`<html><head><title>A &amp; B &#x4e2d;&#25991;</title></head>
+<body><p>1 &lt; 2&nbsp;&amp;&nbsp;3 &gt; 2</p><p>Ca<b>f&eacute;</b></p></body></html>`)
+	if pd.title != "A & B 中文" || pd.body != "1 < 2 & 3 > 2 Café" {
+		t.Error("|"+pd.title+"|", "|"+pd.body+"|")
+	}
+}
+
+func TestParseDocument_Hidden(t *testing.T) {
+	pd := parseDocument(`<title>t</title><body>
+<noscript>请启用 JavaScript</noscript>
+<template><p>模板</p></template>
+<div aria-hidden="true">隐藏1</div><div hidden>隐藏2</div><span style="display: none">隐藏3</span>
+<p>正文<!-- 注释 --></p>
+</body>`)
+	if pd.body != "正文" || pd.boilerplate != "" {
+		t.Error("|"+pd.body+"|", "|"+pd.boilerplate+"|")
+	}
+}
+
+func TestParseDocument_Title(t *testing.T) {
+	cases := []struct {
+		document, title string
+	}{
+		{`<body><svg><title>图标</title></svg><h1> 没有 <i>title</i> </h1><p>正文</p></body>`, "没有 title"},
+		{`<head><meta property="og:title" content="OG 标题"></head><body><h1>h1</h1></body>`, "OG 标题"},
+		{`<p>只有正文</p>`, ""},
+	}
+	for _, c := range cases {
+		pd := parseDocument(c.document)
+		if pd == nil || pd.title != c.title {
+			t.Errorf("%q %+v", c.document, pd)
+		}
+	}
+	if pd := parseDocument(`<html><body> </body></html>`); pd != nil {
+		t.Error(pd)
+	}
+}
+
+func TestParseDocument_Boilerplate(t *testing.T) {
+	pd := parseDocument(`<html><head><title>文章</title></head><body>
+<div class="top-nav"><a href="/">首页</a> <a href="/news">新闻</a></div>
+<div id="wrapper">
+  <div class="sidebar-list"><ul><li><a href="/1">热门文章一</a></li><li><a href="/2">热门文章二</a></li></ul></div>
+  <div class="post">
+    <h1>倒排索引简介</h1>
+    <p>倒排索引是搜索引擎最常用的数据结构，它记录了每个词出现在哪些文档中，以及出现的位置。</p>
+    <p>建立索引时，先把文档切分成词元，然后把文档编号追加到每个词元的倒排列表中，查询时合并倒排列表。</p>
+    <div class="share">分享到微博</div>
+  </div>
+  <ul><li><a href="/a">上一篇</a></li><li><a href="/b">下一篇</a></li></ul>
+</div>
+<footer>版权所有 © 2021</footer>
+<div id="cookie-consent">本网站使用 cookie，继续浏览表示同意。</div>
+</body></html>`)
+	body := "倒排索引简介 倒排索引是搜索引擎最常用的数据结构，它记录了每个词出现在哪些文档中，以及出现的位置。 " +
+		"建立索引时，先把文档切分成词元，然后把文档编号追加到每个词元的倒排列表中，查询时合并倒排列表。"
+	boilerplate := "首页 新闻 热门文章一 热门文章二 分享到微博 上一篇 下一篇 版权所有 © 2021 本网站使用 cookie，继续浏览表示同意。"
+	if pd.title != "文章" || pd.body != body || pd.boilerplate != boilerplate {
+		t.Error("|"+pd.body+"|", "\n", "|"+pd.boilerplate+"|")
+	}
+}
+
+func TestParseDocument_LinkList(t *testing.T) {
+	// 整个页面都是链接时不能丢掉
+	pd := parseDocument(`<title>导航页</title><ul><li><a href="/a">链接一</a></li><li><a href="/b">链接二</a></li></ul>`)
+	if pd.body != "链接一 链接二" || pd.boilerplate != "" {
+		t.Error("|"+pd.body+"|", "|"+pd.boilerplate+"|")
+	}
+}
@@ -0,0 +1,324 @@
+// Porter 词干提取算法，参考 https://tartarus.org/martin/PorterStemmer/def.txt
+// 如 connection、connected、connecting => connect
+package core
+
+// 只处理小写英文字母组成的单词
+type porterStemmer struct {
+	b []byte
+	k int // 当前词尾的下标
+	j int // 词干结尾的下标
+}
+
+func porterStem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	s := &porterStemmer{b: []byte(word), k: len(word) - 1}
+	s.step1ab()
+	if s.k > 0 {
+		s.step1c()
+		s.step2()
+		s.step3()
+		s.step4()
+		s.step5()
+	}
+	return string(s.b[:s.k+1])
+}
+
+// b[i] 是否是辅音
+func (s *porterStemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		if i == 0 {
+			return true
+		}
+		return !s.cons(i - 1)
+	}
+	return true
+}
+
+// 计算 b[0:j+1] 中 VC 序列的个数 m，<c>(VC)^m<v>
+func (s *porterStemmer) m() int {
+	n, i := 0, 0
+	for {
+		if i > s.j {
+			return n
+		}
+		if !s.cons(i) {
+			break
+		}
+		i++
+	}
+	i++
+	for {
+		for {
+			if i > s.j {
+				return n
+			}
+			if s.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+		n++
+		for {
+			if i > s.j {
+				return n
+			}
+			if !s.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+	}
+}
+
+// b[0:j+1] 中是否有元音
+func (s *porterStemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// b[j-1:j+1] 是否是两个相同的辅音
+func (s *porterStemmer) doublec(j int) bool {
+	if j < 1 || s.b[j] != s.b[j-1] {
+		return false
+	}
+	return s.cons(j)
+}
+
+// b[i-2:i+1] 是否是 辅音-元音-辅音，且最后一个辅音不是 w、x、y，如 hop
+func (s *porterStemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+	switch s.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// b[0:k+1] 是否以 suffix 结尾，是的话将 j 设为词干结尾
+func (s *porterStemmer) ends(suffix string) bool {
+	l := len(suffix)
+	if l > s.k+1 || string(s.b[s.k-l+1:s.k+1]) != suffix {
+		return false
+	}
+	s.j = s.k - l
+	return true
+}
+
+// 将 b[j+1:k+1] 替换成 str
+func (s *porterStemmer) setTo(str string) {
+	s.b = append(s.b[:s.j+1], str...)
+	s.k = s.j + len(str)
+}
+
+func (s *porterStemmer) r(str string) {
+	if s.m() > 0 {
+		s.setTo(str)
+	}
+}
+
+// 复数和 -ed、-ing，如 caresses => caress，ponies => poni，meetings => meet
+func (s *porterStemmer) step1ab() {
+	if s.b[s.k] == 's' {
+		if s.ends("sses") {
+			s.k -= 2
+		} else if s.ends("ies") {
+			s.setTo("i")
+		} else if s.k >= 1 && s.b[s.k-1] != 's' {
+			s.k--
+		}
+	}
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.k--
+		}
+	} else if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+		s.k = s.j
+		if s.ends("at") {
+			s.setTo("ate")
+		} else if s.ends("bl") {
+			s.setTo("ble")
+		} else if s.ends("iz") {
+			s.setTo("ize")
+		} else if s.doublec(s.k) {
+			s.k--
+			switch s.b[s.k] {
+			case 'l', 's', 'z':
+				s.k++
+			}
+		} else if s.j = s.k; s.m() == 1 && s.cvc(s.k) {
+			s.setTo("e")
+		}
+	}
+	s.b = s.b[:s.k+1]
+}
+
+// 词干中有元音时，结尾的 y 变为 i
+func (s *porterStemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b[s.k] = 'i'
+	}
+}
+
+// 双后缀变为单后缀，如 -ization => -ize
+func (s *porterStemmer) step2() {
+	if s.k < 1 {
+		return
+	}
+	switch s.b[s.k-1] {
+	case 'a':
+		if s.ends("ational") {
+			s.r("ate")
+		} else if s.ends("tional") {
+			s.r("tion")
+		}
+	case 'c':
+		if s.ends("enci") {
+			s.r("ence")
+		} else if s.ends("anci") {
+			s.r("ance")
+		}
+	case 'e':
+		if s.ends("izer") {
+			s.r("ize")
+		}
+	case 'l':
+		if s.ends("bli") {
+			s.r("ble")
+		} else if s.ends("alli") {
+			s.r("al")
+		} else if s.ends("entli") {
+			s.r("ent")
+		} else if s.ends("eli") {
+			s.r("e")
+		} else if s.ends("ousli") {
+			s.r("ous")
+		}
+	case 'o':
+		if s.ends("ization") {
+			s.r("ize")
+		} else if s.ends("ation") {
+			s.r("ate")
+		} else if s.ends("ator") {
+			s.r("ate")
+		}
+	case 's':
+		if s.ends("alism") {
+			s.r("al")
+		} else if s.ends("iveness") {
+			s.r("ive")
+		} else if s.ends("fulness") {
+			s.r("ful")
+		} else if s.ends("ousness") {
+			s.r("ous")
+		}
+	case 't':
+		if s.ends("aliti") {
+			s.r("al")
+		} else if s.ends("iviti") {
+			s.r("ive")
+		} else if s.ends("biliti") {
+			s.r("ble")
+		}
+	case 'g':
+		if s.ends("logi") {
+			s.r("log")
+		}
+	}
+}
+
+// -ic-、-full、-ness 等
+func (s *porterStemmer) step3() {
+	switch s.b[s.k] {
+	case 'e':
+		if s.ends("icate") {
+			s.r("ic")
+		} else if s.ends("ative") {
+			s.r("")
+		} else if s.ends("alize") {
+			s.r("al")
+		}
+	case 'i':
+		if s.ends("iciti") {
+			s.r("ic")
+		}
+	case 'l':
+		if s.ends("ical") {
+			s.r("ic")
+		} else if s.ends("ful") {
+			s.r("")
+		}
+	case 's':
+		if s.ends("ness") {
+			s.r("")
+		}
+	}
+}
+
+// 在 m>1 时去掉 -ant、-ence 等后缀
+func (s *porterStemmer) step4() {
+	if s.k < 1 {
+		return
+	}
+	matched := false
+	switch s.b[s.k-1] {
+	case 'a':
+		matched = s.ends("al")
+	case 'c':
+		matched = s.ends("ance") || s.ends("ence")
+	case 'e':
+		matched = s.ends("er")
+	case 'i':
+		matched = s.ends("ic")
+	case 'l':
+		matched = s.ends("able") || s.ends("ible")
+	case 'n':
+		matched = s.ends("ant") || s.ends("ement") || s.ends("ment") || s.ends("ent")
+	case 'o':
+		matched = (s.ends("ion") && s.j >= 0 && (s.b[s.j] == 's' || s.b[s.j] == 't')) || s.ends("ou")
+	case 's':
+		matched = s.ends("ism")
+	case 't':
+		matched = s.ends("ate") || s.ends("iti")
+	case 'u':
+		matched = s.ends("ous")
+	case 'v':
+		matched = s.ends("ive")
+	case 'z':
+		matched = s.ends("ize")
+	}
+	if matched && s.m() > 1 {
+		s.k = s.j
+	}
+}
+
+// 在 m>1 时去掉结尾的 -e，-ll 变为 -l
+func (s *porterStemmer) step5() {
+	s.j = s.k
+	if s.b[s.k] == 'e' {
+		a := s.m()
+		if a > 1 || (a == 1 && !s.cvc(s.k-1)) {
+			s.k--
+		}
+	}
+	if s.b[s.k] == 'l' && s.doublec(s.k) && s.m() > 1 {
+		s.k--
+	}
+}
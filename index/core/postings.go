@@ -0,0 +1,343 @@
+// 倒排列表的编码（分块、差值编码、跳表）和迭代器
+package core
+
+import (
+	"encoding/binary"
+	"search-engine/index/db"
+	"search-engine/index/util"
+)
+
+// 倒排列表的格式，记录在段的清单中
+const (
+	postingsFormatLegacy = 0 // 旧版本，见 encodeLegacy
+	postingsFormatBlock  = 1 // 分块、差值编码，带跳表，头部记录词元在各字段中的最大词频
+)
+
+// 每块的文档数量
+const postingsBlockSize = 128
+
+func appendUvarint(buf, tmp []byte, x uint64) []byte {
+	return append(buf, tmp[:binary.PutUvarint(tmp, x)]...)
+}
+
+func appendVarint(buf, tmp []byte, x int64) []byte {
+	return append(buf, tmp[:binary.PutVarint(tmp, x)]...)
+}
+
+// 将倒排列表编码成二进制数据（格式 1），文档每 postingsBlockSize 个一块：
+// 头部：文档数量、块数量、词元在各个字段中的最大词频（fieldCount 个，用于计算分数的上界），
+// 然后是每块的跳表项：块中最后一个文档 ID（与上一块的差值）、块的字节数；
+// 块：每个文档依次是文档 ID（与上一个文档的差值）、titleEnd、位置数量、各个位置（与上一个位置的差值，
+// 标题和正文中的位置分别从 0 开始）。
+// 检索时根据跳表就能跳过整块，而不用解码块中的每个文档
+func (p *postingsList) encode() []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	var blocks [][]byte
+	var lastDocIds []int
+	var block []byte
+	var maxTf [fieldCount]int
+	count, prevDocId := 0, 0
+	for q := p; q != nil; q = q.next {
+		for f, tf := range fieldTf(q) {
+			if tf > maxTf[f] {
+				maxTf[f] = tf
+			}
+		}
+		block = appendUvarint(block, tmp, uint64(q.documentId-prevDocId))
+		block = appendVarint(block, tmp, int64(q.titleEnd))
+		block = appendUvarint(block, tmp, uint64(len(q.positions)))
+		prevPos := 0
+		for i, pos := range q.positions {
+			if i == q.titleEnd {
+				prevPos = 0
+			}
+			block = appendVarint(block, tmp, int64(pos-prevPos))
+			prevPos = pos
+		}
+		prevDocId = q.documentId
+		count++
+		if count%postingsBlockSize == 0 || q.next == nil {
+			blocks = append(blocks, block)
+			lastDocIds = append(lastDocIds, q.documentId)
+			block = nil
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+
+	buf := appendUvarint(nil, tmp, uint64(count))
+	buf = appendUvarint(buf, tmp, uint64(len(blocks)))
+	for _, tf := range maxTf {
+		buf = appendUvarint(buf, tmp, uint64(tf))
+	}
+	prevDocId = 0
+	for i := range blocks {
+		buf = appendUvarint(buf, tmp, uint64(lastDocIds[i]-prevDocId))
+		buf = appendUvarint(buf, tmp, uint64(len(blocks[i])))
+		prevDocId = lastDocIds[i]
+	}
+	for _, b := range blocks {
+		buf = append(buf, b...)
+	}
+	return buf
+}
+
+// 将二进制数据（格式 1）解码成倒排列表，同时返回文档数量
+func decodePostings(data []byte) (*postingsList, int) {
+	head := new(postingsList)
+	p := head
+	it := newBlockIterator(data)
+	for ; it.Current() != nil; it.Next() {
+		p.next = it.Current()
+		p = p.next
+	}
+	return head.next, it.count
+}
+
+// 根据段的格式编码、解码
+func encodeSegmentPostings(p *postingsList, format int) []byte {
+	if format == postingsFormatLegacy {
+		return p.encodeLegacy()
+	}
+	return p.encode()
+}
+
+func decodeSegmentPostings(data []byte, format int) (*postingsList, int) {
+	if format == postingsFormatLegacy {
+		return decodeLegacyPostings(data)
+	}
+	return decodePostings(data)
+}
+
+// 倒排列表迭代器，按文档 ID 升序遍历，只在需要时才解码
+type postingsIterator interface {
+	// 当前的文档，遍历结束时返回 nil，返回值的 next 字段无意义
+	Current() *postingsList
+	// 移动到下一个文档
+	Next()
+	// 移动到第一个文档 ID 不小于 target 的文档，当前文档已经满足时不移动
+	Advance(target int)
+	// 词元在各字段中的最大词频，用于计算分数的上界，旧格式的段没有记录时返回 false
+	MaxTf() ([fieldCount]int, bool)
+}
+
+// 根据各个段中的倒排列表创建迭代器，多个段时合并
+func newPostingsIterator(segments []db.SegmentPostings) postingsIterator {
+	iterators := make([]postingsIterator, 0, len(segments))
+	for _, segment := range segments {
+		if segment.Format == postingsFormatLegacy {
+			postings, _ := decodeLegacyPostings(segment.Data)
+			iterators = append(iterators, &listIterator{p: postings})
+		} else {
+			iterators = append(iterators, newBlockIterator(segment.Data))
+		}
+	}
+	if len(iterators) == 1 {
+		return iterators[0]
+	}
+	m := &mergedIterator{iterators: iterators}
+	m.pick()
+	return m
+}
+
+///////////////////// 分块的倒排列表 //////////////////////
+
+type blockIterator struct {
+	data       []byte
+	count      int             // 文档数量
+	maxTf      [fieldCount]int // 词元在各字段中的最大词频
+	lastDocIds []int           // 跳表：每块最后一个文档 ID
+	offsets    []int           // 每块在 data 中的起始位置，最后一个元素是 data 的长度
+	blockIdx   int             // 当前块
+	block      []postingsList
+	idx        int // 当前文档在块中的下标
+}
+
+// 只解码头部，块在遍历到时才解码
+func newBlockIterator(data []byte) *blockIterator {
+	it := &blockIterator{data: data}
+	if len(data) == 0 {
+		return it
+	}
+	pos := 0
+	readUvarint := func() int {
+		x, n := binary.Uvarint(data[pos:])
+		pos += n
+		return int(x)
+	}
+	it.count = readUvarint()
+	blockCount := readUvarint()
+	for f := range it.maxTf {
+		it.maxTf[f] = readUvarint()
+	}
+	it.lastDocIds = make([]int, blockCount)
+	lengths := make([]int, blockCount)
+	prevDocId := 0
+	for i := 0; i < blockCount; i++ {
+		it.lastDocIds[i] = prevDocId + readUvarint()
+		lengths[i] = readUvarint()
+		prevDocId = it.lastDocIds[i]
+	}
+	it.offsets = make([]int, blockCount+1)
+	it.offsets[0] = pos
+	for i, l := range lengths {
+		it.offsets[i+1] = it.offsets[i] + l
+	}
+	it.loadBlock()
+	return it
+}
+
+// 解码当前块
+func (it *blockIterator) loadBlock() {
+	it.idx = 0
+	it.block = nil
+	if it.blockIdx >= len(it.lastDocIds) {
+		return
+	}
+	data := it.data[it.offsets[it.blockIdx]:it.offsets[it.blockIdx+1]]
+	prevDocId := 0
+	if it.blockIdx > 0 {
+		prevDocId = it.lastDocIds[it.blockIdx-1]
+	}
+	it.block = make([]postingsList, 0, postingsBlockSize)
+	for pos := 0; pos < len(data); {
+		var p postingsList
+		x, n := binary.Uvarint(data[pos:])
+		pos += n
+		p.documentId = prevDocId + int(x)
+		titleEnd, n := binary.Varint(data[pos:])
+		pos += n
+		p.titleEnd = int(titleEnd)
+		x, n = binary.Uvarint(data[pos:])
+		pos += n
+		p.positions = make([]int, x)
+		prevPos := 0
+		for i := range p.positions {
+			if i == p.titleEnd {
+				prevPos = 0
+			}
+			delta, n := binary.Varint(data[pos:])
+			pos += n
+			p.positions[i] = prevPos + int(delta)
+			prevPos = p.positions[i]
+		}
+		it.block = append(it.block, p)
+		prevDocId = p.documentId
+	}
+}
+
+func (it *blockIterator) Current() *postingsList {
+	if it.idx >= len(it.block) {
+		return nil
+	}
+	return &it.block[it.idx]
+}
+
+func (it *blockIterator) Next() {
+	if it.idx++; it.idx >= len(it.block) && it.blockIdx < len(it.lastDocIds) {
+		it.blockIdx++
+		it.loadBlock()
+	}
+}
+
+func (it *blockIterator) Advance(target int) {
+	cur := it.Current()
+	if cur == nil || cur.documentId >= target {
+		return
+	}
+	// 目标不在当前块中，根据跳表跳过整块
+	if it.lastDocIds[it.blockIdx] < target {
+		for it.blockIdx < len(it.lastDocIds) && it.lastDocIds[it.blockIdx] < target {
+			it.blockIdx++
+		}
+		it.loadBlock()
+	}
+	for it.idx < len(it.block) && it.block[it.idx].documentId < target {
+		it.idx++
+	}
+}
+
+func (it *blockIterator) MaxTf() ([fieldCount]int, bool) {
+	return it.maxTf, true
+}
+
+///////////////////// 链表形式的倒排列表 //////////////////////
+
+// 用于旧版本的段
+type listIterator struct {
+	p *postingsList
+}
+
+func (it *listIterator) Current() *postingsList {
+	return it.p
+}
+
+func (it *listIterator) Next() {
+	if it.p != nil {
+		it.p = it.p.next
+	}
+}
+
+func (it *listIterator) Advance(target int) {
+	for it.p != nil && it.p.documentId < target {
+		it.p = it.p.next
+	}
+}
+
+func (it *listIterator) MaxTf() ([fieldCount]int, bool) {
+	return [fieldCount]int{}, false
+}
+
+///////////////////// 多个段 //////////////////////
+
+// 一个文档只会在一个段中出现，每次取各个段中文档 ID 最小的
+type mergedIterator struct {
+	iterators []postingsIterator
+	cur       postingsIterator
+}
+
+func (it *mergedIterator) pick() {
+	it.cur = nil
+	for _, i := range it.iterators {
+		if p := i.Current(); p != nil && (it.cur == nil || p.documentId < it.cur.Current().documentId) {
+			it.cur = i
+		}
+	}
+}
+
+func (it *mergedIterator) Current() *postingsList {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.Current()
+}
+
+func (it *mergedIterator) Next() {
+	if it.cur != nil {
+		it.cur.Next()
+		it.pick()
+	}
+}
+
+func (it *mergedIterator) Advance(target int) {
+	for _, i := range it.iterators {
+		i.Advance(target)
+	}
+	it.pick()
+}
+
+// 各个段的最大词频中的最大值，有一个段没有记录时返回 false
+func (it *mergedIterator) MaxTf() ([fieldCount]int, bool) {
+	var maxTf [fieldCount]int
+	for _, i := range it.iterators {
+		tf, ok := i.MaxTf()
+		if !ok {
+			return maxTf, false
+		}
+		for f := range maxTf {
+			maxTf[f] = util.MaxInt(maxTf[f], tf[f])
+		}
+	}
+	return maxTf, true
+}
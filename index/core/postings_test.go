@@ -0,0 +1,133 @@
+package core
+
+import (
+	"search-engine/index/db"
+	"testing"
+)
+
+// 文档 ID 为 start, start+step, ...，共 count 个
+func buildLongPostingsList(start, step, count int) *postingsList {
+	head := new(postingsList)
+	p := head
+	for i := 0; i < count; i++ {
+		p.next = &postingsList{documentId: start + i*step, positions: []int{i % 3, i%3 + 5, 1}, titleEnd: 2}
+		p = p.next
+	}
+	return head.next
+}
+
+func TestPostingsList_EncodeBlocks(t *testing.T) {
+	// 跨越多个块，最后一块不满
+	p := buildLongPostingsList(3, 7, postingsBlockSize*2+5)
+	data := p.encode()
+	it := newBlockIterator(data)
+	if it.count != postingsBlockSize*2+5 || len(it.lastDocIds) != 3 {
+		t.Fatal(it.count, it.lastDocIds)
+	}
+	q, count := decodePostings(data)
+	if count != it.count {
+		t.Error(count)
+	}
+	for ; p != nil && q != nil; p, q = p.next, q.next {
+		if p.documentId != q.documentId || p.titleEnd != q.titleEnd || len(p.positions) != len(q.positions) {
+			t.Fatal(p.documentId, q.documentId)
+		}
+		for i := range p.positions {
+			if p.positions[i] != q.positions[i] {
+				t.Fatal(p.documentId, q.positions)
+			}
+		}
+	}
+	if p != nil || q != nil {
+		t.Error("文档数量不一致")
+	}
+	if data := (*postingsList)(nil).encode(); len(data) != 0 || newBlockIterator(data).Current() != nil {
+		t.Error("空的倒排列表")
+	}
+}
+
+func TestBlockIterator_Advance(t *testing.T) {
+	// 文档 ID 为 0, 2, 4, ...
+	it := newBlockIterator(buildLongPostingsList(0, 2, postingsBlockSize*3).encode())
+	it.Advance(0)
+	if it.Current().documentId != 0 {
+		t.Fatal(it.Current().documentId)
+	}
+	// 跳过第一块
+	it.Advance(postingsBlockSize*2 + 1)
+	if it.blockIdx != 1 || it.Current().documentId != postingsBlockSize*2+2 {
+		t.Fatal(it.blockIdx, it.Current().documentId)
+	}
+	// 不会后退
+	it.Advance(10)
+	if it.Current().documentId != postingsBlockSize*2+2 {
+		t.Fatal(it.Current().documentId)
+	}
+	it.Next()
+	if it.Current().documentId != postingsBlockSize*2+4 {
+		t.Fatal(it.Current().documentId)
+	}
+	it.Advance(postingsBlockSize*6 - 2)
+	if it.blockIdx != 2 || it.Current().documentId != postingsBlockSize*6-2 {
+		t.Fatal(it.blockIdx, it.Current().documentId)
+	}
+	it.Next()
+	if it.Current() != nil {
+		t.Error("遍历没有结束")
+	}
+	it = newBlockIterator(buildLongPostingsList(0, 2, 10).encode())
+	if it.Advance(100); it.Current() != nil {
+		t.Error("遍历没有结束")
+	}
+}
+
+// 旧格式的段和新格式的段一起检索
+func TestMergedIterator(t *testing.T) {
+	it := newPostingsIterator([]db.SegmentPostings{
+		{Data: buildLongPostingsList(0, 3, 200).encodeLegacy(), Format: postingsFormatLegacy},
+		{Data: buildLongPostingsList(1, 3, 200).encode(), Format: postingsFormatBlock},
+	})
+	var docIds []int
+	for ; it.Current() != nil; it.Next() {
+		if docId := it.Current().documentId; docId%3 == 1 && docId < 300 {
+			// 跳过一部分
+			it.Advance(docId + 30)
+		}
+		docIds = append(docIds, it.Current().documentId)
+	}
+	for i := 1; i < len(docIds); i++ {
+		if docIds[i] <= docIds[i-1] {
+			t.Fatal(docIds)
+		}
+	}
+	if docIds[0] != 0 || docIds[len(docIds)-1] != 598 {
+		t.Error(docIds)
+	}
+}
+
+func TestPostingsIterator_MaxTf(t *testing.T) {
+	p := &postingsList{documentId: 1, positions: []int{1, 3, 9}, titleEnd: 1}
+	p.next = &postingsList{documentId: 5, positions: []int{2, 4, anchorBase}, titleEnd: 0}
+	q := &postingsList{documentId: 7, positions: []int{0, 1, 2, boilerplateBase}, titleEnd: 3}
+	segment := func(p *postingsList, format int) db.SegmentPostings {
+		return db.SegmentPostings{Data: encodeSegmentPostings(p, format), Format: format}
+	}
+
+	if tf, ok := newPostingsIterator([]db.SegmentPostings{segment(p, postingsFormatBlock)}).MaxTf(); !ok ||
+		tf != [fieldCount]int{1, 2, 1, 0} {
+		t.Error(tf, ok)
+	}
+	// 多个段取最大值
+	it := newPostingsIterator([]db.SegmentPostings{segment(p, postingsFormatBlock), segment(q, postingsFormatBlock)})
+	if tf, ok := it.MaxTf(); !ok || tf != [fieldCount]int{3, 2, 1, 1} {
+		t.Error(tf, ok)
+	}
+	// 旧格式的段没有记录
+	it = newPostingsIterator([]db.SegmentPostings{segment(p, postingsFormatBlock), segment(q, postingsFormatLegacy)})
+	if _, ok := it.MaxTf(); ok {
+		t.Error("旧格式的段")
+	}
+	if it.Advance(7); it.Current() == nil || len(it.Current().positions) != 4 {
+		t.Error(it.Current())
+	}
+}
@@ -0,0 +1,131 @@
+// 后台清理已删除（被替换）的文档：从倒排列表中删除它们，并修正词元的文档数量
+package core
+
+import (
+	"encoding/binary"
+	"github.com/boltdb/bolt"
+	"log"
+	"search-engine/index/db"
+	"search-engine/index/util"
+	"time"
+)
+
+// 定时清理，interval 为清理间隔（秒），小于等于 0 时不清理
+func (e *Engine) startPurgeGoroutine(interval int) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(interval) * time.Second)
+			if purged := e.purgeDeletedDocuments(); purged > 0 {
+				log.Printf("已从倒排列表中清理 %d 个删除的文档\n", purged)
+			}
+		}
+	}()
+}
+
+// 清理倒排列表中已删除的文档，返回清理掉的文档数量。
+// 一个文档的所有倒排列表是在同一个事务中写入同一个段的（合并段时也是整段合并），而每个段的清理也在一个事务中完成，
+// 所以在段中出现过的文档在清理后就不会再有残留，可以去掉删除标记；
+// 没有出现过的文档可能还在内存中没有刷新，保留删除标记，下次再清理
+func (e *Engine) purgeDeletedDocuments() int {
+	tombstones := e.DB.Tombstones()
+	if len(tombstones) == 0 {
+		return 0
+	}
+	// 合并段会删除段，不能同时进行
+	e.segmentLock.Lock()
+	defer e.segmentLock.Unlock()
+
+	purged := make(map[int]struct{})
+	changedTokens := make(map[string]struct{})
+	for _, segment := range e.DB.Segments() {
+		// 文档 ID 范围内没有删除的文档，跳过
+		contains := false
+		for docId := range tombstones {
+			if docId >= segment.MinDocId && docId <= segment.MaxDocId {
+				contains = true
+				break
+			}
+		}
+		if !contains {
+			continue
+		}
+		err := e.DB.UpdatePostings(func(tx *bolt.Tx) error {
+			return purgeSegment(tx, segment, tombstones, purged, changedTokens)
+		})
+		if err != nil {
+			log.Println(err.Error())
+			return 0
+		}
+	}
+	for token := range changedTokens {
+		e.DB.PostingsBuffer.Del(token)
+		e.DB.TokenDocsCountBuffer.Del(token)
+	}
+	docIds := make([]int, 0, len(purged))
+	for docId := range purged {
+		docIds = append(docIds, docId)
+	}
+	if err := e.DB.RemoveTombstones(docIds); err != nil {
+		log.Println(err.Error())
+	}
+	return len(docIds)
+}
+
+// 在一个事务中清理一个段，清理掉的文档记录到 purged 中，修改过的词元记录到 changedTokens 中
+func purgeSegment(tx *bolt.Tx, segment db.SegmentInfo, tombstones, purged map[int]struct{},
+	changedTokens map[string]struct{}) error {
+	bucketSegment := tx.Bucket([]byte(segment.Name))
+	bucketDocCount := tx.Bucket(db.BucketTokenDocCount)
+	// 遍历时不能修改 bucket，先记下要修改的词元，及其清理掉的文档数量
+	updates := make(map[string][]byte)
+	removed := make(map[string]int)
+	_ = bucketSegment.ForEach(func(k, v []byte) error {
+		postings, _ := decodeSegmentPostings(v, segment.Format)
+		head := &postingsList{next: postings}
+		for p := head; p.next != nil; {
+			if _, ok := tombstones[p.next.documentId]; ok {
+				purged[p.next.documentId] = struct{}{}
+				removed[string(k)]++
+				p.next = p.next.next
+			} else {
+				p = p.next
+			}
+		}
+		if removed[string(k)] > 0 {
+			// 段的格式不变
+			updates[string(k)] = encodeSegmentPostings(head.next, segment.Format)
+		}
+		return nil
+	})
+	for token, data := range updates {
+		key := []byte(token)
+		changedTokens[token] = struct{}{}
+		segment.Size -= len(bucketSegment.Get(key)) - len(data)
+		var err error
+		if len(data) == 0 {
+			err = bucketSegment.Delete(key)
+		} else {
+			err = bucketSegment.Put(key, data)
+		}
+		if err != nil {
+			return err
+		}
+		docCount, _ := binary.Varint(bucketDocCount.Get(key))
+		if docCount -= int64(removed[token]); docCount <= 0 {
+			err = bucketDocCount.Delete(key)
+		} else {
+			// 写入的数据在事务结束前必须有效，每次都分配新的 buf
+			err = bucketDocCount.Put(key, util.EncodeVarInt(make([]byte, binary.MaxVarintLen64), docCount))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return db.PutSegmentInfo(tx, &segment)
+}
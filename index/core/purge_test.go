@@ -0,0 +1,81 @@
+package core
+
+import (
+	"path/filepath"
+	"search-engine/index/db"
+	"testing"
+)
+
+func newTestIndexDB(t *testing.T) *db.IndexDB {
+	dir := t.TempDir()
+	return db.NewIndexDB(&db.IndexDBOptions{
+		DocUrlBufferSize:         10,
+		PostingsBufferSize:       10,
+		TokenDocsCountBufferSize: 10,
+		DocumentDBPath:           filepath.Join(dir, "doc.db"),
+		IndexDBPath:              filepath.Join(dir, "index.db"),
+	})
+}
+
+// 将 index 刷新成一个段
+func flushIndex(indexDB *db.IndexDB, index invertedIndex) {
+	m := &indexManager{db: indexDB, flushChannel: make(chan invertedIndex, 1)}
+	m.flushChannel <- index
+	close(m.flushChannel)
+	m.flusher()
+}
+
+// 读取 token 在所有段中的倒排列表
+func fetchPostings(indexDB *db.IndexDB, token string) *postingsList {
+	head := new(postingsList)
+	p := head
+	for it := newPostingsIterator(indexDB.FetchPostings(token)); it.Current() != nil; it.Next() {
+		p.next = it.Current()
+		p = p.next
+	}
+	return head.next
+}
+
+func TestPurgeDeletedDocuments(t *testing.T) {
+	indexDB := newTestIndexDB(t)
+	e := &Engine{DB: indexDB}
+
+	// 重新索引同一个 URL 会替换旧文档，旧文档的长度不再计入平均长度
+	id1, _ := indexDB.AddDocument("http://a.com", "a", "a", []int{1, 2})
+	id2, _ := indexDB.AddDocument("http://a.com", "b", "b", []int{3, 4})
+	id3, _ := indexDB.AddDocument("http://b.com", "c", "c", []int{5, 6})
+	if !indexDB.IsDeleted(id1) || indexDB.IsDeleted(id2) || indexDB.GetDocumentsCount() != 2 {
+		t.Fatal("替换文档失败")
+	}
+	if avg := indexDB.GetAverageFieldLength(); len(avg) != 2 || avg[0] != 4 || avg[1] != 5 {
+		t.Error("平均长度：", avg)
+	}
+	if ok, _ := indexDB.DeleteDocument("http://b.com"); !ok || !indexDB.IsDeleted(id3) {
+		t.Fatal("删除文档失败")
+	}
+	if ok, _ := indexDB.DeleteDocument("http://c.com"); ok {
+		t.Fatal("删除了不存在的文档")
+	}
+
+	// 倒排列表中还有已删除的文档
+	postings := &postingsList{documentId: id1, positions: []int{0}}
+	postings.next = &postingsList{documentId: id2, positions: []int{0}}
+	postings.next.next = &postingsList{documentId: id3, positions: []int{0}}
+	flushIndex(indexDB, invertedIndex{
+		"x": {token: "x", documentCount: 3, postings: postings},
+		"y": {token: "y", documentCount: 1, postings: &postingsList{documentId: id3}},
+	})
+
+	if purged := e.purgeDeletedDocuments(); purged != 2 {
+		t.Error("purged:", purged)
+	}
+	if p := fetchPostings(indexDB, "x"); p == nil || p.next != nil || p.documentId != id2 {
+		t.Error("清理倒排列表失败")
+	}
+	if indexDB.GetDocsCountOfToken("x") != 1 || indexDB.GetDocsCountOfToken("y") != 0 {
+		t.Error("修正 token_doc_count 失败")
+	}
+	if len(indexDB.Tombstones()) != 0 || len(indexDB.FetchPostings("y")) != 0 {
+		t.Error("删除标记没有清除")
+	}
+}
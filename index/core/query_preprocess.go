@@ -1,34 +1,291 @@
-// 查询预处理器，在查询前，做一些预处理工作，如过滤非法关键词等等
+// 查询预处理器，将查询解析成语法树：
+//
+//	query   := or
+//	or      := and { ("OR" | "|") and }
+//	and     := unary { unary }                  多个条件之间是隐式的 AND
+//	unary   := "-" unary | primary              排除
+//	primary := "(" or ")" | 短语 | field 关键词 | field 短语 | 关键词
+//	field   := "site:" | "intitle:" | "inurl:"
+//	短语    := "\"" ... "\""                     词元必须相邻
 package core
 
 import (
+	"fmt"
 	"strings"
+	"unicode"
 )
 
-type parsedQuery struct {
-	keywords   []string // 查询关键词，and
-	exclusions []string // 排除的关键词，not
-	site       string   // 站点内查询，site:xxx.com
+type queryNodeType int
+
+const (
+	nodeKeyword queryNodeType = iota // 关键词，所有词元都要出现
+	nodePhrase                       // 短语，词元还要相邻
+	nodeAnd
+	nodeOr
+	nodeNot
+	nodeSite  // 站点内查询，多个站点取并集
+	nodeInUrl // URL 中包含
+)
+
+// 查询语法树的节点
+type queryNode struct {
+	typ      queryNodeType
+	pos      int      // 在查询中的位置（字符），用于报错
+	text     string   // 关键词、短语、inurl: 的内容
+	sites    []string // site: 的值，小写，去掉了末尾的点
+	inTitle  bool     // intitle:，关键词（短语）要出现在标题中
+	synonym  bool     // 由同义词扩展而来，匹配时的权重低于原词
+	children []*queryNode
+}
+
+// 查询的语法错误
+type QueryError struct {
+	Pos int    `json:"position"` // 出错的位置（字符，从 0 开始）
+	Msg string `json:"message"`
 }
 
-func parseQuery(query string) *parsedQuery {
-	fragments := strings.Split(query, " ")
-	ret := &parsedQuery{}
-	for _, fragment := range fragments {
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("第 %d 个字符处：%s", e.Pos+1, e.Msg)
+}
+
+///////////////////// 词法分析 //////////////////////
+
+type queryTokenType int
+
+const (
+	queryEOF queryTokenType = iota
+	queryTerm
+	queryPhrase
+	queryField // 字段名，后面紧跟关键词或短语
+	queryOr
+	queryNot
+	queryLParen
+	queryRParen
+)
+
+type queryToken struct {
+	typ  queryTokenType
+	text string
+	pos  int
+}
+
+var queryFields = []string{"site", "intitle", "inurl"}
+
+// 关键词到空白、括号、引号、| 为止
+func isTermChar(c rune) bool {
+	return !unicode.IsSpace(c) && !strings.ContainsRune(`()"|`, c)
+}
+
+func lexQuery(query string) ([]queryToken, error) {
+	chars := []rune(query)
+	var tokens []queryToken
+	for i := 0; i < len(chars); {
+		c := chars[i]
 		switch {
-		case len(fragment) == 0:
-			break
-		case strings.HasPrefix(fragment, "-"):
-			ret.exclusions = append(ret.exclusions, fragment[1:])
-		case strings.HasPrefix(fragment, "site:"):
-			// 多个取第一个
-			if len(ret.site) > 0 {
-				break
-			}
-			ret.site = fragment[5:]
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, queryToken{typ: queryLParen, pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, queryToken{typ: queryRParen, pos: i})
+			i++
+		case c == '|':
+			tokens = append(tokens, queryToken{typ: queryOr, pos: i})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(chars) && chars[end] != '"' {
+				end++
+			}
+			if end >= len(chars) {
+				return nil, &QueryError{Pos: i, Msg: "引号没有闭合"}
+			}
+			tokens = append(tokens, queryToken{typ: queryPhrase, text: string(chars[i+1 : end]), pos: i})
+			i = end + 1
+		// 单独的 - 当作普通字符
+		case c == '-' && i+1 < len(chars) && !unicode.IsSpace(chars[i+1]):
+			tokens = append(tokens, queryToken{typ: queryNot, pos: i})
+			i++
 		default:
-			ret.keywords = append(ret.keywords, fragment)
+			start := i
+			for i < len(chars) && isTermChar(chars[i]) {
+				i++
+			}
+			text := string(chars[start:i])
+			if text == "OR" {
+				tokens = append(tokens, queryToken{typ: queryOr, pos: start})
+				continue
+			}
+			field := ""
+			for _, f := range queryFields {
+				if strings.HasPrefix(text, f+":") {
+					field = f
+					break
+				}
+			}
+			if field == "" {
+				tokens = append(tokens, queryToken{typ: queryTerm, text: text, pos: start})
+				continue
+			}
+			tokens = append(tokens, queryToken{typ: queryField, text: field, pos: start})
+			// 字段的值可以是短语，如 intitle:"搜索 引擎"
+			if value := text[len(field)+1:]; value != "" {
+				tokens = append(tokens, queryToken{typ: queryTerm, text: value, pos: start + len(field) + 1})
+			} else if i >= len(chars) || chars[i] != '"' {
+				return nil, &QueryError{Pos: start, Msg: field + ": 后面缺少内容"}
+			}
+		}
+	}
+	return append(tokens, queryToken{typ: queryEOF, pos: len(chars)}), nil
+}
+
+///////////////////// 语法分析 //////////////////////
+
+type queryParser struct {
+	tokens []queryToken
+	idx    int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.idx]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.idx]
+	if t.typ != queryEOF {
+		p.idx++
+	}
+	return t
+}
+
+// 解析查询，查询中没有任何条件时返回 nil
+func parseQuery(query string) (*queryNode, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != queryEOF {
+		return nil, &QueryError{Pos: t.pos, Msg: "多余的右括号"}
+	}
+	return node, nil
+}
+
+func (p *queryParser) parseOr() (*queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != queryOr {
+		return left, nil
+	}
+	if left == nil {
+		return nil, &QueryError{Pos: p.peek().pos, Msg: "OR 前面缺少条件"}
+	}
+	node := &queryNode{typ: nodeOr, pos: left.pos, children: []*queryNode{left}}
+	for p.peek().typ == queryOr {
+		or := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if right == nil {
+			return nil, &QueryError{Pos: or.pos, Msg: "OR 后面缺少条件"}
+		}
+		node.children = append(node.children, right)
+	}
+	return node, nil
+}
+
+// 多个 site: 合并成一个节点，取并集
+func (p *queryParser) parseAnd() (*queryNode, error) {
+	var children []*queryNode
+	var site *queryNode
+	for {
+		switch p.peek().typ {
+		case queryEOF, queryRParen, queryOr:
+			if len(children) == 0 {
+				return nil, nil
+			} else if len(children) == 1 {
+				return children[0], nil
+			}
+			return &queryNode{typ: nodeAnd, pos: children[0].pos, children: children}, nil
+		}
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if child.typ == nodeSite {
+			if site != nil {
+				site.sites = append(site.sites, child.sites...)
+				continue
+			}
+			site = child
+		}
+		children = append(children, child)
+	}
+}
+
+func (p *queryParser) parseUnary() (*queryNode, error) {
+	if p.peek().typ != queryNot {
+		return p.parsePrimary()
+	}
+	not := p.next()
+	switch p.peek().typ {
+	case queryEOF, queryRParen, queryOr:
+		return nil, &QueryError{Pos: not.pos, Msg: "- 后面缺少要排除的内容"}
+	}
+	child, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &queryNode{typ: nodeNot, pos: not.pos, children: []*queryNode{child}}, nil
+}
+
+func (p *queryParser) parsePrimary() (*queryNode, error) {
+	t := p.next()
+	switch t.typ {
+	case queryLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().typ != queryRParen {
+			return nil, &QueryError{Pos: t.pos, Msg: "缺少右括号"}
+		}
+		p.next()
+		if node == nil {
+			return nil, &QueryError{Pos: t.pos, Msg: "括号中没有内容"}
+		}
+		return node, nil
+	case queryPhrase:
+		return &queryNode{typ: nodePhrase, pos: t.pos, text: t.text}, nil
+	case queryTerm:
+		return &queryNode{typ: nodeKeyword, pos: t.pos, text: t.text}, nil
+	case queryField:
+		value := p.next()
+		if value.typ != queryTerm && value.typ != queryPhrase {
+			return nil, &QueryError{Pos: t.pos, Msg: t.text + ": 后面缺少内容"}
+		}
+		switch t.text {
+		case "site":
+			// 域名不区分大小写，末尾的点表示根域名（a.com. 就是 a.com）
+			site := strings.TrimSuffix(strings.ToLower(value.text), ".")
+			return &queryNode{typ: nodeSite, pos: t.pos, sites: []string{site}}, nil
+		case "inurl":
+			return &queryNode{typ: nodeInUrl, pos: t.pos, text: value.text}, nil
+		}
+		node := &queryNode{typ: nodeKeyword, pos: t.pos, text: value.text, inTitle: true}
+		if value.typ == queryPhrase {
+			node.typ = nodePhrase
 		}
+		return node, nil
 	}
-	return ret
+	// 不会出现：parseAnd 遇到 EOF、右括号、OR 时已经返回
+	return nil, &QueryError{Pos: t.pos, Msg: "语法错误"}
 }
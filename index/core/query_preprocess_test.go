@@ -0,0 +1,88 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 将语法树转换成便于比较的字符串
+func queryNodeString(node *queryNode) string {
+	if node == nil {
+		return ""
+	}
+	var children []string
+	for _, child := range node.children {
+		children = append(children, queryNodeString(child))
+	}
+	switch node.typ {
+	case nodeKeyword, nodePhrase:
+		s := node.text
+		if node.typ == nodePhrase {
+			s = fmt.Sprintf("%q", s)
+		}
+		if node.inTitle {
+			s = "intitle:" + s
+		}
+		return s
+	case nodeSite:
+		return "site:" + strings.Join(node.sites, ",")
+	case nodeInUrl:
+		return "inurl:" + node.text
+	case nodeNot:
+		return "-" + children[0]
+	case nodeAnd:
+		return "AND(" + strings.Join(children, " ") + ")"
+	}
+	return "OR(" + strings.Join(children, " ") + ")"
+}
+
+func TestParseQuery(t *testing.T) {
+	for query, want := range map[string]string{
+		"搜索":                              "搜索",
+		" 搜索  引擎 ":                        "AND(搜索 引擎)",
+		"a OR b c | d":                    "OR(a AND(b c) d)",
+		"a or b":                          "AND(a or b)",
+		`"搜索 引擎" -"倒排 索引"`:                `AND("搜索 引擎" -"倒排 索引")`,
+		"-(a | b) c":                      "AND(-OR(a b) c)",
+		"--a b":                           "AND(--a b)",
+		"e-mail - x":                      "AND(e-mail - x)",
+		`intitle:搜索 intitle:"a b"`:        `AND(intitle:搜索 intitle:"a b")`,
+		"a site:x.com inurl:go site:y.cn": "AND(a site:x.com,y.cn inurl:go)",
+		"site:News.A.com.":                "site:news.a.com",
+		"(a (b | c)) d":                   "AND(AND(a OR(b c)) d)",
+		"":                                "",
+	} {
+		node, err := parseQuery(query)
+		if err != nil {
+			t.Error(query, err)
+			continue
+		}
+		if got := queryNodeString(node); got != want {
+			t.Errorf("%s: %s", query, got)
+		}
+	}
+	for query, pos := range map[string]int{
+		`a "b`:     2,
+		"(a b":     0,
+		"a b)":     3,
+		"OR a":     0,
+		"a |":      2,
+		"a -":      -1, // 单独的 - 当作关键词
+		"site: a":  0,
+		"a ()":     2,
+		"a -(":     3,
+		"intitle:": 0,
+	} {
+		_, err := parseQuery(query)
+		if pos < 0 {
+			if err != nil {
+				t.Error(query, err)
+			}
+			continue
+		}
+		if e, ok := err.(*QueryError); !ok || e.Pos != pos {
+			t.Error(query, err)
+		}
+	}
+}
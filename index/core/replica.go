@@ -0,0 +1,181 @@
+// 副本：indexer.shard 配置相同的索引服务器是同一个分片的副本，爬虫把文档发送给分片的所有副本，
+// web 检索时每个分片只查询一个健康的副本，某个副本宕机时其他副本继续提供服务。
+// 副本启动时如果分片中还有其他健康的副本，先在注册中心登记 indexer.recovering（这期间不参与检索），
+// 通过 GET /replica/documents 从其他副本流式同步所有文档，补上宕机期间错过的写入，同步的文档写入磁盘后取消登记
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"search-engine/index/config"
+	"search-engine/index/db"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	catchUpRetryInterval        = 30 * time.Second // 同步失败后重试的间隔
+	recoveringHeartbeatInterval = 10 * time.Second
+)
+
+// 同步、恢复的数据量可能很大，不设置超时
+var replicaClient = &http.Client{}
+
+func (e *Engine) Recovering() bool {
+	return atomic.LoadInt32(&e.recovering) == 1
+}
+
+// 启动时从同一分片的其他副本同步数据，失败时换一个副本，都失败时隔一段时间重试
+func (e *Engine) startCatchUpGoroutine() {
+	if db.Registry == nil {
+		return
+	}
+	self := config.Get("indexer.listenAddr")
+	atomic.StoreInt32(&e.recovering, 1)
+	// 先登记再查找其他副本，两个副本同时启动时互相看到对方在同步，都不会从对方同步
+	_ = db.Registry.Register(serviceRecovering, self)
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(recoveringHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_ = db.Registry.Register(serviceRecovering, self)
+			}
+		}
+	}()
+
+	go func() {
+		defer func() {
+			close(stop)
+			_ = db.Registry.Deregister(serviceRecovering, self)
+			atomic.StoreInt32(&e.recovering, 0)
+		}()
+		for {
+			peers, err := replicaPeers()
+			if err != nil {
+				log.Println("获取副本地址失败：" + err.Error())
+			} else if len(peers) == 0 {
+				// 分片中没有其他健康的副本，本机的数据就是最新的
+				return
+			}
+			for _, i := range rand.Perm(len(peers)) {
+				begin := time.Now()
+				count, err := e.catchUp(peers[i])
+				if err == nil {
+					log.Printf("从副本 %s 同步了 %d 个 URL，耗时 %.1fs\n", peers[i], count, time.Since(begin).Seconds())
+					return
+				}
+				log.Println("从副本同步失败：" + err.Error())
+			}
+			time.Sleep(catchUpRetryInterval)
+		}
+	}()
+}
+
+// 同一分片中其他健康的副本
+func replicaPeers() ([]string, error) {
+	topology, err := loadTopology()
+	if err != nil {
+		return nil, err
+	}
+	self := config.Get("indexer.listenAddr")
+	var peers []string
+	for _, addr := range topology.healthyReplicas(ShardName()) {
+		if addr != self {
+			peers = append(peers, addr)
+		}
+	}
+	return peers, nil
+}
+
+// 从副本 peer 同步所有文档，返回同步的 URL 数量
+func (e *Engine) catchUp(peer string) (int, error) {
+	known := e.DB.DocumentIds()
+	resp, err := replicaClient.Get(fmt.Sprintf("http://%s/replica/documents", peer))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", peer, resp.Status)
+	}
+	seen, err := e.applyReplicaDocuments(resp.Body)
+	if err != nil {
+		return len(seen), err
+	}
+	// 对方没有的文档是宕机期间被删除或迁移走的，同步期间重新写入的除外
+	for url, docId := range known {
+		if !seen[url] && e.DB.GetDocumentId(url) == docId {
+			if _, err := e.DB.DeleteDocument(url); err != nil {
+				log.Println(err.Error())
+			}
+		}
+	}
+	return len(seen), nil
+}
+
+// 应用副本发送的文档，返回出现过的 URL。流必须以结束标记结尾，否则认为不完整。
+// 文档不经过 indexChannel，直接建索引并分批写成段，返回时已经可以检索到，之后才能取消 indexer.recovering 的登记
+func (e *Engine) applyReplicaDocuments(r io.Reader) (map[string]bool, error) {
+	decoder := json.NewDecoder(r)
+	seen := make(map[string]bool)
+	index := invertedIndex{}
+	indexed := make(map[string]int)
+	var err error
+	for {
+		doc := &MigratedDocument{}
+		if err = decoder.Decode(doc); err == io.EOF {
+			err = io.ErrUnexpectedEOF
+			break
+		} else if err != nil || doc.Url == "" {
+			break
+		}
+		seen[doc.Url] = true
+		if len(doc.Anchors) > 0 {
+			if err = e.DB.MergeAnchors(doc.Url, doc.Anchors); err != nil {
+				break
+			}
+		}
+		if doc.Indexed && !e.sameDocument(doc) {
+			e.indexMigratedDocument(doc, index, indexed)
+			// 整个分片的索引可能很大，不全部缓存在内存中
+			if len(indexed) >= e.indexManager.bufferFlushThreshold {
+				if err = e.writeIndexed(index, indexed); err != nil {
+					return seen, err
+				}
+				index, indexed = invertedIndex{}, make(map[string]int)
+			}
+		}
+	}
+	// 出错之前已经建索引的文档也写入磁盘
+	if writeErr := e.writeIndexed(index, indexed); writeErr != nil {
+		return seen, writeErr
+	}
+	return seen, err
+}
+
+// 本机是否已经有内容相同的文档
+func (e *Engine) sameDocument(doc *MigratedDocument) bool {
+	docId := e.DB.GetDocumentId(doc.Url)
+	if docId < 0 {
+		return false
+	}
+	_, title, body := e.DB.GetDocument(docId)
+	return title == doc.Title && body == doc.Body && e.DB.GetDocumentBoilerplate(docId) == doc.Boilerplate
+}
+
+// 把本机的所有文档以 JSON Lines 写入 w，最后一行是 URL 为空的结束标记
+func (e *Engine) StreamDocuments(w io.Writer) error {
+	if err := e.writeMigratedDocuments(w, e.DB.Urls()); err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(&MigratedDocument{})
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"search-engine/index/config"
+	"search-engine/index/db"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicaCatchUp(t *testing.T) {
+	src := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com": {"搜索引擎", "倒排索引"},
+		"http://b.com": {"新的标题", "正文"},
+	})
+	peer := &Engine{DB: src.db}
+	_ = peer.DB.AddAnchors("http://src.com", map[string]string{"http://c.com": "未抓取"})
+	truncate := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if truncate {
+			_ = peer.writeMigratedDocuments(w, peer.DB.Urls())
+			return
+		}
+		_ = peer.StreamDocuments(w)
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	dst := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com":   {"搜索引擎", "倒排索引"},
+		"http://b.com":   {"旧的标题", "正文"},
+		"http://old.com": {"已经删除", "文档"},
+	})
+	local := &Engine{DB: dst.db, indexManager: &indexManager{db: dst.db, textProcessor: dst.textProcessor, bufferFlushThreshold: 1}}
+	docIdA, docIdB := local.DB.GetDocumentId("http://a.com"), local.DB.GetDocumentId("http://b.com")
+
+	// 没有结束标记时不删除本地的文档
+	truncate = true
+	if _, err := local.catchUp(addr); err == nil {
+		t.Error("不完整的流")
+	}
+	if local.DB.GetDocumentId("http://old.com") < 0 {
+		t.Error("删除了文档")
+	}
+
+	truncate = false
+	count, err := local.catchUp(addr)
+	if err != nil || count != 3 {
+		t.Fatal(count, err)
+	}
+	// 只重新索引内容不同的文档，返回时已经可以检索到
+	if local.DB.GetDocumentId("http://a.com") != docIdA || local.DB.GetDocumentId("http://b.com") == docIdB {
+		t.Error("重新索引的文档不对")
+	}
+	results := searchQuery(t, dst, "新的标题", 10, SortByScore)
+	if len(results.Items) != 1 || results.Items[0].docId != local.DB.GetDocumentId("http://b.com") {
+		t.Errorf("%+v", results.Items)
+	}
+	if local.DB.GetDocumentId("http://old.com") >= 0 {
+		t.Error("对方没有的文档没有删除")
+	}
+	if text := local.DB.GetAnchorText("http://c.com"); text != "未抓取" {
+		t.Error(text)
+	}
+}
+
+// 取消 indexer.recovering 的登记时，同步的文档已经可以检索到
+func TestReplicaRecovering(t *testing.T) {
+	src := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com": {"搜索引擎", "倒排索引"},
+		"http://b.com": {"分布式", "副本"},
+	})
+	peer := &Engine{DB: src.db}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = peer.StreamDocuments(w)
+	}))
+	defer server.Close()
+
+	registry := db.Registry
+	defer func() {
+		db.Registry = registry
+	}()
+	db.Registry = db.NewMemoryRegistry()
+	self, shard := config.Get("indexer.listenAddr"), ShardName()
+	for _, addr := range []string{self, strings.TrimPrefix(server.URL, "http://")} {
+		_ = db.Registry.Register(serviceIndexer, addr)
+		_ = db.Registry.Register(serviceShardPrefix+shard, addr)
+	}
+	_ = db.Registry.Register(serviceShards, shard)
+
+	dst := newTestSearcher(t, rankingBM25F, map[string][2]string{})
+	local := &Engine{DB: dst.db, indexManager: &indexManager{db: dst.db, textProcessor: dst.textProcessor, bufferFlushThreshold: 100}}
+	local.startCatchUpGoroutine()
+	for deadline := time.Now().Add(5 * time.Second); local.Recovering(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("同步没有结束")
+		}
+	}
+	if results := searchQuery(t, dst, "副本", 10, SortByScore); len(results.Items) != 1 {
+		t.Errorf("%+v", results.Items)
+	}
+	if recovering, _ := db.Registry.List(serviceRecovering); len(recovering) != 0 {
+		t.Error(recovering)
+	}
+}
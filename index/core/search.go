@@ -2,16 +2,37 @@
 package core
 
 import (
+	"container/heap"
 	"math"
+	"search-engine/index/config"
 	"search-engine/index/db"
 	"search-engine/index/util"
 	"sort"
-	"strings"
 )
 
 type searcher struct {
-	db            *db.IndexDB
-	textProcessor *textProcessor
+	db             *db.IndexDB
+	textProcessor  *textProcessor
+	rankingModel   string
+	bm25           *bm25Params
+	pageRankWeight float64 // 为 0 时不使用 PageRank
+	exactTotal     int     // 匹配的文档达到这个数量以后才开始跳过文档（MaxScore），为 0 时不跳过
+}
+
+// 排序模型
+const (
+	rankingBM25F = "bm25f"
+	rankingTfIdf = "tfidf"
+)
+
+// web 最多翻到第 1000 个结果，匹配的文档不到这个数量时 Total 是准确的，页数不受跳过文档的影响
+const totalExactLimit = 1000
+
+// BM25F 的参数，下标为字段
+type bm25Params struct {
+	k1     float64
+	b      [fieldCount]float64
+	weight [fieldCount]float64
 }
 
 // 文档查询游标，用于指示当前词元处理到了哪个文档
@@ -34,10 +55,19 @@ func (c *phraseSearchCursor) hasNextPos() bool {
 
 // 搜索结果
 type SearchResults struct {
-	Items    []*searchResultItem `json:"items"`
-	Duration int64               `json:"duration"` //  搜索耗时，毫秒
+	Items      []*searchResultItem `json:"items"`
+	Total      int                 `json:"total"`                // 匹配的文档总数
+	Duration   int64               `json:"duration"`             //  搜索耗时，毫秒
+	Suggestion string              `json:"suggestion,omitempty"` // 拼写纠错后的查询（您是不是要找），不需要纠正时为空
+	sortBy     string
 }
 
+// 排序方式
+const (
+	SortByScore = "score" // 按分数
+	SortByDate  = "date"  // 按建索引的时间，新的在前
+)
+
 func (s *SearchResults) Push(x interface{}) {
 	s.Items = append(s.Items, x.(*searchResultItem))
 }
@@ -50,235 +80,258 @@ func (s *SearchResults) Pop() interface{} {
 
 func (s *SearchResults) Len() int { return len(s.Items) }
 
-func (s *SearchResults) Less(i, j int) bool { return s.Items[j].Score < s.Items[i].Score }
+// 小顶堆，堆顶是排在最后的结果
+func (s *SearchResults) Less(i, j int) bool { return s.lowerThan(s.Items[i], s.Items[j]) }
+
+// item 是否排在 item2 后面，分数相同时文档 ID 大的在后面，这样结果的顺序是确定的
+func (s *SearchResults) lowerThan(item, item2 *searchResultItem) bool {
+	if s.sortBy == SortByDate || item.Score == item2.Score {
+		// 文档 ID 越大，建索引的时间越新
+		return (item.docId < item2.docId) == (s.sortBy == SortByDate)
+	}
+	return item.Score < item2.Score
+}
 
 func (s *SearchResults) Swap(i, j int) { s.Items[i], s.Items[j] = s.Items[j], s.Items[i] }
 
-// 取交集 list ∩ list2，list 和 list2 均有序
-func (s *SearchResults) and(s2 *SearchResults) {
-	if s.Items == nil {
-		s.Items = s2.Items
-		return
-	}
-	i, j, putIdx := 0, 0, 0
-	len1, len2 := len(s.Items), len(s2.Items)
-	for i < len1 {
-		base := s.Items[i]
-		for j < len2 && s2.Items[j].docId < base.docId {
-			j++
-		}
-		if j >= len2 {
-			break
-		}
-		if s2.Items[j].docId > base.docId {
-			for i < len1 && s.Items[i].docId < s2.Items[j].docId {
-				i++
-			}
-			continue
-		}
-		s.Items[putIdx] = base
-		putIdx++
-		i++
+// 结果数量不足 k 个时直接放入堆中，否则替换掉排在最后的结果
+func (s *SearchResults) pushTopK(item *searchResultItem, k int) {
+	if s.Len() < k {
+		heap.Push(s, item)
+	} else if s.lowerThan(s.Items[0], item) {
+		s.Items[0] = item
+		heap.Fix(s, 0)
 	}
-	s.Items = s2.Items[:putIdx]
 }
 
-// 取差集 list1 - list2，list1 和 list2 均有序
-func (s *SearchResults) not(s2 *SearchResults) {
-	putIdx := 0
-	set := make(map[int]struct{}, len(s2.Items))
-	for _, item := range s2.Items {
-		set[item.docId] = struct{}{}
-	}
-	for i, len1 := 0, len(s.Items); i < len1; i++ {
-		if _, ok := set[s.Items[i].docId]; !ok {
-			s.Items[putIdx] = s.Items[i]
-			putIdx++
-		}
+// 按分数排序时堆中最低的分数，文档分数的上界不超过它就不可能进入前 k 个；
+// 结果数量不足 k 个或者不按分数排序时返回 -1
+func (s *SearchResults) minScore(k int) float64 {
+	if s.Len() < k || s.sortBy != SortByScore {
+		return -1
 	}
-	s.Items = s.Items[:putIdx]
+	return s.Items[0].Score
 }
 
-const (
-	highlightPrefix = `<span style='color:red'>`
-	highlightSuffix = `</span>`
-)
-
-// 获取结果信息及结果高亮
-func (s *SearchResults) applyHighlight(db *db.IndexDB) {
-	for _, item := range s.Items {
-		url, title0, body0 := db.GetDocument(item.docId)
-		title, body := []rune(title0), []rune(body0)
-
-		item.Url = url
-
-		builder := &strings.Builder{}
-		var pos int
-		// body 插入高亮标签
-		if bh := item.bodyHighlight; len(bh) > 0 {
-			// 计算网页摘要的区间
-			start, end := bh[0][0], bh[len(bh)-1][1]
-			padding := (100 - (end - start + 1)) / 2
-			start = util.MaxInt(start-padding, 0)
-			if start-padding >= 0 {
-				end = util.MinInt(len(body), end+padding)
-			} else {
-				end = util.MinInt(len(body), end+padding-start)
-			}
-			abstract := body[start:end]
-			for _, h := range item.bodyHighlight {
-				h[0], h[1] = h[0]-start, h[1]-start
-				builder.WriteString(string(abstract[pos:h[0]]))
-				builder.WriteString(highlightPrefix)
-				builder.WriteString(string(abstract[h[0] : h[1]+1]))
-				builder.WriteString(highlightSuffix)
-				pos = h[1] + 1
-			}
-			// 剩余的字符串
-			if pos < len(abstract) {
-				builder.WriteString(string(abstract[pos:]))
-			}
-			item.Abstract = builder.String()
-		} else {
-			item.Abstract = body0[:util.MinInt(100, len(body0))]
-		}
-		// title 插入高亮标签
-		if len(item.titleHighlight) > 0 {
-			// title:abcd1234  h:{{2,3}, {5,6}}  =>  ab <span...> cd </span> 1 <span...> 12 </span> 34
-			builder.Reset()
-			pos = 0
-			for _, h := range item.titleHighlight {
-				builder.WriteString(string(title[pos:h[0]]))
-				builder.WriteString(highlightPrefix)
-				builder.WriteString(string(title[h[0] : h[1]+1]))
-				builder.WriteString(highlightSuffix)
-				pos = h[1] + 1
-			}
-			// 剩余的字符串
-			if pos < len(title) {
-				builder.WriteString(string(title[pos:]))
-			}
-			item.Title = builder.String()
-		} else {
-			item.Title = title0
-		}
+// 依次弹出排在最后的结果，得到排好序的结果
+func (s *SearchResults) sortResults() {
+	items := make([]*searchResultItem, s.Len())
+	for i := len(items) - 1; i >= 0; i-- {
+		items[i] = heap.Pop(s).(*searchResultItem)
 	}
+	s.Items = items
 }
 
 type searchResultItem struct {
-	docId          int
-	titleHighlight [][2]int // 结果高亮
-	bodyHighlight  [][2]int
-	// 返回的数据
-	Score    float64 `json:"score"`
-	Url      string  `json:"url"`
-	Title    string  `json:"title"`
-	Abstract string  `json:"abstract"`
+	docId   int
+	matches []keywordMatch // 文档在各个关键词中的倒排列表项，用于计算高亮
+	// 返回的数据，标题和摘要都是纯文本，高亮区间由 web 服务器转义文本后再加上标签
+	Score           float64   `json:"score"`
+	Url             string    `json:"url"`
+	Title           string    `json:"title"`
+	TitleHighlights [][2]int  `json:"title_highlights,omitempty"` // 标题的高亮区间 [start, end)，字符下标
+	Snippets        []snippet `json:"snippets"`                   // 摘要片段，按在正文中的位置排列
+	Anchors         []snippet `json:"anchors,omitempty"`          // 锚文本的摘要，关键词出现在锚文本中时才有
 }
 
 func newSearcher(db *db.IndexDB, processor *textProcessor) *searcher {
+	model := config.Get("ranking.model")
+	if model != rankingBM25F && model != rankingTfIdf {
+		panic("配置项错误：ranking.model")
+	}
+	params := &bm25Params{k1: config.GetFloat("ranking.bm25.k1")}
+	params.b[fieldTitle] = config.GetFloat("ranking.bm25.titleB")
+	params.b[fieldBody] = config.GetFloat("ranking.bm25.bodyB")
+	params.weight[fieldTitle] = config.GetFloat("ranking.bm25.titleWeight")
+	params.weight[fieldBody] = config.GetFloat("ranking.bm25.bodyWeight")
+	params.b[fieldAnchor] = config.GetFloat("ranking.bm25.anchorB")
+	params.weight[fieldAnchor] = config.GetFloat("ranking.bm25.anchorWeight")
+	params.b[fieldBoilerplate] = config.GetFloat("ranking.bm25.boilerplateB")
+	params.weight[fieldBoilerplate] = config.GetFloat("ranking.bm25.boilerplateWeight")
 	return &searcher{
-		db:            db,
-		textProcessor: processor,
+		db:             db,
+		textProcessor:  processor,
+		rankingModel:   model,
+		bm25:           params,
+		pageRankWeight: config.GetFloat("ranking.pageRankWeight"),
+		exactTotal:     totalExactLimit,
 	}
 }
 
-// 检索文档
-func (s *searcher) searchDocs(query, site string) *SearchResults {
-	results := &SearchResults{}
-	queryTokens := s.textProcessor.queryToTokens(query)
-	if len(queryTokens) == 0 {
-		return &SearchResults{}
+// 检索文档，返回按 sortBy 排序的前 k 个结果，结果在堆中，还没有排序，Total 是匹配的文档总数。
+// 一次遍历所有词元的倒排列表（document-at-a-time），用小顶堆保存当前排在前面的 k 个结果，
+// 按分数排序时，堆满以后，文档分数的上界不超过堆中最低分数的，就不用再做短语匹配、读取文档长度等开销较大的计算。
+// 匹配的文档达到 exactTotal 个以后，把堆中最低的分数作为阈值交给 docMatcher，跳过分数不可能超过阈值的文档（见 matcher.go），
+// 所有关键词的上界之和都不超过阈值时提前结束，之后不再计数，Total 是估计值
+func (s *searcher) search(query *queryNode, k int, sortBy string) (*SearchResults, error) {
+	results := &SearchResults{sortBy: sortBy}
+	if query == nil || k <= 0 {
+		return results, nil
 	}
-	// 将 queryToken 按文档数量升序排序，这样可以尽早结束比较
-	sort.Slice(queryTokens, func(i, j int) bool {
-		return queryTokens[i].documentCount < queryTokens[j].documentCount
-	})
-
-	// 构建文档查询游标
-	cursors := make([]docSearchCursor, len(queryTokens))
-	for i, item := range queryTokens {
-		// 词元 i 还没有建过索引
-		if item == nil {
-			return results
-		}
-		data := s.db.FetchPostings(item.token)
-		postings, _ := decodePostings(data)
-		// 词元 i 没有倒排列表
-		if postings == nil {
-			return results
+	docsCount := s.db.GetDocumentsCount()
+	root, filter, err := s.compile(query, docsCount)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		// 只有 site:、inurl: 或者排除条件
+		if filter != nil {
+			return nil, &QueryError{Pos: query.pos, Msg: "缺少检索关键词"}
 		}
-		cursors[i] = postings
+		// 关键词全是停用词或标点
+		return results, nil
 	}
 
-	// 检索候选文档，以第一个词元的倒排列表（最短）为基准
-	for cursors[0] != nil {
-		baseDocId := cursors[0].documentId
-		nextDocId := -1
-		// 对除基准词元外的所有词元，不断获其取下一个docId，直到当前docId不小于基准词元的docId
-		for i := 1; i < len(cursors); i++ {
-			for cursors[i] != nil && cursors[i].documentId < baseDocId {
-				cursors[i] = cursors[i].next
-			}
-			// cursors[i] 中的所有文档id都小于baseDocId，则不可能有结果
-			if cursors[i] == nil {
-				return results
+	avgFieldLengths := s.db.GetAverageFieldLength()
+	maxBoost := s.maxStaticBoost()
+	pruneFrom := -1 // 开始跳过文档时的文档 ID
+	threshold := math.Inf(-1)
+	for docId := root.advance(0); docId != noMoreDocs; docId = root.advance(docId + 1) {
+		// 已删除（被替换）的文档，倒排列表还没有被清理
+		if s.db.IsDeleted(docId) {
+			continue
+		}
+		if pruneFrom < 0 {
+			results.Total++
+		}
+		matches := root.collect(docId, nil)
+		if s.maxScore(matches, docsCount, docId) > results.minScore(k) {
+			item := &searchResultItem{docId: docId, matches: matches}
+			item.Score = s.score(matches, docsCount, docId, avgFieldLengths)
+			results.pushTopK(item, k)
+		}
+		if s.exactTotal <= 0 || results.Total < s.exactTotal || math.IsInf(maxBoost, 1) {
+			continue
+		}
+		// 阈值不含静态分数，除以静态分数的上界
+		if minScore := results.minScore(k); minScore >= 0 && minScore/maxBoost > threshold {
+			if pruneFrom < 0 {
+				pruneFrom = docId
 			}
-			if cursors[i].documentId > baseDocId {
-				nextDocId = cursors[i].documentId
+			threshold = minScore / maxBoost
+			if root.maxScore() <= threshold {
 				break
 			}
+			root.setMinScore(threshold)
 		}
-		if nextDocId > 0 {
-			// 不断获取基准词元的下一个docId，直到不小于nextDocId
-			for cursors[0] != nil && cursors[0].documentId < nextDocId {
-				cursors[0] = cursors[0].next
-			}
-			continue
+	}
+	if pruneFrom >= 0 {
+		results.Total = s.estimateTotal(results.Total, pruneFrom, docsCount)
+	}
+	return results, nil
+}
+
+// 开始跳过文档以后不再计数，假设匹配的文档在文档 ID 上是均匀分布的，按 [1, pruneFrom] 中匹配的比例估计总数
+func (s *searcher) estimateTotal(counted, pruneFrom, docsCount int) int {
+	estimate := int(float64(counted) * float64(s.db.GetMaxDocumentId()) / float64(util.MaxInt(pruneFrom, 1)))
+	return util.MaxInt(counted, util.MinInt(estimate, docsCount))
+}
+
+// 文档的分数，各个关键词的分数之和
+func (s *searcher) score(matches []keywordMatch, docsCount, docId int, avgFieldLengths []float64) float64 {
+	var fieldLengths []int
+	if s.rankingModel == rankingBM25F {
+		fieldLengths = s.db.GetDocumentLength(docId)
+	}
+	var score float64
+	for _, m := range matches {
+		// 进行短语搜索
+		titlePhraseCount := searchPhrase(m.tokens, m.cursors, true)
+		bodyPhraseCount := searchPhrase(m.tokens, m.cursors, false)
+		if s.rankingModel == rankingTfIdf {
+			tfIdf := calcTfIdf(m.tokens, m.cursors, docsCount)
+			// 标题中的词元权值更高
+			score += m.weight * (tfIdf*tfIdfPhraseBoost(titlePhraseCount)*3 + tfIdf*tfIdfPhraseBoost(bodyPhraseCount))
+		} else {
+			bm25 := calcBM25F(m.tokens, m.cursors, docsCount, fieldLengths, avgFieldLengths, s.bm25)
+			// 完整短语（词元相邻）说明相关性更高
+			score += m.weight * bm25 * bm25PhraseBoost(titlePhraseCount+bodyPhraseCount)
 		}
-		// 如果该文档的URL不是指定域名下的
-		if site != "" {
-			u := s.db.GetDocumentUrl(baseDocId)
-			if !strings.HasSuffix(util.UrlToHost(u), site) {
-				cursors[0] = cursors[0].next
-				continue
-			}
+	}
+	return score * s.staticBoost(docId)
+}
+
+// 文档分数的上界，只用到倒排列表中的词频
+func (s *searcher) maxScore(matches []keywordMatch, docsCount, docId int) float64 {
+	var score float64
+	for _, m := range matches {
+		tfs := make([][fieldCount]int, len(m.cursors))
+		for i, cursor := range m.cursors {
+			tfs[i] = fieldTf(cursor)
 		}
-		item := &searchResultItem{docId: baseDocId}
-		searchTitleOrBody := func(inTitle bool) {
-			// 进行短语搜索
-			phraseCount, highLight := searchPhrase(queryTokens, cursors, inTitle)
-			// 打分
-			docsCount := s.db.GetDocumentsCount()
-			score := calcTfIdf(queryTokens, cursors, docsCount)
-			if phraseCount > 0 {
-				// 有完整短语权重更大，只要有完整短语，Score 就至少3倍，凭感觉来的
-				score *= 3 + math.Log(float64(phraseCount))
-			}
-			if inTitle { // 标题中的词元权值更高
-				score *= 3
-				item.titleHighlight = highLight
-			} else {
-				item.bodyHighlight = highLight
+		score += s.keywordMaxScore(m.tokens, m.weight, tfs, docsCount)
+	}
+	return score * s.staticBoost(docId)
+}
+
+// 由各词元在各字段中的词频（或者词频的上界）计算一个关键词分数的上界，不含静态分数：
+// 每出现一次完整短语，每个词元都要出现一次，所以短语数量不超过关键词中出现次数最少的词元的出现次数，
+// 标题以外的短语在 positions[titleEnd:] 中查找，可能在正文、模板文本或者锚文本中，按这三个字段的词频之和计算；
+// BM25F 按字段长度为 0 计算（长度归一化系数最小）
+func (s *searcher) keywordMaxScore(tokens []*tokenIndexItem, weight float64, tfs [][fieldCount]int, docsCount int) float64 {
+	var minTitleTf, minOtherTf int
+	for i, tf := range tfs {
+		otherTf := tf[fieldBody] + tf[fieldBoilerplate] + tf[fieldAnchor]
+		if i == 0 || tf[fieldTitle] < minTitleTf {
+			minTitleTf = tf[fieldTitle]
+		}
+		if i == 0 || otherTf < minOtherTf {
+			minOtherTf = otherTf
+		}
+	}
+	if s.rankingModel == rankingTfIdf {
+		var tfIdf float64
+		for i, item := range tokens {
+			var tf int
+			for _, n := range tfs[i] {
+				tf += n
 			}
-			item.Score += score
+			tfIdf += tokenTfIdf(tf, item.documentCount, docsCount)
 		}
-		searchTitleOrBody(true)
-		searchTitleOrBody(false)
-		results.Items = append(results.Items, item)
-		// 不能在 for 首部，因为循环体中有 continue
-		cursors[0] = cursors[0].next
+		return weight * (tfIdf*tfIdfPhraseBoost(minTitleTf)*3 + tfIdf*tfIdfPhraseBoost(minOtherTf))
+	}
+	bm25 := calcMaxBM25F(tokens, tfs, docsCount, s.bm25)
+	return weight * bm25 * bm25PhraseBoost(minTitleTf+minOtherTf)
+}
+
+// 文档的静态分数（与查询无关）对分数的加权：PageRank^pageRankWeight，PageRank 的平均值为 1，
+// 还没有计算 PageRank 的文档不加权
+func (s *searcher) staticBoost(docId int) float64 {
+	if s.pageRankWeight == 0 {
+		return 1
+	}
+	rank := s.db.GetPageRank(docId)
+	if rank <= 0 {
+		return 1
 	}
-	return results
+	return math.Pow(rank, s.pageRankWeight)
 }
 
-// 检索文档或标题中是否存在完全匹配的短语
-// 还要返回不完整短语的位置信息，用于结果高亮
+// staticBoost 的上界，pageRankWeight 为负数时 PageRank 越小加权越大，没有上界
+func (s *searcher) maxStaticBoost() float64 {
+	if s.pageRankWeight == 0 {
+		return 1
+	} else if s.pageRankWeight < 0 {
+		return math.Inf(1)
+	}
+	return math.Max(1, math.Pow(s.db.GetMaxPageRank(), s.pageRankWeight))
+}
+
+// 有完整短语权重更大，只要有完整短语，Score 就至少3倍，凭感觉来的
+func tfIdfPhraseBoost(phraseCount int) float64 {
+	if phraseCount > 0 {
+		return 3 + math.Log(float64(phraseCount))
+	}
+	return 1
+}
+
+func bm25PhraseBoost(phraseCount int) float64 {
+	return 1 + math.Log(1+float64(phraseCount))
+}
+
+// 构建短语查询游标
 // inTitle=true 表示在title中查询，否则在body中查询
-func searchPhrase(queryTokens []*tokenIndexItem, docCursors []docSearchCursor, inTitle bool) (int, [][2]int) {
-	phraseCount := 0
-	highLight := make([][2]int, 0)
-	// 初始化游标
+func newPhraseCursors(queryTokens []*tokenIndexItem, docCursors []docSearchCursor, inTitle bool) []phraseSearchCursor {
 	count := 0 // 查询中的词元的总数
 	for _, item := range queryTokens {
 		count += item.positionsCount // 之所以加上posCount是因为某个词元可能重复出现了
@@ -299,6 +352,14 @@ func searchPhrase(queryTokens []*tokenIndexItem, docCursors []docSearchCursor, i
 			cursorPos++
 		}
 	}
+	return cursors
+}
+
+// 检索文档或标题中完全匹配的短语的数量
+// inTitle=true 表示在title中查询，否则在body中查询
+func searchPhrase(queryTokens []*tokenIndexItem, docCursors []docSearchCursor, inTitle bool) int {
+	phraseCount := 0
+	cursors := newPhraseCursors(queryTokens, docCursors, inTitle)
 
 	// 检索短语
 	for cursors[0].hasNextPos() {
@@ -315,7 +376,7 @@ func searchPhrase(queryTokens []*tokenIndexItem, docCursors []docSearchCursor, i
 			}
 			// 不能能再找到了
 			if !cursors[i].hasNextPos() {
-				return phraseCount, findHighlight(cursors)
+				return phraseCount
 			}
 			// 对于其他词元，如果偏移量不等于第一个词元的偏移量就退出循环
 			if cursors[i].curPos()-cursors[i].base != offset {
@@ -334,77 +395,93 @@ func searchPhrase(queryTokens []*tokenIndexItem, docCursors []docSearchCursor, i
 			cursors[0].curIdx++
 		}
 	}
-	highLight = findHighlight(cursors)
-	return phraseCount, highLight
+	return phraseCount
 }
 
-// 构造高亮区间
-func findHighlight(cursors []phraseSearchCursor) [][2]int {
-	length := 0
-	// 重置 curIdx
-	for i := range cursors {
-		cursors[i].curIdx = 0
-		length += len(cursors[i].positions)
-	}
-	// 如果词元仅在标题（文档）中，而 findHighlight 又都会尝试查找，
-	// 由于位置信息为空导致 intervals 为空，继续执行下面代码就会导致切片溢出 panic
-	if length == 0 {
-		return nil
-	}
-	// 将位置信息转换成区间信息
-	intervals := make([][2]int, 0, length)
-	for i := range cursors {
-		for _, pos := range cursors[i].positions {
-			intervals = append(intervals, [2]int{pos, pos + 1}) // todo N(n-gram)
-		}
-	}
-	// 排序合并区间
-	sort.Slice(intervals, func(i, j int) bool {
-		return intervals[i][0] < intervals[j][0]
-	})
-	pos := 0
-	for i := 1; i < len(intervals); i++ {
-		// query:ABC  DOC:ABCABGC  =>  AB:{0,3} BC:{1} => {0,1,3} => {0,1} {1,2}, {3,4} => {0,4}
-		if intervals[i][0]-intervals[i-1][1] <= 1 {
-			intervals[pos][1] = intervals[i][1]
-		} else {
-			pos++
-			intervals[pos] = intervals[i]
+//   BM25F 在 BM25 的基础上考虑了文档的字段，先按字段对词频做长度归一化并加权求和，
+// 得到“伪词频” tf = Σ weight_f * tf_f / (1 - b_f + b_f * len_f / avgLen_f)，
+// 再做饱和处理 tf / (k1 + tf)，这样词元出现很多次的长文档不会压过短小而切题的文档。
+//   IDF = log(1 + (N - n + 0.5) / (n + 0.5))，N 为文档总数，n 为包含该词元的文档数量。
+// fieldLengths 是文档各字段的长度，avgFieldLengths 是各字段的平均长度，旧版本建立的索引
+// 没有长度信息，此时不做长度归一化。
+func calcBM25F(tokens []*tokenIndexItem, cursors []docSearchCursor, docsCount int,
+	fieldLengths []int, avgFieldLengths []float64, params *bm25Params) float64 {
+	var score float64
+	for i, item := range tokens {
+		tf := fieldTf(cursors[i])
+		var weightedTf float64
+		for f := 0; f < fieldCount; f++ {
+			if tf[f] == 0 {
+				continue
+			}
+			norm := 1.0
+			if f < len(fieldLengths) && f < len(avgFieldLengths) && avgFieldLengths[f] > 0 {
+				norm = 1 - params.b[f] + params.b[f]*float64(fieldLengths[f])/avgFieldLengths[f]
+			}
+			weightedTf += params.weight[f] * float64(tf[f]) / norm
 		}
+		score += bm25IDF(item.documentCount, docsCount) * weightedTf / (params.k1 + weightedTf)
 	}
-	intervals = intervals[:pos+1]
+	return score
+}
 
-	LEN := func(l [][2]int, i, j int) int {
-		return l[j][1] - l[i][0] + 1
-	}
-	// 100长度的滑动窗口，找到包含最长短语的窗口
-	maxLen, maxLenIdx := 0, 0
-	for i := 0; i < len(intervals); i++ {
-		if maxLen < LEN(intervals, i, i) {
-			maxLen = LEN(intervals, i, i)
-			maxLenIdx = i
-		}
-	}
-	i, j := maxLenIdx, maxLenIdx
-	for LEN(intervals, i, j) > 100 && (i > 0 || j < len(intervals)-1) {
-		len1, len2 := 0, 0
-		if i > 0 {
-			len1 = LEN(intervals, i-1, i-1)
-		}
-		if j < len(intervals)-1 {
-			len2 = LEN(intervals, j+1, j+1)
+// BM25F 分数的上界：字段长度为 0 时长度归一化系数最小，为 1-b；b 为 1 时取词频饱和的极限 1
+// tfs 是各词元在各字段中的词频
+func calcMaxBM25F(tokens []*tokenIndexItem, tfs [][fieldCount]int, docsCount int, params *bm25Params) float64 {
+	var score float64
+	for i, item := range tokens {
+		tf := tfs[i]
+		var weightedTf float64
+		for f := 0; f < fieldCount; f++ {
+			if tf[f] == 0 {
+				continue
+			}
+			if params.b[f] >= 1 {
+				weightedTf = math.Inf(1)
+				break
+			}
+			weightedTf += params.weight[f] * float64(tf[f]) / (1 - params.b[f])
 		}
-		if len1 > len2 {
-			i--
-		} else {
-			j++
+		saturation := 1.0
+		if !math.IsInf(weightedTf, 1) {
+			saturation = weightedTf / (params.k1 + weightedTf)
 		}
+		score += bm25IDF(item.documentCount, docsCount) * saturation
 	}
-	return intervals[i : j+1]
+	return score
 }
 
-func calcBM25(tokens []*tokenIndexItem, cursors []docSearchCursor, docsCount int) float64 {
-	return calcTfIdf(tokens, cursors, docsCount)
+func bm25IDF(documentCount, docsCount int) float64 {
+	// 已删除的文档清理前仍然计入 documentCount，不能超过文档总数
+	n := math.Min(float64(documentCount), float64(docsCount))
+	return math.Log(1 + (float64(docsCount)-n+0.5)/(n+0.5))
+}
+
+// 词元在各字段中的位置在 positions 中的区间 [start, end)
+func fieldRanges(cursor docSearchCursor) [fieldCount][2]int {
+	titleEnd := util.MaxInt(cursor.titleEnd, 0)
+	end := len(cursor.positions)
+	boilerplateStart, anchorStart := end, end
+	// 大多数词元只出现在标题和正文中，不用查找
+	if end > titleEnd && cursor.positions[end-1] >= boilerplateBase {
+		boilerplateStart = titleEnd + sort.SearchInts(cursor.positions[titleEnd:], boilerplateBase)
+		anchorStart = boilerplateStart + sort.SearchInts(cursor.positions[boilerplateStart:], anchorBase)
+	}
+	var ranges [fieldCount][2]int
+	ranges[fieldTitle] = [2]int{0, titleEnd}
+	ranges[fieldBody] = [2]int{titleEnd, boilerplateStart}
+	ranges[fieldBoilerplate] = [2]int{boilerplateStart, anchorStart}
+	ranges[fieldAnchor] = [2]int{anchorStart, end}
+	return ranges
+}
+
+// 词元在各字段中的出现次数
+func fieldTf(cursor docSearchCursor) [fieldCount]int {
+	var tf [fieldCount]int
+	for f, r := range fieldRanges(cursor) {
+		tf[f] = r[1] - r[0]
+	}
+	return tf
 }
 
 //   TF 词频因子，表示一个单词在文档中出现的次数，一般在某个文档中反复出现的单词，
@@ -420,10 +497,14 @@ func calcTfIdf(tokens []*tokenIndexItem, cursors []docSearchCursor, docsCount in
 	var score float64
 	for i, item := range tokens {
 		// cursors[tokenId]对应一篇文档的倒排列表项，它的positions就是在对应文档的出现次数
-		TF := 1 + math.Log(float64(len(cursors[i].positions)))
-		//
-		IDF := math.Log(float64(docsCount) / float64(item.documentCount))
-		score += TF * IDF
+		score += tokenTfIdf(len(cursors[i].positions), item.documentCount, docsCount)
 	}
 	return score
 }
+
+// 单个词元的 TF*IDF，tf 为词元在文档中的出现次数，documentCount 为包含词元的文档数量
+func tokenTfIdf(tf, documentCount, docsCount int) float64 {
+	TF := 1 + math.Log(float64(tf))
+	IDF := math.Log(float64(docsCount) / math.Min(float64(documentCount), float64(docsCount)))
+	return TF * IDF
+}
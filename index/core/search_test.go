@@ -0,0 +1,322 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCalcBM25F(t *testing.T) {
+	params := &bm25Params{k1: 1.2}
+	params.b[fieldTitle], params.b[fieldBody] = 0.75, 0.75
+	params.weight[fieldTitle], params.weight[fieldBody] = 3, 1
+	tokens := []*tokenIndexItem{{token: "搜索", documentCount: 10}}
+	avg := []float64{10, 100}
+	score := func(titleEnd int, positions []int, lengths []int) float64 {
+		cursors := []docSearchCursor{{positions: positions, titleEnd: titleEnd}}
+		return calcBM25F(tokens, cursors, 1000, lengths, avg, params)
+	}
+
+	// 词频相同时，短文档分数更高
+	short := score(0, []int{1, 5}, []int{10, 50})
+	long := score(0, []int{1, 5}, []int{10, 500})
+	if short <= long {
+		t.Error("short:", short, "long:", long)
+	}
+	// 词频饱和：长文档中出现很多次也不能压过短小切题的文档
+	longRepeated := score(0, make([]int, 20), []int{10, 2000})
+	if short <= longRepeated {
+		t.Error("short:", short, "long repeated:", longRepeated)
+	}
+	// 标题中出现的权重更高
+	inTitle := score(1, []int{1}, []int{10, 100})
+	inBody := score(0, []int{1}, []int{10, 100})
+	if inTitle <= inBody {
+		t.Error("title:", inTitle, "body:", inBody)
+	}
+	// 没有长度信息时不做归一化
+	if score(0, []int{1}, nil) <= 0 {
+		t.Error("no length")
+	}
+}
+
+// 为文档建索引，返回检索器
+func newTestSearcher(t *testing.T, model string, docs map[string][2]string) *searcher {
+	indexDB := newTestIndexDB(t)
+	analyzer, _ := newAnalyzer("standard")
+	tp := newTextProcessor(analyzer, indexDB)
+	params := &bm25Params{k1: 1.2}
+	params.b[fieldTitle], params.b[fieldBody] = 0.75, 0.75
+	params.weight[fieldTitle], params.weight[fieldBody] = 3, 1
+	index := invertedIndex{}
+	for url, doc := range docs {
+		docIndex, fieldLengths := tp.textToInvertedIndex(0, &parsedDocument{title: doc[0], body: doc[1]})
+		docId, err := indexDB.AddDocument(url, doc[0], doc[1], fieldLengths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docIndex.setDocumentId(docId)
+		index.merge(docIndex)
+	}
+	flushIndex(indexDB, index)
+	return &searcher{db: indexDB, textProcessor: tp, rankingModel: model, bm25: params}
+}
+
+func searchQuery(t *testing.T, s *searcher, query string, k int, sortBy string) *SearchResults {
+	node, err := parseQuery(query)
+	if err != nil {
+		t.Fatal(query, err)
+	}
+	results, err := s.search(node, k, sortBy)
+	if err != nil {
+		t.Fatal(query, err)
+	}
+	return results
+}
+
+func TestSearcher_SearchTopK(t *testing.T) {
+	docs := map[string][2]string{}
+	for i := 0; i < 30; i++ {
+		body := strings.Repeat("搜索引擎 ", i%7+1) + strings.Repeat("其他内容 ", i%5)
+		docs[fmt.Sprint("http://a.com/", i)] = [2]string{fmt.Sprint("文档", i), body}
+	}
+	docs["http://b.com"] = [2]string{"搜索引擎", "倒排索引"}
+	docs["http://c.com"] = [2]string{"无关", "其他内容"}
+	for _, model := range []string{rankingBM25F, rankingTfIdf} {
+		s := newTestSearcher(t, model, docs)
+		all := searchQuery(t, s, "搜索 引擎", 100, SortByScore)
+		all.sortResults()
+		if len(all.Items) != 31 {
+			t.Fatal(model, len(all.Items))
+		}
+		for i := 1; i < len(all.Items); i++ {
+			if all.Items[i].Score > all.Items[i-1].Score {
+				t.Fatal(model, "没有按分数降序排列")
+			}
+		}
+		// 前 k 个结果和全部结果排序后的前 k 个相同
+		top := searchQuery(t, s, "搜索 引擎", 5, SortByScore)
+		top.sortResults()
+		if len(top.Items) != 5 || top.Total != 31 {
+			t.Fatal(model, len(top.Items), top.Total)
+		}
+		for i, item := range top.Items {
+			if item.docId != all.Items[i].docId || item.Score != all.Items[i].Score {
+				t.Error(model, i, item.docId, all.Items[i].docId)
+			}
+		}
+		// 匹配的文档达到 exactTotal 个以后可以提前结束，前 k 个结果不变，Total 是估计值
+		s.exactTotal = 5
+		pruned := searchQuery(t, s, "搜索 引擎", 5, SortByScore)
+		pruned.sortResults()
+		s.exactTotal = 0
+		if len(pruned.Items) != 5 || pruned.Total < 5 || pruned.Total > 32 {
+			t.Fatal(model, len(pruned.Items), pruned.Total)
+		}
+		for i, item := range pruned.Items {
+			if item.docId != top.Items[i].docId || item.Score != top.Items[i].Score {
+				t.Error(model, "提前结束", i, item.docId, top.Items[i].docId)
+			}
+		}
+		// 按建索引的时间排序
+		latest := searchQuery(t, s, "搜索 引擎", 3, SortByDate)
+		latest.sortResults()
+		if len(latest.Items) != 3 || latest.Total != 31 {
+			t.Fatal(model, len(latest.Items), latest.Total)
+		}
+		docIds := make([]int, 0, len(all.Items))
+		for _, item := range all.Items {
+			docIds = append(docIds, item.docId)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(docIds)))
+		for i, item := range latest.Items {
+			if item.docId != docIds[i] {
+				t.Error(model, "按时间排序", i, item.docId)
+			}
+		}
+		// 分数的上界不小于分数，关键词的上界不小于文档的上界
+		docsCount := s.db.GetDocumentsCount()
+		var bound float64
+		for _, keyword := range []string{"搜索", "引擎"} {
+			bound += s.newKeywordQuery(keyword, docsCount).bound
+		}
+		for _, item := range all.Items {
+			if max := s.maxScore(item.matches, docsCount, item.docId); max < item.Score || bound < max {
+				t.Error(model, "上界", bound, max, item.Score)
+			}
+		}
+		// 排除关键词、站内检索
+		r := searchQuery(t, s, "搜索 -倒排", 100, SortByScore)
+		if len(r.Items) != 30 {
+			t.Error(model, len(r.Items))
+		}
+		r = searchQuery(t, s, "搜索 site:b.com", 100, SortByScore)
+		if len(r.Items) != 1 {
+			t.Error(model, len(r.Items))
+		}
+		if r = searchQuery(t, s, "搜索 不存在的词", 100, SortByScore); len(r.Items) != 0 {
+			t.Error(model, len(r.Items))
+		}
+	}
+}
+
+func TestSearcher_SearchBoolean(t *testing.T) {
+	s := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com/1":     {"搜索引擎", "倒排索引的压缩"},
+		"http://b.com/2":     {"索引", "搜索引擎使用倒排索引"},
+		"http://news.a.com/": {"新闻", "引擎盖和搜索"},
+		"http://c.com/go":    {"Go 语言", "使用 Go 语言编写搜索引擎"},
+		"http://data.com/":   {"数据", "站点过滤"},
+	})
+	urls := func(query string) string {
+		r := searchQuery(t, s, query, 10, SortByScore)
+		r.sortResults()
+		var ret []string
+		for _, item := range r.Items {
+			ret = append(ret, s.db.GetDocumentUrl(item.docId))
+		}
+		sort.Strings(ret)
+		return strings.Join(ret, " ")
+	}
+	for query, want := range map[string]string{
+		"搜索 引擎":                    "http://a.com/1 http://b.com/2 http://c.com/go http://news.a.com/",
+		`"搜索引擎"`:                   "http://a.com/1 http://b.com/2 http://c.com/go",
+		"倒排 OR 新闻":                 "http://a.com/1 http://b.com/2 http://news.a.com/",
+		"压缩 | 新闻":                  "http://a.com/1 http://news.a.com/",
+		"搜索 -(倒排 | go)":            "http://news.a.com/",
+		"intitle:搜索":               "http://a.com/1",
+		`intitle:"go 语言"`:          "http://c.com/go",
+		"搜索 site:a.com":            "http://a.com/1 http://news.a.com/",
+		"搜索 site:b.com site:c.com": "http://b.com/2 http://c.com/go",
+		"搜索 inurl:GO":              "http://c.com/go",
+		"搜索 -site:a.com":           "http://b.com/2 http://c.com/go",
+		"过滤 site:a.com":            "",
+		"过滤 site:data.com":         "http://data.com/",
+		"搜索 site:News.A.com.":      "http://news.a.com/",
+		"(压缩 OR 新闻) 搜索 -引擎盖":       "http://a.com/1",
+		"the":                      "",
+	} {
+		if got := urls(query); got != want {
+			t.Errorf("%s: %s", query, got)
+		}
+	}
+	// 只有过滤条件
+	node, _ := parseQuery("site:a.com -搜索")
+	if _, err := s.search(node, 10, SortByScore); err == nil {
+		t.Error("缺少检索关键词")
+	}
+	node, _ = parseQuery("搜索 OR site:a.com")
+	if _, err := s.search(node, 10, SortByScore); err == nil {
+		t.Error("OR 连接了关键词和 site:")
+	}
+}
+
+func TestSearcher_Boilerplate(t *testing.T) {
+	s := newTestSearcher(t, rankingBM25F, nil)
+	s.bm25.b[fieldBoilerplate], s.bm25.weight[fieldBoilerplate] = 0.75, 0.3
+	m := &indexManager{db: s.db, textProcessor: s.textProcessor}
+	index := invertedIndex{}
+	for url, body := range map[string]string{
+		"http://a.com": `<nav><a href="/">压缩</a></nav><p>搜索引擎的排序</p>`,
+		"http://b.com": `<nav><a href="/">首页</a></nav><p>倒排列表的压缩</p>`,
+	} {
+		index.merge(m.indexDocument(&rawDocument{url: url, document: "<title>文档</title>" + body}))
+	}
+	flushIndex(s.db, index)
+
+	results := searchQuery(t, s, "压缩", 10, SortByScore)
+	results.sortResults()
+	results.applyHighlight(s.db)
+	if len(results.Items) != 2 {
+		t.Fatal(results.Items)
+	}
+	// 模板文字中的关键词权重较低，关键词只出现在模板文字中时用模板文字作为摘要
+	if a, b := results.Items[1], results.Items[0]; a.Url != "http://a.com" || b.Url != "http://b.com" ||
+		!reflect.DeepEqual(a.Snippets, []snippet{{Text: "压缩", Highlights: [][2]int{{0, 2}}}}) {
+		t.Errorf("%+v %+v", a, b)
+	}
+}
+
+// 短语只出现在锚文本或模板文本中时，分数的上界也不能小于分数
+func TestSearcher_MaxScorePhraseOutsideBody(t *testing.T) {
+	for _, model := range []string{rankingBM25F, rankingTfIdf} {
+		s := newTestSearcher(t, model, map[string][2]string{
+			"http://a.com": {"文档", "搜索引擎"},
+			"http://b.com": {"其他", "内容"},
+		})
+		s.bm25.b[fieldAnchor], s.bm25.weight[fieldAnchor] = 0.5, 2
+		s.bm25.b[fieldBoilerplate], s.bm25.weight[fieldBoilerplate] = 0.75, 0.3
+		docId := s.db.GetDocumentId("http://a.com")
+		tokens := []*tokenIndexItem{
+			{token: "搜索", documentCount: 1, positionsCount: 1, postings: &postingsList{positions: []int{0}}},
+			{token: "引擎", documentCount: 1, positionsCount: 1, postings: &postingsList{positions: []int{1}}},
+		}
+		for _, base := range []int{anchorBase, boilerplateBase} {
+			matches := []keywordMatch{{tokens: tokens, weight: 1, cursors: []docSearchCursor{
+				&postingsList{documentId: docId, positions: []int{base, base + 5}},
+				&postingsList{documentId: docId, positions: []int{base + 1, base + 6}},
+			}}}
+			score := s.score(matches, s.db.GetDocumentsCount(), docId, s.db.GetAverageFieldLength())
+			if max := s.maxScore(matches, s.db.GetDocumentsCount(), docId); max < score {
+				t.Error(model, base, "上界", max, score)
+			}
+		}
+	}
+}
+
+// 跳过文档（MaxScore）和不跳过时前 k 个结果相同
+func TestSearcher_MaxScorePruning(t *testing.T) {
+	docs := map[string][2]string{}
+	for i := 0; i < 60; i++ {
+		body := strings.Repeat("搜索 ", i%4+1) + "引擎"
+		if i%10 == 0 {
+			body += " 倒排索引"
+		}
+		docs[fmt.Sprint("http://a.com/", i)] = [2]string{fmt.Sprint("文档", i), body}
+	}
+	for _, model := range []string{rankingBM25F, rankingTfIdf} {
+		s := newTestSearcher(t, model, docs)
+		for _, query := range []string{"搜索 OR 倒排", "(搜索 OR 倒排) 引擎", "搜索"} {
+			s.exactTotal = 0
+			all := searchQuery(t, s, query, 3, SortByScore)
+			all.sortResults()
+			s.exactTotal = 5
+			pruned := searchQuery(t, s, query, 3, SortByScore)
+			pruned.sortResults()
+			if len(pruned.Items) != len(all.Items) || pruned.Total < 5 || pruned.Total > 60 {
+				t.Fatal(model, query, len(pruned.Items), pruned.Total)
+			}
+			for i, item := range pruned.Items {
+				if item.docId != all.Items[i].docId || item.Score != all.Items[i].Score {
+					t.Error(model, query, i, item.docId, all.Items[i].docId)
+				}
+			}
+		}
+
+		// 只匹配非必要的子条件的文档被跳过
+		node, _ := parseQuery("搜索 OR 倒排")
+		root, _, _ := s.compile(node, s.db.GetDocumentsCount())
+		or := root.(*orMatcher)
+		common, rare := or.matchers[0], or.matchers[1]
+		if common.maxScore() >= rare.maxScore() {
+			t.Fatal(model, common.maxScore(), rare.maxScore())
+		}
+		root.setMinScore(common.maxScore())
+		count := 0
+		for docId := root.advance(0); docId != noMoreDocs; docId = root.advance(docId + 1) {
+			if matches := root.collect(docId, nil); len(matches) != 2 {
+				t.Error(model, "非必要的子条件没有跟上", len(matches))
+			}
+			count++
+		}
+		if count != 6 {
+			t.Error(model, count)
+		}
+		root.setMinScore(common.maxScore() + rare.maxScore())
+		if root.advance(0) != noMoreDocs {
+			t.Error(model, "没有提前结束")
+		}
+	}
+}
@@ -0,0 +1,459 @@
+// 分片：URL 按一致性哈希分配到注册中心中存活的分片（不包括所有副本都在下线的），每个分片在哈希环上有
+// 多个虚拟节点，增减分片时只有少量 URL 需要换地方。爬虫按同样的规则把文档发送给分片的所有副本，索引服务器定期检查
+// 本地的 URL，把不属于本分片的文档连同出链、锚文本通过 PUT /migrate 迁移到新分片的所有副本，然后删除本地的数据。
+// 迁移期间在注册中心登记 indexer.migrating，web 据此查询所有索引服务器并按 URL 去重
+package core
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"search-engine/index/config"
+	"search-engine/index/db"
+	"search-engine/index/util"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	virtualNodeCount = 160 // 每个索引服务器的虚拟节点数量，要和爬虫的一致
+	migrateBatchSize = 500 // 每个迁移请求的文档数量
+)
+
+// 注册中心中的服务名
+const (
+	serviceIndexer   = "indexer.addr"
+	serviceDraining  = "indexer.draining"  // 正在下线的索引服务器，不再分配 URL，文档迁移完后可以停止
+	serviceMigrating = "indexer.migrating" // 正在迁移文档的索引服务器
+	// 副本，见 replica.go
+	serviceShards      = "indexer.shards"     // 所有分片的名称
+	serviceShardPrefix = "indexer.shard:"     // indexer.shard:<分片名> 是分片的副本
+	serviceRecovering  = "indexer.recovering" // 正在从其他副本同步数据的索引服务器，不能读
+)
+
+// 一致性哈希环
+type hashRing struct {
+	hashes []uint32
+	nodes  map[uint32]string
+}
+
+func newHashRing(addrs []string) *hashRing {
+	r := &hashRing{nodes: make(map[uint32]string, len(addrs)*virtualNodeCount)}
+	// 排序后哈希冲突时的结果与地址的顺序无关
+	addrs = append([]string(nil), addrs...)
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		for i := 0; i < virtualNodeCount; i++ {
+			h := ringHash(addr + "#" + strconv.Itoa(i))
+			if _, ok := r.nodes[h]; !ok {
+				r.nodes[h] = addr
+				r.hashes = append(r.hashes, h)
+			}
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+	return r
+}
+
+func ringHash(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// URL 所属的索引服务器，环为空时返回空字符串
+func (r *hashRing) get(url string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := ringHash(url)
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
+
+// 迁移的文档，只有锚文本（本机没有文档）时 Indexed 为 false
+type MigratedDocument struct {
+	Url         string            `json:"url"`
+	Indexed     bool              `json:"indexed"`
+	Title       string            `json:"title,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	Boilerplate string            `json:"boilerplate,omitempty"`
+	Links       []string          `json:"links,omitempty"`
+	Anchors     map[string]string `json:"anchors,omitempty"` // 来源 URL -> 锚文本
+}
+
+// 注册中心中存活的地址
+func aliveAddrs(service string) ([]string, error) {
+	r, err := db.Registry.List(service)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for addr, heartbeatTime := range r {
+		// 40秒内认为存活
+		if time.Now().Unix()-heartbeatTime < 40 {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// 本机所属的分片，没有配置 indexer.shard 时每个索引服务器单独是一个分片
+func ShardName() string {
+	if shard := config.Get("indexer.shard"); shard != "" {
+		return shard
+	}
+	return config.Get("indexer.listenAddr")
+}
+
+// 分片拓扑：每个分片存活的副本，以及副本的状态
+type shardTopology struct {
+	replicas   map[string][]string // 分片名 -> 存活的副本地址
+	draining   map[string]bool
+	recovering map[string]bool
+}
+
+// 从注册中心读取分片拓扑。没有登记分片的索引服务器（旧版本）单独是一个分片，分片名就是地址
+func loadTopology() (*shardTopology, error) {
+	alive, err := aliveAddrs(serviceIndexer)
+	if err != nil {
+		return nil, err
+	}
+	t := &shardTopology{replicas: make(map[string][]string)}
+	if t.draining, err = aliveSet(serviceDraining); err != nil {
+		return nil, err
+	}
+	if t.recovering, err = aliveSet(serviceRecovering); err != nil {
+		return nil, err
+	}
+	names, err := aliveAddrs(serviceShards)
+	if err != nil {
+		return nil, err
+	}
+	isAlive := make(map[string]bool, len(alive))
+	for _, addr := range alive {
+		isAlive[addr] = true
+	}
+	grouped := make(map[string]bool)
+	for _, name := range names {
+		members, err := aliveAddrs(serviceShardPrefix + name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range members {
+			if isAlive[addr] && !grouped[addr] {
+				grouped[addr] = true
+				t.replicas[name] = append(t.replicas[name], addr)
+			}
+		}
+	}
+	for _, addr := range alive {
+		if !grouped[addr] {
+			t.replicas[addr] = []string{addr}
+		}
+	}
+	for _, addrs := range t.replicas {
+		sort.Strings(addrs)
+	}
+	return t, nil
+}
+
+func aliveSet(service string) (map[string]bool, error) {
+	addrs, err := aliveAddrs(service)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		set[addr] = true
+	}
+	return set, nil
+}
+
+// 分配 URL 的分片：至少有一个不在下线的副本
+func (t *shardTopology) ringShards() []string {
+	var shards []string
+	for name := range t.replicas {
+		if len(t.writeReplicas(name)) > 0 {
+			shards = append(shards, name)
+		}
+	}
+	return shards
+}
+
+// 写入分片时要发送的副本：不在下线的，包括正在同步的
+func (t *shardTopology) writeReplicas(shard string) []string {
+	var addrs []string
+	for _, addr := range t.replicas[shard] {
+		if !t.draining[addr] {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// 可以读的副本：已经同步完成的
+func (t *shardTopology) healthyReplicas(shard string) []string {
+	var addrs []string
+	for _, addr := range t.replicas[shard] {
+		if !t.recovering[addr] {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// 开始下线：不再分配 URL，把所有文档迁移到其他索引服务器
+func (e *Engine) Drain() {
+	if atomic.CompareAndSwapInt32(&e.draining, 0, 1) {
+		e.registerDraining()
+		// 不等下一次定时检查
+		go e.rebalance()
+	}
+}
+
+func (e *Engine) Draining() bool {
+	return atomic.LoadInt32(&e.draining) == 1
+}
+
+func (e *Engine) registerDraining() {
+	if err := db.Registry.Register(serviceDraining, config.Get("indexer.listenAddr")); err != nil {
+		log.Println("注册下线状态失败：" + err.Error())
+	}
+}
+
+// 定时检查并迁移不属于本机的文档，interval 为间隔（秒），小于等于 0 时不迁移
+func (e *Engine) startRebalanceGoroutine(interval int) {
+	if interval <= 0 || db.Registry == nil {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(interval) * time.Second)
+			if e.Draining() {
+				// 心跳
+				e.registerDraining()
+			}
+			e.rebalance()
+		}
+	}()
+}
+
+// 把不属于本机的 URL 迁移到所属的索引服务器，同一时间只有一个迁移在进行
+func (e *Engine) rebalance() {
+	if !atomic.CompareAndSwapInt32(&e.migrating, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&e.migrating, 0)
+
+	self := config.Get("indexer.listenAddr")
+	selfShard := ShardName()
+	topology, err := loadTopology()
+	if err != nil {
+		log.Println("获取索引服务器地址失败：" + err.Error())
+		return
+	}
+	shards := topology.ringShards()
+	ring := newHashRing(shards)
+	// 还没注册或者注册中心不可用时环中没有自己的分片，这时不能确定哪些 URL 属于自己。
+	// 分片中只有部分副本下线时分片仍然在环中，其他副本有同样的文档，不需要迁移
+	if !e.Draining() && !contains(shards, selfShard) {
+		return
+	}
+	targets := make(map[string][]string)
+	for _, url := range e.DB.Urls() {
+		if owner := ring.get(url); owner != "" && owner != selfShard {
+			targets[owner] = append(targets[owner], url)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	_ = db.Registry.Register(serviceMigrating, self)
+	defer func() {
+		_ = db.Registry.Deregister(serviceMigrating, self)
+	}()
+	for shard, urls := range targets {
+		begin := time.Now()
+		count, err := e.migrateTo(topology.writeReplicas(shard), urls)
+		log.Printf("迁移 %d/%d 个 URL 到分片 %s，耗时 %.1fs\n", count, len(urls), shard, time.Since(begin).Seconds())
+		if err != nil {
+			log.Println("迁移失败：" + err.Error())
+		}
+	}
+}
+
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// 分批把 urls 迁移到分片的所有副本 addrs，每批以 JSON Lines 流式发送，所有副本都确认（已经写入磁盘）后删除本地的数据，
+// 返回迁移完的数量。接收方不替换已有的文档，部分副本失败后重新发送也没有问题
+func (e *Engine) migrateTo(addrs []string, urls []string) (int, error) {
+	self := config.Get("indexer.listenAddr")
+	count := 0
+	for start := 0; start < len(urls); start += migrateBatchSize {
+		batch := urls[start:util.MinInt(start+migrateBatchSize, len(urls))]
+		for _, addr := range addrs {
+			if err := e.migrateBatch(addr, batch); err != nil {
+				return count, err
+			}
+		}
+		for _, url := range batch {
+			if _, err := e.DB.DeleteDocument(url); err != nil {
+				log.Println(err.Error())
+			}
+			if err := e.DB.DeleteAnchors(url); err != nil {
+				log.Println(err.Error())
+			}
+		}
+		count += len(batch)
+		// 迁移可能持续很久，保持登记状态
+		_ = db.Registry.Register(serviceMigrating, self)
+	}
+	return count, nil
+}
+
+func (e *Engine) migrateBatch(addr string, batch []string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(e.writeMigratedDocuments(writer, batch))
+	}()
+	err := putMigratedDocuments(addr, reader)
+	if err != nil {
+		_ = reader.CloseWithError(err)
+	}
+	return err
+}
+
+// 把 urls 的文档以 JSON Lines 写入 w
+func (e *Engine) writeMigratedDocuments(w io.Writer, urls []string) error {
+	encoder := json.NewEncoder(w)
+	for _, url := range urls {
+		if err := encoder.Encode(e.migratedDocument(url)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) migratedDocument(url string) *MigratedDocument {
+	doc := &MigratedDocument{Url: url, Anchors: e.DB.GetAnchorSources(url)}
+	if docId := e.DB.GetDocumentId(url); docId >= 0 {
+		doc.Indexed = true
+		_, doc.Title, doc.Body = e.DB.GetDocument(docId)
+		doc.Boilerplate = e.DB.GetDocumentBoilerplate(docId)
+		doc.Links, _ = e.DB.GetDocumentLinks(url)
+	}
+	return doc
+}
+
+func putMigratedDocuments(addr string, body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/migrate", addr), body)
+	if err != nil {
+		return err
+	}
+	resp, err := peerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var r struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return err
+	} else if r.Code != 0 {
+		return fmt.Errorf("%s: %s", addr, r.Msg)
+	}
+	return nil
+}
+
+// 接收其他索引服务器迁移过来的文档（JSON Lines），返回接收的数量。
+// 本机已经有的文档是爬虫按新的分片规则发送的，比迁移过来的新，不替换。
+// 迁移过来的文档不经过 indexChannel，直接建索引并写成一个新的段后才返回，返回成功时数据已经写入磁盘，
+// 发送方收到确认后才会删除，进程崩溃也不会丢失文档
+func (e *Engine) ReceiveDocuments(r io.Reader) (int, error) {
+	decoder := json.NewDecoder(r)
+	index := invertedIndex{}
+	indexed := make(map[string]int) // 本次建索引的 URL -> 文档 ID
+	count := 0
+	var err error
+	for {
+		doc := &MigratedDocument{}
+		if err = decoder.Decode(doc); err != nil {
+			break
+		}
+		if len(doc.Anchors) > 0 {
+			if err = e.DB.MergeAnchors(doc.Url, doc.Anchors); err != nil {
+				break
+			}
+		}
+		// indexDocument 立即保存文档，同一个 URL 重复发送时不会重复建索引
+		if doc.Indexed && e.DB.GetDocumentId(doc.Url) < 0 {
+			e.indexMigratedDocument(doc, index, indexed)
+		}
+		count++
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	// 出错之前已经建索引的文档也写入磁盘，发送方重新发送时跳过
+	if writeErr := e.writeIndexed(index, indexed); writeErr != nil {
+		return 0, writeErr
+	}
+	return count, err
+}
+
+// 为迁移、同步过来的文档建索引，倒排索引合并到 index 中，建索引的 URL 和文档 ID 记录到 indexed 中
+func (e *Engine) indexMigratedDocument(doc *MigratedDocument, index invertedIndex, indexed map[string]int) {
+	docIndex := e.indexManager.indexDocument(&rawDocument{
+		url:    doc.Url,
+		parsed: &parsedDocument{title: doc.Title, body: doc.Body, boilerplate: doc.Boilerplate},
+		links:  doc.Links,
+	})
+	if docIndex != nil {
+		index.merge(docIndex)
+		indexed[doc.Url] = e.DB.GetDocumentId(doc.Url)
+	}
+}
+
+// 将 indexMigratedDocument 建立的索引写成一个新的段，写入后才能检索到这些文档
+func (e *Engine) writeIndexed(index invertedIndex, indexed map[string]int) error {
+	if len(index) == 0 {
+		return nil
+	}
+	err := e.indexManager.writeSegment(index)
+	if err == nil {
+		return nil
+	}
+	// 没有倒排列表的文档检索不到，删除后发送方重新发送时再建索引
+	for url, docId := range indexed {
+		if e.DB.GetDocumentId(url) != docId {
+			continue
+		}
+		if _, delErr := e.DB.DeleteDocument(url); delErr != nil {
+			log.Println(delErr.Error())
+		}
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"search-engine/index/db"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	ring := newHashRing([]string{"a:1", "b:1", "c:1"})
+	if r := newHashRing([]string{"c:1", "a:1", "b:1"}); !reflect.DeepEqual(ring, r) {
+		t.Error("地址的顺序不同")
+	}
+	if newHashRing(nil).get("http://a.com") != "" {
+		t.Error("空的环")
+	}
+	const n = 30000
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[ring.get(fmt.Sprint("http://a.com/", i))]++
+	}
+	for addr, count := range counts {
+		if count < n/4 || count > n*5/12 {
+			t.Error(addr, count)
+		}
+	}
+	// 增加节点时只有分给新节点的 URL 换地方
+	ring2 := newHashRing([]string{"a:1", "b:1", "c:1", "d:1"})
+	moved := 0
+	for i := 0; i < n; i++ {
+		url := fmt.Sprint("http://a.com/", i)
+		if owner := ring2.get(url); owner != ring.get(url) {
+			moved++
+			if owner != "d:1" {
+				t.Fatal(url, owner)
+			}
+		}
+	}
+	if moved < n/6 || moved > n/3 {
+		t.Error(moved)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	src := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com": {"搜索引擎", "倒排索引"},
+	})
+	source := &Engine{DB: src.db}
+	_ = source.DB.SetDocumentBoilerplate(source.DB.GetDocumentId("http://a.com"), "首页")
+	_ = source.DB.SetDocumentLinks("http://a.com", []string{"http://b.com"})
+	_ = source.DB.AddAnchors("http://src.com", map[string]string{"http://a.com": "教程", "http://stub.com": "未抓取"})
+
+	dst := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://old.com": {"已有", "文档"},
+	})
+	target := &Engine{DB: dst.db, indexManager: &indexManager{db: dst.db, textProcessor: dst.textProcessor}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := target.ReceiveDocuments(r.Body); err != nil {
+			_, _ = w.Write([]byte(`{"code":1,"msg":"error"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}))
+	defer server.Close()
+	// 分片的另一个副本
+	replicaReceived := 0
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for decoder := json.NewDecoder(r.Body); decoder.More(); replicaReceived++ {
+			_ = decoder.Decode(&MigratedDocument{})
+		}
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}))
+	defer replica.Close()
+
+	urls := []string{"http://a.com", "http://stub.com", "http://old.com"}
+	_ = source.DB.AddAnchors("http://src.com", map[string]string{"http://old.com": "旧"})
+	_, _ = source.DB.AddDocument("http://old.com", "旧的", "文档", nil)
+	addrs := []string{strings.TrimPrefix(server.URL, "http://"), strings.TrimPrefix(replica.URL, "http://")}
+	count, err := source.migrateTo(addrs, urls)
+	if err != nil || count != 3 || replicaReceived != 3 {
+		t.Fatal(count, replicaReceived, err)
+	}
+
+	// 本地的数据已删除
+	if len(source.DB.Urls()) != 0 || source.DB.GetDocumentsCount() != 0 {
+		t.Error(source.DB.Urls())
+	}
+	// 确认时迁移过来的文档已经写入磁盘，可以检索到
+	docId := target.DB.GetDocumentId("http://a.com")
+	results := searchQuery(t, dst, "倒排", 10, SortByScore)
+	if len(results.Items) != 1 || results.Items[0].docId != docId {
+		t.Fatalf("%+v", results.Items)
+	}
+	links, _ := target.DB.GetDocumentLinks("http://a.com")
+	if target.DB.GetDocumentBoilerplate(docId) != "首页" || !reflect.DeepEqual(links, []string{"http://b.com"}) {
+		t.Error(target.DB.GetDocumentBoilerplate(docId), links)
+	}
+	// 重新发送不会重复建索引
+	doc, _ := json.Marshal(&MigratedDocument{Url: "http://a.com", Indexed: true, Title: "搜索引擎", Body: "倒排索引"})
+	if count, err := target.ReceiveDocuments(bytes.NewReader(doc)); err != nil || count != 1 {
+		t.Fatal(count, err)
+	}
+	if target.DB.GetDocumentId("http://a.com") != docId {
+		t.Error("重复建索引")
+	}
+	// 已有的文档不替换，只有锚文本的 URL 只迁移锚文本
+	if target.DB.GetDocumentId("http://stub.com") >= 0 {
+		t.Error("只有锚文本的 URL")
+	}
+	if _, title, _ := target.DB.GetDocument(target.DB.GetDocumentId("http://old.com")); title != "已有" {
+		t.Error(title)
+	}
+	for url, text := range map[string]string{"http://a.com": "教程", "http://stub.com": "未抓取", "http://old.com": "旧"} {
+		if got := target.DB.GetAnchorText(url); got != text {
+			t.Error(url, got)
+		}
+	}
+}
+
+func TestShardTopology(t *testing.T) {
+	registry := db.Registry
+	defer func() {
+		db.Registry = registry
+	}()
+	db.Registry = db.NewMemoryRegistry()
+	for _, addr := range []string{"a:1", "b:1", "c:1", "d:1"} {
+		_ = db.Registry.Register(serviceIndexer, addr)
+	}
+	_ = db.Registry.Register(serviceShards, "s1")
+	_ = db.Registry.Register(serviceShards, "s2")
+	_ = db.Registry.Register(serviceShardPrefix+"s1", "a:1")
+	_ = db.Registry.Register(serviceShardPrefix+"s1", "b:1")
+	_ = db.Registry.Register(serviceShardPrefix+"s2", "c:1")
+	_ = db.Registry.Register(serviceShardPrefix+"s2", "e:1") // 已经不存活
+	_ = db.Registry.Register(serviceDraining, "c:1")
+	_ = db.Registry.Register(serviceRecovering, "b:1")
+
+	topology, err := loadTopology()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有登记分片的 d:1 单独是一个分片
+	want := map[string][]string{"s1": {"a:1", "b:1"}, "s2": {"c:1"}, "d:1": {"d:1"}}
+	if !reflect.DeepEqual(topology.replicas, want) {
+		t.Error(topology.replicas)
+	}
+	shards := topology.ringShards()
+	sort.Strings(shards)
+	if !reflect.DeepEqual(shards, []string{"d:1", "s1"}) {
+		t.Error(shards)
+	}
+	if r := topology.writeReplicas("s1"); !reflect.DeepEqual(r, []string{"a:1", "b:1"}) {
+		t.Error(r)
+	}
+	if r := topology.healthyReplicas("s1"); !reflect.DeepEqual(r, []string{"a:1"}) {
+		t.Error(r)
+	}
+}
@@ -0,0 +1,270 @@
+// 结果高亮及摘要。索引服务器只返回纯文本和高亮区间，由 web 服务器转义文本后再加上高亮标签，
+// 这样文档中的 HTML 标签、脚本只会原样显示，不会被浏览器执行。
+// 摘要按句子切分，选出包含关键词最多的几个句子作为片段，太长的句子只截取关键词附近的部分
+package core
+
+import (
+	"search-engine/index/db"
+	"search-engine/index/util"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxSnippetLength    = 160 // 摘要的总字数
+	maxFragmentLength   = 80  // 一个片段的最大字数
+	minFragmentLength   = 20  // 剩余的字数少于这个值时不再添加片段
+	maxSnippetFragments = 3   // 片段的最大数量
+	fragmentContext     = 20  // 截取长句子时，第一个关键词前面保留的字数
+)
+
+// 摘要中的一个片段，通常是一个完整的句子
+type snippet struct {
+	Text       string   `json:"text"`
+	Highlights [][2]int `json:"highlights,omitempty"` // 高亮区间 [start, end)，是 Text 中的字符下标
+}
+
+// 获取结果的文档信息，计算标题的高亮区间和摘要，只对最终返回的结果计算
+func (s *SearchResults) applyHighlight(db *db.IndexDB) {
+	for _, item := range s.Items {
+		url, title, body := db.GetDocument(item.docId)
+		titleChars, bodyChars := []rune(title), []rune(body)
+		item.Url = url
+		item.Title = title
+		item.TitleHighlights = highlightIntervals(item.matches, fieldTitle, titleChars)
+		bodyHighlights := highlightIntervals(item.matches, fieldBody, bodyChars)
+		// 关键词只出现在模板文字中时，用模板文字作为摘要
+		if len(bodyHighlights) == 0 {
+			boilerplateChars := []rune(db.GetDocumentBoilerplate(item.docId))
+			if h := highlightIntervals(item.matches, fieldBoilerplate, boilerplateChars); len(h) > 0 {
+				bodyChars, bodyHighlights = boilerplateChars, h
+			}
+		}
+		item.Snippets = makeSnippets(bodyChars, bodyHighlights)
+		// 只有关键词出现在锚文本中时才返回锚文本的摘要
+		anchorChars := []rune(db.GetDocumentAnchor(item.docId))
+		if h := highlightIntervals(item.matches, fieldAnchor, anchorChars); len(h) > 0 {
+			item.Anchors = makeSnippets(anchorChars, h)
+		}
+	}
+}
+
+// 所有关键词中的词元在字段 field 的文本 text 中的高亮区间，已排序，相交或相邻的区间已合并
+func highlightIntervals(matches []keywordMatch, field int, text []rune) [][2]int {
+	base := 0
+	switch field {
+	case fieldBoilerplate:
+		base = boilerplateBase
+	case fieldAnchor:
+		base = anchorBase
+	}
+	var intervals [][2]int
+	for _, m := range matches {
+		for i, item := range m.tokens {
+			cursor := m.cursors[i]
+			r := fieldRanges(cursor)[field]
+			for _, pos := range cursor.positions[r[0]:r[1]] {
+				if pos -= base; pos < len(text) {
+					intervals = append(intervals, [2]int{pos, tokenEnd(text, pos, item.token)})
+				}
+			}
+		}
+	}
+	if len(intervals) == 0 {
+		return nil
+	}
+	// 排序合并区间
+	// query:ABC  DOC:ABCABGC  =>  AB:{0,3} BC:{1} => {0,2} {1,3} {3,5} => {0,5}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i][0] < intervals[j][0]
+	})
+	pos := 0
+	for i := 1; i < len(intervals); i++ {
+		if intervals[i][0] <= intervals[pos][1] {
+			intervals[pos][1] = util.MaxInt(intervals[pos][1], intervals[i][1])
+		} else {
+			pos++
+			intervals[pos] = intervals[i]
+		}
+	}
+	return intervals[:pos+1]
+}
+
+// 词元在原文本中的结束位置（不包含）。词干、n-gram 只是单词的一部分，拉丁字母等以空格分隔的单词
+// 高亮整个单词；汉字、假名规范化前后一般是一一对应的，按词元的字数计算
+func tokenEnd(text []rune, pos int, token string) int {
+	end := pos + 1
+	switch charClass(text[pos]) {
+	case charWord:
+		for end < len(text) && charClass(text[end]) == charWord {
+			end++
+		}
+	case charHan, charKana:
+		limit := util.MinInt(pos+utf8.RuneCountInString(token), len(text))
+		for end < limit && charClass(text[end]) != charOther {
+			end++
+		}
+	}
+	return end
+}
+
+// 句末的标点，英文句号后面需要是空白才算句末，避免切开小数、网址
+func isSentenceEnd(text []rune, i int) bool {
+	switch text[i] {
+	case '。', '！', '？', '；', '…', '!', '?', ';', '\n':
+		return true
+	case '.':
+		return i+1 == len(text) || unicode.IsSpace(text[i+1])
+	}
+	return false
+}
+
+// 将文本切分成句子，返回去掉首尾空白的句子区间 [start, end)，忽略空句子
+func splitSentences(text []rune) [][2]int {
+	var sentences [][2]int
+	add := func(start, end int) {
+		for start < end && unicode.IsSpace(text[start]) {
+			start++
+		}
+		for end > start && unicode.IsSpace(text[end-1]) {
+			end--
+		}
+		if start < end {
+			sentences = append(sentences, [2]int{start, end})
+		}
+	}
+	start := 0
+	for i := range text {
+		if isSentenceEnd(text, i) {
+			add(start, i+1)
+			start = i + 1
+		}
+	}
+	add(start, len(text))
+	return sentences
+}
+
+// 由正文和高亮区间生成摘要。贪心地选择句子：优先选择包含未出现过的关键词最多的句子，
+// 其次是关键词出现次数多的、靠前的句子，直到字数或片段数量达到上限，最后按位置排序。
+// 没有高亮区间（关键词只在标题中出现）时取正文开头的部分
+func makeSnippets(body []rune, highlights [][2]int) []snippet {
+	sentences := splitSentences(body)
+	if len(sentences) == 0 {
+		return nil
+	}
+	if len(highlights) == 0 {
+		start, end := sentences[0][0], sentences[0][1]
+		for _, s := range sentences[1:] {
+			if s[1]-start > maxSnippetLength {
+				break
+			}
+			end = s[1]
+		}
+		end = util.MinInt(end, start+maxSnippetLength)
+		return []snippet{newSnippet(body, [2]int{start, end}, nil)}
+	}
+
+	// 每个句子中的高亮区间及其中的关键词
+	type candidate struct {
+		sentence [2]int
+		hits     [][2]int
+		terms    []string
+	}
+	var candidates []*candidate
+	h := 0
+	for _, s := range sentences {
+		c := &candidate{sentence: s}
+		for h < len(highlights) && highlights[h][0] < s[1] {
+			if highlights[h][0] >= s[0] {
+				c.hits = append(c.hits, highlights[h])
+				c.terms = append(c.terms, strings.ToLower(string(body[highlights[h][0]:highlights[h][1]])))
+			}
+			h++
+		}
+		if len(c.hits) > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	var fragments [][2]int
+	seen := make(map[string]bool) // 已选择的片段中出现过的关键词
+	remaining := maxSnippetLength
+	for len(fragments) < maxSnippetFragments && remaining >= minFragmentLength && len(candidates) > 0 {
+		best, bestNew := 0, -1
+		for i, c := range candidates {
+			newTerms := 0
+			for _, term := range c.terms {
+				if !seen[term] {
+					newTerms++
+				}
+			}
+			// 候选句子按位置排列，相等时保留靠前的
+			if newTerms > bestNew || newTerms == bestNew && len(c.hits) > len(candidates[best].hits) {
+				best, bestNew = i, newTerms
+			}
+		}
+		c := candidates[best]
+		candidates = append(candidates[:best], candidates[best+1:]...)
+		if bestNew == 0 && len(fragments) > 0 {
+			// 剩下的句子只包含重复的关键词
+			break
+		}
+		fragment := cropFragment(body, c.sentence, c.hits[0], util.MinInt(maxFragmentLength, remaining))
+		fragments = append(fragments, fragment)
+		remaining -= fragment[1] - fragment[0]
+		for _, term := range c.terms {
+			seen[term] = true
+		}
+	}
+	sort.Slice(fragments, func(i, j int) bool {
+		return fragments[i][0] < fragments[j][0]
+	})
+
+	snippets := make([]snippet, len(fragments))
+	for i, f := range fragments {
+		snippets[i] = newSnippet(body, f, highlights)
+	}
+	return snippets
+}
+
+// 句子超过 length 个字时，截取从第一个关键词前 fragmentContext 个字开始的 length 个字，
+// 并尽量不从单词中间截断
+func cropFragment(text []rune, sentence, firstHit [2]int, length int) [2]int {
+	start, end := sentence[0], sentence[1]
+	if end-start <= length {
+		return sentence
+	}
+	start = util.MaxInt(start, firstHit[0]-fragmentContext)
+	end = util.MinInt(end, start+length)
+	start = util.MaxInt(sentence[0], end-length)
+	inWord := func(i int) bool {
+		return charClass(text[i-1]) == charWord && charClass(text[i]) == charWord
+	}
+	for start > sentence[0] && start < firstHit[0] && inWord(start) {
+		start++
+	}
+	for end < sentence[1] && end > firstHit[1] && inWord(end) {
+		end--
+	}
+	for start < firstHit[0] && unicode.IsSpace(text[start]) {
+		start++
+	}
+	for end > firstHit[1] && unicode.IsSpace(text[end-1]) {
+		end--
+	}
+	return [2]int{start, end}
+}
+
+// 截取 text 中的区间 f 作为片段，高亮区间转换为片段中的下标，超出片段的部分被截掉
+func newSnippet(text []rune, f [2]int, highlights [][2]int) snippet {
+	s := snippet{Text: string(text[f[0]:f[1]])}
+	for _, h := range highlights {
+		start, end := util.MaxInt(h[0], f[0]), util.MinInt(h[1], f[1])
+		if start < end {
+			s.Highlights = append(s.Highlights, [2]int{start - f[0], end - f[0]})
+		}
+	}
+	return s
+}
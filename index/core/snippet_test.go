@@ -0,0 +1,66 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMakeSnippets(t *testing.T) {
+	body := []rune("第一句没有关键词。搜索引擎使用倒排索引！这一句也没有。倒排索引记录词元的位置。")
+	// 搜索引擎 {9,13}，倒排索引 {15,19} {27,31}
+	snippets := makeSnippets(body, [][2]int{{9, 13}, {15, 19}, {27, 31}})
+	want := []snippet{{Text: "搜索引擎使用倒排索引！", Highlights: [][2]int{{0, 4}, {6, 10}}}}
+	if !reflect.DeepEqual(snippets, want) {
+		t.Errorf("%+v", snippets)
+	}
+
+	// 不同的关键词在不同的句子中
+	snippets = makeSnippets(body, [][2]int{{9, 11}, {27, 31}})
+	want = []snippet{
+		{Text: "搜索引擎使用倒排索引！", Highlights: [][2]int{{0, 2}}},
+		{Text: "倒排索引记录词元的位置。", Highlights: [][2]int{{0, 4}}},
+	}
+	if !reflect.DeepEqual(snippets, want) {
+		t.Errorf("%+v", snippets)
+	}
+
+	// 没有高亮时取开头
+	snippets = makeSnippets(body, nil)
+	if len(snippets) != 1 || snippets[0].Text != string(body) {
+		t.Errorf("%+v", snippets)
+	}
+
+	// 长句子只截取关键词附近的部分，不从单词中间截断
+	long := []rune(strings.Repeat("lorem ipsum ", 20) + "search engine " + strings.Repeat("dolor sit ", 20))
+	snippets = makeSnippets(long, [][2]int{{240, 246}})
+	s := snippets[0]
+	if len(snippets) != 1 || len([]rune(s.Text)) > maxFragmentLength ||
+		strings.HasPrefix(s.Text, "psum") || string([]rune(s.Text)[s.Highlights[0][0]:s.Highlights[0][1]]) != "search" {
+		t.Errorf("%+v", snippets)
+	}
+}
+
+func TestSearchResults_ApplyHighlight(t *testing.T) {
+	s := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com": {"<b>Searching</b> engines", "倒排索引是搜索引擎的核心。Search engines use it."},
+	})
+	results := searchQuery(t, s, "search 搜索引擎", 10, SortByScore)
+	results.sortResults()
+	results.applyHighlight(s.db)
+	if len(results.Items) != 1 {
+		t.Fatal(results.Items)
+	}
+	item := results.Items[0]
+	// 标题是纯文本，词干高亮整个单词
+	if item.Title != "<b>Searching</b> engines" || !reflect.DeepEqual(item.TitleHighlights, [][2]int{{3, 12}}) {
+		t.Errorf("%q %v", item.Title, item.TitleHighlights)
+	}
+	want := []snippet{
+		{Text: "倒排索引是搜索引擎的核心。", Highlights: [][2]int{{2, 4}, {5, 9}}}, // 关键词中的词元单独出现时也会高亮
+		{Text: "Search engines use it.", Highlights: [][2]int{{0, 6}}},
+	}
+	if !reflect.DeepEqual(item.Snippets, want) {
+		t.Errorf("%+v", item.Snippets)
+	}
+}
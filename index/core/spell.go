@@ -0,0 +1,445 @@
+// 拼写纠错（您是不是要找）。词典由索引的词表（token_doc_count）和检索过、有结果的查询中的词组成：
+// 以空格分词的单词（拉丁字母等）使用 SymSpell 算法，预先为词典中的每个词生成删除若干字符后的字符串（删除串），
+// 检索时只要生成查询词的删除串去查表，就能找到编辑距离不超过 2 的候选词，不用和整个词典比较；
+// 汉字没有拼错的说法，打错一般是同音字，按拼音查找同音的词，如 搜锁引擎 => 搜索引擎
+package core
+
+import (
+	"bufio"
+	_ "embed"
+	"log"
+	"search-engine/index/db"
+	"search-engine/index/util"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+//go:embed dict/pinyin.txt
+var pinyinData string
+
+// 汉字 -> 拼音（不带声调），多音字只有一个读音
+var pinyinOf = make(map[rune]string, 21000)
+
+func init() {
+	scanner := bufio.NewScanner(strings.NewReader(pinyinData))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		for _, ch := range fields[1] {
+			pinyinOf[ch] = fields[0]
+		}
+	}
+}
+
+const (
+	spellMaxDistance   = 2      // 最大编辑距离，不超过 4 个字符的词最多为 1
+	spellPrefixLength  = 7      // 只为前 7 个字符生成删除串，减少内存，候选词再用完整的词计算距离
+	spellMaxWordLength = 30     // 更长的词不纠错
+	spellMaxHanLength  = 6      // 汉语词最多几个字
+	spellMaxObserved   = 100000 // 记录的查询词数量上限，超过后不再记录新词
+)
+
+// 拼写纠错的词典，后台定期重建，建好后只读
+type spellDict struct {
+	words   map[string]int      // 单词 -> 频率（包含它的文档数量，或检索的次数）
+	deletes map[string][]string // 删除串 -> 单词
+	surface map[string]string   // 词元（词干）-> 检索过的原词，如 engin -> engine，建议中显示原词
+	han     map[string]int      // 汉语词 -> 频率
+	pinyin  map[string]string   // 拼音 -> 频率最高的汉语词
+}
+
+type spellChecker struct {
+	db       *db.IndexDB
+	analyzer Analyzer // 和建索引使用同一个分析器，判断一个词是否在词表中
+	lock     sync.RWMutex
+	dict     *spellDict // 还没建好时为 nil
+
+	observedLock sync.Mutex
+	observed     map[string]int // 检索过、有结果的查询中的词 -> 次数
+}
+
+func newSpellChecker(db *db.IndexDB, analyzer Analyzer) *spellChecker {
+	return &spellChecker{db: db, analyzer: analyzer, observed: make(map[string]int)}
+}
+
+// 启动时建一次词典，之后每隔 interval 秒重建，新的文档和查询才能加入词典
+func (e *Engine) startSpellGoroutine(interval int) {
+	go func() {
+		for {
+			start := time.Now()
+			e.spellChecker.rebuild()
+			log.Printf("拼写纠错词典已重建，耗时 %v\n", time.Since(start))
+			if interval <= 0 {
+				return
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+}
+
+func (c *spellChecker) rebuild() {
+	d := &spellDict{
+		words:   make(map[string]int),
+		deletes: make(map[string][]string),
+		surface: make(map[string]string),
+		han:     make(map[string]int),
+		pinyin:  make(map[string]string),
+	}
+	c.db.ForEachTokenDocCount(func(token string, count int) {
+		if count > 0 {
+			d.add(token, count)
+		}
+	})
+	c.observedLock.Lock()
+	observed := make(map[string]int, len(c.observed))
+	for word, count := range c.observed {
+		observed[word] = count
+	}
+	c.observedLock.Unlock()
+	// 检索过的单词只保留词元都在词表中的，同一个词元取检索次数最多的原词
+	surfaceCount := make(map[string]int)
+	for word, count := range observed {
+		if wordClass(word) != charWord {
+			d.add(word, count)
+			continue
+		}
+		tokens := analyzeQuery(c.analyzer, word)
+		if len(tokens) != 1 || d.words[tokens[0]] == 0 {
+			continue
+		}
+		d.add(word, count)
+		if count > surfaceCount[tokens[0]] {
+			d.surface[tokens[0]], surfaceCount[tokens[0]] = word, count
+		}
+	}
+	c.lock.Lock()
+	c.dict = d
+	c.lock.Unlock()
+}
+
+func (d *spellDict) add(word string, count int) {
+	length := len([]rune(word))
+	switch wordClass(word) {
+	case charWord:
+		if length > spellMaxWordLength {
+			return
+		}
+		if d.words[word] == 0 {
+			for _, del := range spellDeletes([]rune(word), spellMaxDistance) {
+				d.deletes[del] = append(d.deletes[del], word)
+			}
+		}
+		d.words[word] += count
+	case charHan:
+		// 单字词元只用于单字查询，不作为纠错的词
+		if length < 2 || length > spellMaxHanLength {
+			return
+		}
+		d.han[word] += count
+		key := pinyinKey([]rune(word))
+		if key == "" {
+			return
+		}
+		if best, ok := d.pinyin[key]; !ok || d.han[word] > d.han[best] {
+			d.pinyin[key] = word
+		}
+	}
+}
+
+// 词中所有字符的类别都相同时返回该类别，否则返回 charOther
+func wordClass(word string) int {
+	class := charOther
+	for i, ch := range word {
+		if i == 0 {
+			class = charClass(ch)
+		} else if charClass(ch) != class {
+			return charOther
+		}
+	}
+	return class
+}
+
+// 用分析器处理查询中的一个词，得到词元
+func analyzeQuery(analyzer Analyzer, text string) []string {
+	var tokens []string
+	analyzer.AnalyzeQuery(text, func(token string, pos int) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	return tokens
+}
+
+// 各个字的拼音用空格连接，有字没有拼音时返回空字符串
+func pinyinKey(chars []rune) string {
+	syllables := make([]string, len(chars))
+	for i, ch := range chars {
+		if syllables[i] = pinyinOf[ch]; syllables[i] == "" {
+			return ""
+		}
+	}
+	return strings.Join(syllables, " ")
+}
+
+///////////////////// SymSpell //////////////////////
+
+// word 的前 spellPrefixLength 个字符删除最多 distance 个字符得到的所有字符串，包括不删除的
+func spellDeletes(word []rune, distance int) []string {
+	if len(word) > spellPrefixLength {
+		word = word[:spellPrefixLength]
+	}
+	seen := map[string]bool{string(word): true}
+	level := [][]rune{word}
+	for i := 0; i < distance; i++ {
+		var next [][]rune
+		for _, w := range level {
+			// 不删成空字符串
+			if len(w) <= 1 {
+				continue
+			}
+			for j := range w {
+				del := string(w[:j]) + string(w[j+1:])
+				if !seen[del] {
+					seen[del] = true
+					next = append(next, []rune(del))
+				}
+			}
+		}
+		level = next
+	}
+	deletes := make([]string, 0, len(seen))
+	for del := range seen {
+		deletes = append(deletes, del)
+	}
+	return deletes
+}
+
+// 编辑距离（OSA），相邻两个字符交换算一次编辑
+func editDistance(a, b []rune) int {
+	// 三行滚动：d[i-2]、d[i-1]、d[i]
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = util.MinInt(util.MinInt(prev[j], cur[j-1])+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = util.MinInt(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// 词典中和 word 编辑距离最小的词，距离相同时取频率最高的，没有时返回空字符串
+func (d *spellDict) lookup(word string) (string, int) {
+	chars := []rune(word)
+	maxDistance := spellMaxDistance
+	if len(chars) <= 4 {
+		maxDistance = 1
+	}
+	best, bestDistance := "", maxDistance+1
+	for _, del := range spellDeletes(chars, maxDistance) {
+		for _, candidate := range d.deletes[del] {
+			distance := editDistance(chars, []rune(candidate))
+			if distance < bestDistance || distance == bestDistance &&
+				(d.words[candidate] > d.words[best] || d.words[candidate] == d.words[best] && candidate < best) {
+				best, bestDistance = candidate, distance
+			}
+		}
+	}
+	return best, bestDistance
+}
+
+///////////////////// 纠错 //////////////////////
+
+// 纠正一个单词，词元都在词表中（或是停用词）、含有数字时不纠正
+func (c *spellChecker) correctWord(d *spellDict, word string) (string, bool) {
+	tokens := analyzeQuery(c.analyzer, word)
+	known := true
+	for _, token := range tokens {
+		if d.words[token] == 0 {
+			known = false
+		}
+	}
+	if known || len([]rune(word)) > spellMaxWordLength || strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+		return word, false
+	}
+	best, distance := d.lookup(strings.ToLower(word))
+	// 词表中是词干，用词干再找一次，如 serching => serch => search
+	if len(tokens) == 1 {
+		if stem, stemDistance := d.lookup(tokens[0]); stem != "" && stemDistance < distance {
+			best = stem
+		}
+	}
+	if best == "" {
+		return word, false
+	}
+	if surface, ok := d.surface[best]; ok {
+		best = surface
+	}
+	return best, true
+}
+
+// 纠正一段汉字：从左到右，词典中有以当前字开头的词时保留最长的，
+// 否则用拼音相同的词中最长的替换，都没有时保留当前字
+func (d *spellDict) correctHan(chars []rune) ([]rune, bool) {
+	corrected := make([]rune, 0, len(chars))
+	changed := false
+	for i := 0; i < len(chars); {
+		end := i
+		for j := util.MinInt(len(chars), i+spellMaxHanLength); j >= i+2; j-- {
+			if d.han[string(chars[i:j])] > 0 {
+				end = j
+				break
+			}
+		}
+		if end > i {
+			corrected = append(corrected, chars[i:end]...)
+			i = end
+			continue
+		}
+		for j := util.MinInt(len(chars), i+spellMaxHanLength); j >= i+2; j-- {
+			if word, ok := d.pinyin[pinyinKey(chars[i:j])]; ok {
+				corrected = append(corrected, []rune(word)...)
+				end, changed = j, true
+				break
+			}
+		}
+		if end > i {
+			i = end
+		} else {
+			corrected = append(corrected, chars[i])
+			i++
+		}
+	}
+	return corrected, changed
+}
+
+// 纠正一个关键词（短语），只替换纠正了的部分，其他部分保持原样
+func (c *spellChecker) correctText(d *spellDict, text string) (string, bool) {
+	original := []rune(text)
+	chars, offsets := normalizeText(text)
+	var b strings.Builder
+	last, changed := 0, false
+	splitRuns(chars, func(class, start, end int) {
+		var corrected string
+		switch class {
+		case charWord:
+			word, ok := c.correctWord(d, string(chars[start:end]))
+			if !ok {
+				return
+			}
+			corrected = word
+		case charHan:
+			han, ok := d.correctHan(chars[start:end])
+			if !ok {
+				return
+			}
+			corrected = string(han)
+		default:
+			return
+		}
+		// 规范化后的区间对应到原文本
+		origStart, origEnd := offsets[start], len(original)
+		if end < len(offsets) {
+			origEnd = offsets[end]
+		}
+		b.WriteString(string(original[last:origStart]))
+		b.WriteString(corrected)
+		last, changed = origEnd, true
+	})
+	if !changed {
+		return text, false
+	}
+	b.WriteString(string(original[last:]))
+	return b.String(), true
+}
+
+// 遍历查询中要纠正的关键词、短语，fn 的参数为内容在查询中的位置（字符）。
+// site:、inurl: 的值不纠正，排除的关键词也不需要
+func forEachQueryText(tokens []queryToken, fn func(text string, pos int)) {
+	for i, t := range tokens {
+		if t.typ != queryTerm && t.typ != queryPhrase {
+			continue
+		}
+		if i > 0 && (tokens[i-1].typ == queryNot || tokens[i-1].typ == queryField && tokens[i-1].text != "intitle") {
+			continue
+		}
+		pos := t.pos
+		if t.typ == queryPhrase {
+			pos++
+		}
+		fn(t.text, pos)
+	}
+}
+
+// 返回纠正后的查询，不需要纠正或者词典还没建好时返回空字符串
+func (c *spellChecker) suggest(query string) string {
+	c.lock.RLock()
+	d := c.dict
+	c.lock.RUnlock()
+	tokens, err := lexQuery(query)
+	if d == nil || err != nil {
+		return ""
+	}
+	chars := []rune(query)
+	var b strings.Builder
+	last, changed := 0, false
+	forEachQueryText(tokens, func(text string, pos int) {
+		corrected, ok := c.correctText(d, text)
+		if !ok {
+			return
+		}
+		b.WriteString(string(chars[last:pos]))
+		b.WriteString(corrected)
+		last, changed = pos+len([]rune(text)), true
+	})
+	if !changed {
+		return ""
+	}
+	b.WriteString(string(chars[last:]))
+	return b.String()
+}
+
+// 记录有结果的查询中的词，下次重建时加入词典：单词转为小写，汉字取整段
+func (c *spellChecker) observe(query string) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return
+	}
+	var words []string
+	forEachQueryText(tokens, func(text string, pos int) {
+		chars, _ := normalizeText(text)
+		splitRuns(chars, func(class, start, end int) {
+			if class == charWord {
+				words = append(words, strings.ToLower(string(chars[start:end])))
+			} else if class == charHan && end-start >= 2 && end-start <= spellMaxHanLength {
+				words = append(words, string(chars[start:end]))
+			}
+		})
+	})
+	c.observedLock.Lock()
+	defer c.observedLock.Unlock()
+	for _, word := range words {
+		if _, ok := c.observed[word]; ok || len(c.observed) < spellMaxObserved {
+			c.observed[word]++
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package core
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		distance int
+	}{
+		{"search", "search", 0},
+		{"serch", "search", 1},
+		{"saerch", "search", 1}, // 交换相邻字符
+		{"engne", "engine", 1},
+		{"", "abc", 3},
+		{"搜锁", "搜索", 1},
+	} {
+		if d := editDistance([]rune(c.a), []rune(c.b)); d != c.distance {
+			t.Errorf("%s %s: %d", c.a, c.b, d)
+		}
+	}
+}
+
+func TestSpellChecker_Suggest(t *testing.T) {
+	s := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com/1": {"搜索引擎", "search engine 使用倒排索引"},
+		"http://a.com/2": {"倒排索引", "inverted index of a search engine"},
+	})
+	c := newSpellChecker(s.db, s.textProcessor.analyzer)
+	if c.suggest("serch") != "" {
+		t.Error("词典还没建好")
+	}
+	c.rebuild()
+	for query, want := range map[string]string{
+		"search engine":          "",
+		"serch index":            "search index",
+		"saerch OR invrted":      "search OR invert",
+		"搜锁引擎":                   "搜索引擎",
+		`"搜锁 引擎" site:serch.com`: `"搜索 引擎" site:serch.com`,
+		"搜索 -serch":              "",
+		"xyzzy":                  "",
+	} {
+		if got := c.suggest(query); got != want {
+			t.Errorf("%s: %q", query, got)
+		}
+	}
+	// 检索过的原词代替词干显示
+	c.observe("inverted index")
+	c.rebuild()
+	if got := c.suggest("invrted"); got != "inverted" {
+		t.Error(got)
+	}
+}
@@ -0,0 +1,98 @@
+// 同义词扩展：检索时把关键词（短语）扩展成 (原词 OR 同义词...)，如 golang => (golang OR "go 语言")，
+// 同义词匹配的分数乘以 synonymWeight，低于原词。同义词表由 web 后台管理，定期重新加载
+package core
+
+import (
+	"log"
+	"search-engine/index/db"
+	"strings"
+	"time"
+)
+
+const (
+	synonymWeight         = 0.5
+	synonymReloadInterval = time.Second * 30
+)
+
+// 规范化后的词 -> 同义词，后台的同一行中的词互为同义词
+type synonymDict map[string][]string
+
+func newSynonymDict(rows map[string][]string) synonymDict {
+	dict := make(synonymDict)
+	for word, synonyms := range rows {
+		group := append([]string{word}, synonyms...)
+		for _, w := range group {
+			key := normalizeSynonym(w)
+			for _, other := range group {
+				if normalizeSynonym(other) != key && !containsSynonym(dict[key], other) {
+					dict[key] = append(dict[key], other)
+				}
+			}
+		}
+	}
+	return dict
+}
+
+// 转为小写，合并空白
+func normalizeSynonym(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+func containsSynonym(list []string, word string) bool {
+	for _, w := range list {
+		if normalizeSynonym(w) == normalizeSynonym(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// 定期加载同义词表，加载失败（如没有建表）时只在错误变化时打印日志
+func (e *Engine) startSynonymGoroutine() {
+	source := db.NewSynonymSource()
+	go func() {
+		lastErr := ""
+		for {
+			if rows, err := source.LoadSynonyms(); err != nil {
+				if err.Error() != lastErr {
+					log.Println("加载同义词失败", err)
+					lastErr = err.Error()
+				}
+			} else {
+				e.synonyms.Store(newSynonymDict(rows))
+				lastErr = ""
+			}
+			time.Sleep(synonymReloadInterval)
+		}
+	}()
+}
+
+// 把语法树中有同义词的关键词、短语替换成 OR，包含空格的同义词作为短语；
+// 排除条件中的关键词同样扩展，排除 golang 也会排除 "go 语言"
+func (d synonymDict) expand(node *queryNode) *queryNode {
+	if node == nil || len(d) == 0 {
+		return node
+	}
+	switch node.typ {
+	case nodeKeyword, nodePhrase:
+		synonyms := d[normalizeSynonym(node.text)]
+		if len(synonyms) == 0 || node.synonym {
+			return node
+		}
+		or := &queryNode{typ: nodeOr, pos: node.pos, children: []*queryNode{node}}
+		for _, synonym := range synonyms {
+			typ := nodeKeyword
+			if len(strings.Fields(synonym)) > 1 {
+				typ = nodePhrase
+			}
+			or.children = append(or.children, &queryNode{
+				typ: typ, pos: node.pos, text: synonym, inTitle: node.inTitle, synonym: true})
+		}
+		return or
+	case nodeAnd, nodeOr, nodeNot:
+		for i, child := range node.children {
+			node.children[i] = d.expand(child)
+		}
+	}
+	return node
+}
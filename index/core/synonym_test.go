@@ -0,0 +1,53 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSynonymDict_Expand(t *testing.T) {
+	s := newTestSearcher(t, rankingBM25F, map[string][2]string{
+		"http://a.com/golang": {"golang 入门", "golang 教程"},
+		"http://a.com/go":     {"go 语言入门", "go 语言教程"},
+		"http://a.com/k8s":    {"Kubernetes", "容器编排"},
+		"http://a.com/other":  {"其他", "入门"},
+	})
+	dict := newSynonymDict(map[string][]string{
+		"Golang": {"Go 语言"},
+		"k8s":    {"kubernetes"},
+	})
+	search := func(query string) []string {
+		node, err := parseQuery(query)
+		if err != nil {
+			t.Fatal(query, err)
+		}
+		r, err := s.search(dict.expand(node), 10, SortByScore)
+		if err != nil {
+			t.Fatal(query, err)
+		}
+		r.sortResults()
+		var urls []string
+		for _, item := range r.Items {
+			urls = append(urls, s.db.GetDocumentUrl(item.docId))
+		}
+		return urls
+	}
+	// 原词排在同义词前面
+	if got := strings.Join(search("golang 入门"), " "); got != "http://a.com/golang http://a.com/go" {
+		t.Error(got)
+	}
+	// 反方向也是同义词
+	if got := strings.Join(search(`"go 语言"`), " "); got != "http://a.com/go http://a.com/golang" {
+		t.Error(got)
+	}
+	if got := strings.Join(search("K8S"), " "); got != "http://a.com/k8s" {
+		t.Error(got)
+	}
+	// 排除条件也扩展
+	got := search("入门 -golang")
+	sort.Strings(got)
+	if strings.Join(got, " ") != "http://a.com/other" {
+		t.Error(got)
+	}
+}
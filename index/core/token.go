@@ -43,3 +43,20 @@ func nGramSplit(str string, n int, consumer func(token string, pos int) error) {
 		}
 	}
 }
+
+// 输出长度不足 n 的片段，用于 n-gram 切分不出词元的短查询，如单字查询
+func shortFragmentSplit(str string, n int, consumer func(token string, pos int) error) {
+	left := 0
+	chars := []rune(str)
+	for i := 0; i <= len(chars); i++ {
+		if i < len(chars) && !isIgnoredChar(chars[i]) {
+			continue
+		}
+		if i > left && i-left < n {
+			if err := consumer(string(chars[left:i]), left); err != nil {
+				log.Println(err.Error())
+			}
+		}
+		left = i + 1
+	}
+}
@@ -0,0 +1,157 @@
+// 锚文本：其他页面链接到某个页面时使用的文字
+package db
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"sort"
+	"strings"
+)
+
+var (
+	// 目标 URL -> 链接到它的页面及锚文本，每行为 "来源 URL\t锚文本"
+	BucketAnchorText = []byte("anchor_text")
+	// 锚文本有变化、需要重新建索引的目标 URL
+	BucketAnchorDirty = []byte("anchor_dirty")
+	// 文档 ID -> 建索引时使用的锚文本，用于计算高亮
+	BucketDocAnchor = []byte("doc_anchor")
+)
+
+// 每个页面最多保存的来源数量，超过后忽略新的来源
+const maxAnchorSources = 50
+
+// 记录 source 页面中链接到各个页面的锚文本（目标 URL -> 锚文本），替换该页面以前的锚文本，
+// 并把锚文本有变化的目标标记为需要重新建索引
+func (db *IndexDB) AddAnchors(source string, anchors map[string]string) error {
+	return db.docDB.Update(func(tx *bolt.Tx) error {
+		for target, text := range anchors {
+			if err := putAnchor(tx, source, target, text); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 合并其他索引服务器迁移过来的锚文本，sources 为来源 URL -> 锚文本
+func (db *IndexDB) MergeAnchors(target string, sources map[string]string) error {
+	return db.docDB.Update(func(tx *bolt.Tx) error {
+		for source, text := range sources {
+			if err := putAnchor(tx, source, target, text); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func putAnchor(tx *bolt.Tx, source, target, text string) error {
+	bucketText := tx.Bucket(BucketAnchorText)
+	text = strings.Join(strings.Fields(text), " ")
+	if target == source || text == "" {
+		return nil
+	}
+	sources := decodeAnchors(bucketText.Get([]byte(target)))
+	if old, ok := sources[source]; ok && old == text || !ok && len(sources) >= maxAnchorSources {
+		return nil
+	}
+	sources[source] = text
+	if err := bucketText.Put([]byte(target), encodeAnchors(sources)); err != nil {
+		return err
+	}
+	return tx.Bucket(BucketAnchorDirty).Put([]byte(target), []byte{})
+}
+
+func decodeAnchors(data []byte) map[string]string {
+	sources := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if item := strings.SplitN(line, "\t", 2); len(item) == 2 {
+			sources[item[0]] = item[1]
+		}
+	}
+	return sources
+}
+
+// 按来源 URL 排序，这样同样的锚文本编码后相同
+func encodeAnchors(sources map[string]string) []byte {
+	lines := make([]string, 0, len(sources))
+	for source, text := range sources {
+		lines = append(lines, source+"\t"+text)
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// 链接到 url 的所有锚文本，每个来源一行
+func (db *IndexDB) GetAnchorText(url string) string {
+	var data []byte
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		data = append(data, tx.Bucket(BucketAnchorText).Get([]byte(url))...)
+		return nil
+	})
+	var texts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if item := strings.SplitN(line, "\t", 2); len(item) == 2 {
+			texts = append(texts, item[1])
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// 链接到 url 的页面及锚文本，来源 URL -> 锚文本
+func (db *IndexDB) GetAnchorSources(url string) map[string]string {
+	var sources map[string]string
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		sources = decodeAnchors(tx.Bucket(BucketAnchorText).Get([]byte(url)))
+		return nil
+	})
+	return sources
+}
+
+// 删除链接到 url 的锚文本，url 迁移到其他索引服务器后调用
+func (db *IndexDB) DeleteAnchors(url string) error {
+	return db.docDB.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(BucketAnchorText).Delete([]byte(url)); err != nil {
+			return err
+		}
+		return tx.Bucket(BucketAnchorDirty).Delete([]byte(url))
+	})
+}
+
+// 取出最多 limit 个需要重新建索引的目标 URL，同时去掉它们的标记
+func (db *IndexDB) TakeDirtyAnchorTargets(limit int) ([]string, error) {
+	var urls []string
+	err := db.docDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BucketAnchorDirty)
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && len(urls) < limit; k, _ = c.First() {
+			urls = append(urls, string(k))
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return urls, err
+}
+
+// 保存文档建索引时使用的锚文本，url 的锚文本已经是最新的，去掉重新建索引的标记
+func (db *IndexDB) SetDocumentAnchor(url string, docId int, text string) error {
+	return db.docDB.Update(func(tx *bolt.Tx) error {
+		if text != "" {
+			if err := tx.Bucket(BucketDocAnchor).Put([]byte(fmt.Sprint(docId)), []byte(text)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(BucketAnchorDirty).Delete([]byte(url))
+	})
+}
+
+func (db *IndexDB) GetDocumentAnchor(docId int) string {
+	var text string
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		text = string(tx.Bucket(BucketDocAnchor).Get([]byte(fmt.Sprint(docId))))
+		return nil
+	})
+	return text
+}
@@ -0,0 +1,130 @@
+// 备份和恢复：快照是一个 tar 文件，包含索引库和文档库两个 bolt 文件
+package db
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 快照中的文件名
+const (
+	snapshotIndexFile = "index.db"
+	snapshotDocFile   = "doc.db"
+)
+
+// 把两个数据库的一致快照以 tar 格式写入 w，期间不影响读写。
+// 先开始索引库的事务，快照中倒排列表引用的文档一定在文档库中。内存中还没写入磁盘的索引不在快照中，和进程崩溃时一样
+func (db *IndexDB) WriteSnapshot(w io.Writer) error {
+	indexTx, err := db.indexDB.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = indexTx.Rollback()
+	}()
+	docTx, err := db.docDB.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = docTx.Rollback()
+	}()
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	for _, f := range []struct {
+		name string
+		tx   *bolt.Tx
+	}{{snapshotIndexFile, indexTx}, {snapshotDocFile, docTx}} {
+		header := &tar.Header{Name: f.name, Mode: 0600, Size: f.tx.Size(), ModTime: now}
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err = f.tx.WriteTo(tw); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// 从快照恢复两个数据库，必须在打开数据库之前调用。快照先写到临时文件并检查能否打开，
+// 都成功后才替换原来的数据库，原来的文件改名为 xxx.before-restore
+func RestoreSnapshot(r io.Reader, indexPath, docPath string) error {
+	paths := map[string]string{snapshotIndexFile: indexPath, snapshotDocFile: docPath}
+	restored := make(map[string]string) // 数据库路径 -> 临时文件
+	defer func() {
+		for _, tmp := range restored {
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		path, ok := paths[header.Name]
+		if !ok {
+			continue
+		}
+		tmp := path + ".restoring"
+		restored[path] = tmp
+		if err = writeFile(tmp, tr); err != nil {
+			return err
+		}
+		if err = checkBoltFile(tmp); err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+	}
+	if len(restored) != len(paths) {
+		return errors.New("快照不完整")
+	}
+
+	for path, tmp := range restored {
+		if _, err := os.Stat(path); err == nil {
+			if err = os.Rename(path, path+".before-restore"); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		delete(restored, path)
+	}
+	return nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, r); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 检查文件是不是完整的 bolt 数据库
+func checkBoltFile(path string) error {
+	b, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	return b.Close()
+}
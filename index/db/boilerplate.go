@@ -0,0 +1,41 @@
+// 模板文字：导航、页脚等正文以外的文字，和正文分开保存
+package db
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"strconv"
+)
+
+// 文档 ID -> 模板文字
+var BucketDocBoilerplate = []byte("doc_boilerplate")
+
+func (db *IndexDB) SetDocumentBoilerplate(docId int, text string) error {
+	if text == "" {
+		return nil
+	}
+	return db.docDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(BucketDocBoilerplate).Put([]byte(fmt.Sprint(docId)), []byte(text))
+	})
+}
+
+func (db *IndexDB) GetDocumentBoilerplate(docId int) string {
+	var text string
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		text = string(tx.Bucket(BucketDocBoilerplate).Get([]byte(fmt.Sprint(docId))))
+		return nil
+	})
+	return text
+}
+
+// URL 对应的未删除文档的 ID，不存在时返回 -1
+func (db *IndexDB) GetDocumentId(url string) int {
+	docId := -1
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(BucketUrlDoc).Get([]byte(url)); value != nil {
+			docId, _ = strconv.Atoi(string(value))
+		}
+		return nil
+	})
+	return docId
+}
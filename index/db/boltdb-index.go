@@ -6,6 +6,7 @@ import (
 	"github.com/boltdb/bolt"
 	"log"
 	"search-engine/index/util"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,8 +15,22 @@ import (
 var (
 	BucketDocUrl        = []byte("doc_url")
 	BucketDocDetail     = []byte("doc_detail")
+	// 旧版本保存所有倒排列表的 bucket，现在作为一个段，见 segment.go
 	BucketTokenPostings = []byte("token_postings")
 	BucketTokenDocCount = []byte("token_doc_count")
+	// 文档各字段（标题、正文）的长度，即词元数量
+	BucketDocLength = []byte("doc_length")
+	// 文档集合的统计信息
+	BucketDocStats = []byte("doc_stats")
+	// 索引的元信息，如建立索引使用的分析器
+	BucketIndexMeta = []byte("index_meta")
+	// URL -> 文档 ID，同一个 URL 只保留最新的文档
+	BucketUrlDoc = []byte("url_doc")
+	// 已删除（被替换）的文档 ID，检索时过滤，后台任务会清理倒排列表
+	BucketDocTombstone = []byte("doc_tombstone")
+
+	// 有长度信息的文档数量、各字段长度之和
+	keyFieldLengthSum = []byte("field_length_sum")
 )
 
 type IndexDB struct {
@@ -30,6 +45,24 @@ type IndexDB struct {
 	}
 	TokenDocsCountBuffer *util.Buffer
 	DocUrlBuffer         *util.Buffer
+	DocLengthBuffer      *util.Buffer
+	// 各字段的平均长度
+	avgFieldLengthBuffer struct {
+		avg      []float64
+		birthday int64
+		sync.Mutex
+	}
+	// 已删除的文档，启动时从 BucketDocTombstone 加载
+	tombstones struct {
+		docs map[int]struct{}
+		sync.RWMutex
+	}
+	// 文档 ID -> PageRank，启动时由 BucketDocRank 和 BucketUrlDoc 建立，见 link.go
+	pageRanks struct {
+		docs map[int]float64
+		max  float64 // PageRank 的上界，删除文档时不减小
+		sync.RWMutex
+	}
 }
 
 type IndexDBOptions struct {
@@ -54,11 +87,24 @@ func NewIndexDB(options *IndexDBOptions) *IndexDB {
 
 	// 创建 Bucket
 	err = docDB.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists(BucketDocUrl); err != nil {
+		for _, name := range [][]byte{BucketDocUrl, BucketDocLength, BucketDocStats, BucketDocTombstone,
+			BucketDocLinks, BucketDocRank, BucketAnchorText, BucketAnchorDirty, BucketDocAnchor,
+			BucketDocBoilerplate} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if _, err = tx.CreateBucketIfNotExists(BucketDocDetail); err != nil {
 			return err
 		}
-		_, err = tx.CreateBucketIfNotExists(BucketDocDetail)
-		return err
+		// 旧版本的文档库没有 URL -> 文档 ID 的映射，需要建立
+		if tx.Bucket(BucketUrlDoc) == nil {
+			if _, err = tx.CreateBucket(BucketUrlDoc); err != nil {
+				return err
+			}
+			return buildUrlDocMapping(tx)
+		}
+		return nil
 	})
 	if err != nil {
 		_ = docDB.Close()
@@ -66,11 +112,12 @@ func NewIndexDB(options *IndexDBOptions) *IndexDB {
 		log.Fatalln(err.Error())
 	}
 	err = indexDB.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists(BucketTokenDocCount); err != nil {
-			return err
+		for _, name := range [][]byte{BucketTokenDocCount, BucketIndexMeta, BucketSegments} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
 		}
-		_, err := tx.CreateBucketIfNotExists(BucketTokenPostings)
-		return err
+		return registerLegacyPostings(tx)
 	})
 	if err != nil {
 		_ = docDB.Close()
@@ -79,17 +126,23 @@ func NewIndexDB(options *IndexDBOptions) *IndexDB {
 	}
 
 	// Buffer
+	// 各个段中的倒排列表
 	postingsBuffer := util.NewBuffer(options.PostingsBufferSize, 60, func(key interface{}) interface{} {
-		var value []byte
+		var values []SegmentPostings
 		_ = indexDB.View(func(tx *bolt.Tx) error {
-			bucket := tx.Bucket(BucketTokenPostings)
-			ret := bucket.Get([]byte(key.(string)))
-			// ret 仅在事务期间有效
-			value = make([]byte, len(ret))
-			copy(value, ret)
+			for _, segment := range ListSegments(tx) {
+				ret := tx.Bucket([]byte(segment.Name)).Get([]byte(key.(string)))
+				if ret == nil {
+					continue
+				}
+				// ret 仅在事务期间有效
+				value := make([]byte, len(ret))
+				copy(value, ret)
+				values = append(values, SegmentPostings{Data: value, Format: segment.Format})
+			}
 			return nil
 		})
-		return value
+		return values
 	})
 	docUrlBuffer := util.NewBuffer(options.DocUrlBufferSize, 60, func(key interface{}) interface{} {
 		var value string
@@ -101,6 +154,14 @@ func NewIndexDB(options *IndexDBOptions) *IndexDB {
 		})
 		return value
 	})
+	docLengthBuffer := util.NewBuffer(options.DocUrlBufferSize, 60, func(key interface{}) interface{} {
+		var lengths []int
+		_ = docDB.View(func(tx *bolt.Tx) error {
+			lengths = decodeVarInts(tx.Bucket(BucketDocLength).Get([]byte(fmt.Sprint(key))))
+			return nil
+		})
+		return lengths
+	})
 	tokenDocsCountBuffer := util.NewBuffer(options.TokenDocsCountBufferSize, 60, func(key interface{}) interface{} {
 		var count int64
 		_ = indexDB.View(func(tx *bolt.Tx) error {
@@ -112,23 +173,71 @@ func NewIndexDB(options *IndexDBOptions) *IndexDB {
 		return int(count)
 	})
 
-	return &IndexDB{
+	db := &IndexDB{
 		docDB:                docDB,
 		indexDB:              indexDB,
 		PostingsBuffer:       postingsBuffer,
 		DocUrlBuffer:         docUrlBuffer,
+		DocLengthBuffer:      docLengthBuffer,
 		TokenDocsCountBuffer: tokenDocsCountBuffer,
 	}
+	db.tombstones.docs = make(map[int]struct{})
+	_ = docDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(BucketDocTombstone).ForEach(func(k, v []byte) error {
+			docId, _ := strconv.Atoi(string(k))
+			db.tombstones.docs[docId] = struct{}{}
+			return nil
+		})
+	})
+	db.loadPageRanks()
+	return db
+}
+
+// 根据 doc_url 建立 URL -> 文档 ID 的映射，同一个 URL 有多个文档时只保留最新的，其余的标记删除
+func buildUrlDocMapping(tx *bolt.Tx) error {
+	latest := make(map[string]int)
+	var duplicates []int
+	err := tx.Bucket(BucketDocUrl).ForEach(func(k, v []byte) error {
+		docId, _ := strconv.Atoi(string(k))
+		url := string(v)
+		if old, ok := latest[url]; ok {
+			// key 是字符串，不是按文档 ID 的大小遍历的
+			if old > docId {
+				old, docId = docId, old
+			}
+			duplicates = append(duplicates, old)
+		}
+		latest[url] = docId
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, docId := range duplicates {
+		if err = tombstone(tx, docId); err != nil {
+			return err
+		}
+	}
+	bucketUrlDoc := tx.Bucket(BucketUrlDoc)
+	for url, docId := range latest {
+		if err = bucketUrlDoc.Put([]byte(url), []byte(fmt.Sprint(docId))); err != nil {
+			return err
+		}
+	}
+	if len(duplicates) > 0 {
+		log.Printf("建立 URL 映射，%d 个重复的文档已标记删除\n", len(duplicates))
+	}
+	return nil
 }
 
 // 构建索引用
-func (db *IndexDB) UpdatePostings(fn func(tx *bolt.Tx) error) {
-	_ = db.indexDB.Update(fn)
+func (db *IndexDB) UpdatePostings(fn func(tx *bolt.Tx) error) error {
+	return db.indexDB.Update(fn)
 }
 
-// 检索使用
-func (db *IndexDB) FetchPostings(token string) []byte {
-	return db.PostingsBuffer.Get(token).([]byte)
+// 检索使用，返回各个段中 token 的倒排列表
+func (db *IndexDB) FetchPostings(token string) []SegmentPostings {
+	return db.PostingsBuffer.Get(token).([]SegmentPostings)
 }
 
 // 获取包含 token 的文档数量
@@ -161,20 +270,44 @@ func (db *IndexDB) GetDocumentsCount() int {
 	return count
 }
 
+// 最大的文档 ID（包括已删除的文档），没有文档时返回 0
+func (db *IndexDB) GetMaxDocumentId() int {
+	var seq uint64
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		seq = tx.Bucket(BucketDocDetail).Sequence()
+		return nil
+	})
+	return int(seq)
+}
+
 // 根据文档 ID 获取文档的 URL
 func (db *IndexDB) GetDocumentUrl(docId int) string {
 	return db.DocUrlBuffer.Get(docId).(string)
 }
 
-func (db *IndexDB) AddDocument(url, title, body string) (int, error) {
+// 添加文档，URL 已经存在时替换旧文档：旧文档标记删除，新文档使用新的 ID，
+// 这样倒排列表仍然按文档 ID 有序。fieldLengths 是各字段的长度，为 nil 时不保存。
+// 删除旧文档（减去它的长度）和累加新文档的长度在同一个事务中
+func (db *IndexDB) AddDocument(url, title, body string, fieldLengths []int) (int, error) {
 	var docId uint64
+	oldDocId := -1
 	err := db.docDB.Update(func(tx *bolt.Tx) error {
 		bucketUrl := tx.Bucket(BucketDocUrl)
 		bucketDetail := tx.Bucket(BucketDocDetail)
+		bucketUrlDoc := tx.Bucket(BucketUrlDoc)
+		if old := bucketUrlDoc.Get([]byte(url)); old != nil {
+			oldDocId, _ = strconv.Atoi(string(old))
+			if err := tombstone(tx, oldDocId); err != nil {
+				return err
+			}
+		}
 		docId, _ = bucketDetail.NextSequence()
 		if err := bucketUrl.Put([]byte(fmt.Sprint(docId)), []byte(url)); err != nil {
 			return err
 		}
+		if err := bucketUrlDoc.Put([]byte(url), []byte(fmt.Sprint(docId))); err != nil {
+			return err
+		}
 
 		t := util.EncodeVarInt(make([]byte, binary.MaxVarintLen64), int64(len(title))) // title长度的字节数组
 		data := make([]byte, len(t)+len(title)+len(body))
@@ -184,11 +317,148 @@ func (db *IndexDB) AddDocument(url, title, body string) (int, error) {
 		if err := bucketDetail.Put([]byte(fmt.Sprint(docId)), data); err != nil {
 			return err
 		}
-		return nil
+		if fieldLengths == nil {
+			return nil
+		}
+		return addDocumentLength(tx, int(docId), fieldLengths)
 	})
+	if err == nil && oldDocId >= 0 {
+		db.markDeleted(oldDocId)
+	}
+	if err == nil {
+		db.inheritPageRank(url, int(docId))
+	}
 	return int(docId), err
 }
 
+// 删除 URL 对应的文档，文档不存在时返回 false
+func (db *IndexDB) DeleteDocument(url string) (bool, error) {
+	docId := -1
+	err := db.docDB.Update(func(tx *bolt.Tx) error {
+		bucketUrlDoc := tx.Bucket(BucketUrlDoc)
+		value := bucketUrlDoc.Get([]byte(url))
+		if value == nil {
+			return nil
+		}
+		docId, _ = strconv.Atoi(string(value))
+		if err := bucketUrlDoc.Delete([]byte(url)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(BucketDocLinks).Delete([]byte(url)); err != nil {
+			return err
+		}
+		return tombstone(tx, docId)
+	})
+	if err != nil || docId < 0 {
+		return false, err
+	}
+	db.markDeleted(docId)
+	return true, nil
+}
+
+// 在事务中将文档标记删除：删除文档内容、URL、长度信息，并从字段长度之和中减去该文档的长度。
+// 倒排列表中的文档 ID 由后台任务清理
+func tombstone(tx *bolt.Tx, docId int) error {
+	key := []byte(fmt.Sprint(docId))
+	if err := tx.Bucket(BucketDocTombstone).Put(key, []byte{}); err != nil {
+		return err
+	}
+	bucketLength := tx.Bucket(BucketDocLength)
+	if lengths := decodeVarInts(bucketLength.Get(key)); lengths != nil {
+		bucketStats := tx.Bucket(BucketDocStats)
+		sum := decodeVarInts(bucketStats.Get(keyFieldLengthSum))
+		if len(sum) >= len(lengths)+1 {
+			sum[0]--
+			for i, l := range lengths {
+				sum[i+1] -= l
+			}
+			if err := bucketStats.Put(keyFieldLengthSum, encodeVarInts(sum)); err != nil {
+				return err
+			}
+		}
+		if err := bucketLength.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(BucketDocUrl).Delete(key); err != nil {
+		return err
+	}
+	if err := tx.Bucket(BucketDocAnchor).Delete(key); err != nil {
+		return err
+	}
+	if err := tx.Bucket(BucketDocBoilerplate).Delete(key); err != nil {
+		return err
+	}
+	return tx.Bucket(BucketDocDetail).Delete(key)
+}
+
+func (db *IndexDB) markDeleted(docId int) {
+	db.tombstones.Lock()
+	db.tombstones.docs[docId] = struct{}{}
+	db.tombstones.Unlock()
+	db.DocUrlBuffer.Del(docId)
+	db.DocLengthBuffer.Del(docId)
+	db.pageRanks.Lock()
+	delete(db.pageRanks.docs, docId)
+	db.pageRanks.Unlock()
+}
+
+// 文档是否已被删除，检索时过滤
+func (db *IndexDB) IsDeleted(docId int) bool {
+	db.tombstones.RLock()
+	defer db.tombstones.RUnlock()
+	_, ok := db.tombstones.docs[docId]
+	return ok
+}
+
+// 已删除的文档集合的快照
+func (db *IndexDB) Tombstones() map[int]struct{} {
+	db.tombstones.RLock()
+	defer db.tombstones.RUnlock()
+	docs := make(map[int]struct{}, len(db.tombstones.docs))
+	for docId := range db.tombstones.docs {
+		docs[docId] = struct{}{}
+	}
+	return docs
+}
+
+// 倒排列表中已经没有这些文档了，删除它们的标记
+func (db *IndexDB) RemoveTombstones(docIds []int) error {
+	err := db.docDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BucketDocTombstone)
+		for _, docId := range docIds {
+			if err := bucket.Delete([]byte(fmt.Sprint(docId))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	db.tombstones.Lock()
+	for _, docId := range docIds {
+		delete(db.tombstones.docs, docId)
+	}
+	db.tombstones.Unlock()
+	return nil
+}
+
+// 遍历所有文档（包括已删除的）的标题，用于搜索建议
+func (db *IndexDB) ForEachDocumentTitle(fn func(docId int, title string)) {
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(BucketDocDetail).ForEach(func(k, v []byte) error {
+			docId, err := strconv.Atoi(string(k))
+			titleLen, length := binary.Varint(v)
+			if err != nil || length <= 0 || int(titleLen) > len(v)-length {
+				return nil
+			}
+			fn(docId, string(v[length:length+int(titleLen)]))
+			return nil
+		})
+	})
+}
+
 func (db *IndexDB) GetDocument(docId int) (string, string, string) {
 	var url, title, body string
 	_ = db.docDB.View(func(tx *bolt.Tx) error {
@@ -207,6 +477,106 @@ func (db *IndexDB) GetDocument(docId int) (string, string, string) {
 	return url, title, body
 }
 
+func encodeVarInts(values []int) []byte {
+	buf := make([]byte, 0, len(values)*2)
+	tmp := make([]byte, binary.MaxVarintLen64)
+	for _, v := range values {
+		length := binary.PutVarint(tmp, int64(v))
+		buf = append(buf, tmp[:length]...)
+	}
+	return buf
+}
+
+func decodeVarInts(data []byte) []int {
+	var values []int
+	for pos := 0; pos < len(data); {
+		x, length := binary.Varint(data[pos:])
+		if length <= 0 {
+			break
+		}
+		pos += length
+		values = append(values, int(x))
+	}
+	return values
+}
+
+// 保存文档各字段的长度，同时累加到文档集合的字段长度之和中，用于计算平均长度
+func addDocumentLength(tx *bolt.Tx, docId int, fieldLengths []int) error {
+	if err := tx.Bucket(BucketDocLength).Put([]byte(fmt.Sprint(docId)), encodeVarInts(fieldLengths)); err != nil {
+		return err
+	}
+	// [文档数量, 字段0长度之和, 字段1长度之和, ...]
+	bucketStats := tx.Bucket(BucketDocStats)
+	sum := decodeVarInts(bucketStats.Get(keyFieldLengthSum))
+	for len(sum) < len(fieldLengths)+1 {
+		sum = append(sum, 0)
+	}
+	sum[0]++
+	for i, l := range fieldLengths {
+		sum[i+1] += l
+	}
+	return bucketStats.Put(keyFieldLengthSum, encodeVarInts(sum))
+}
+
+// 获取文档各字段的长度，旧版本建立的索引没有长度信息，返回 nil
+func (db *IndexDB) GetDocumentLength(docId int) []int {
+	return db.DocLengthBuffer.Get(docId).([]int)
+}
+
+// 获取各字段的平均长度，没有长度信息时返回 nil
+func (db *IndexDB) GetAverageFieldLength() []float64 {
+	buf := &db.avgFieldLengthBuffer
+	buf.Lock()
+	defer buf.Unlock()
+	// 5 秒有效期
+	if time.Now().Unix()-buf.birthday < 5 {
+		return buf.avg
+	}
+
+	var sum []int
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		sum = decodeVarInts(tx.Bucket(BucketDocStats).Get(keyFieldLengthSum))
+		return nil
+	})
+	var avg []float64
+	if len(sum) > 1 && sum[0] > 0 {
+		avg = make([]float64, len(sum)-1)
+		for i := range avg {
+			avg[i] = float64(sum[i+1]) / float64(sum[0])
+		}
+	}
+	buf.avg = avg
+	buf.birthday = time.Now().Unix()
+	return avg
+}
+
+// 获取索引的元信息，不存在时返回空字符串
+func (db *IndexDB) GetIndexMeta(key string) string {
+	var value string
+	_ = db.indexDB.View(func(tx *bolt.Tx) error {
+		value = string(tx.Bucket(BucketIndexMeta).Get([]byte(key)))
+		return nil
+	})
+	return value
+}
+
+func (db *IndexDB) SetIndexMeta(key, value string) error {
+	return db.indexDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(BucketIndexMeta).Put([]byte(key), []byte(value))
+	})
+}
+
+// 遍历词表：词元及包含它的文档数量，用于拼写纠错
+func (db *IndexDB) ForEachTokenDocCount(fn func(token string, count int)) {
+	_ = db.indexDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(BucketTokenDocCount).ForEach(func(k, v []byte) error {
+			count, _ := binary.Varint(v)
+			fn(string(k), int(count))
+			return nil
+		})
+	})
+}
+
 // 统计用
 func (db *IndexDB) GetTokenCount() int {
 	var count int
@@ -0,0 +1,151 @@
+// 链接图和 PageRank
+package db
+
+import (
+	"encoding/binary"
+	"github.com/boltdb/bolt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	// URL -> 页面中的出链（换行分隔），由爬虫随文档一起发送
+	BucketDocLinks = []byte("doc_links")
+	// URL -> PageRank，按 URL 保存，文档被替换（ID 改变）后仍然有效
+	BucketDocRank = []byte("doc_rank")
+)
+
+// 保存页面的出链，替换原有的出链。没有出链的页面也要保存，它也是链接图中的节点
+func (db *IndexDB) SetDocumentLinks(url string, links []string) error {
+	return db.docDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(BucketDocLinks).Put([]byte(url), []byte(strings.Join(links, "\n")))
+	})
+}
+
+// 页面的出链，没有保存时返回 false
+func (db *IndexDB) GetDocumentLinks(url string) ([]string, bool) {
+	var links []string
+	ok := false
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(BucketDocLinks).Get([]byte(url)); v != nil {
+			ok = true
+			if len(v) > 0 {
+				links = strings.Split(string(v), "\n")
+			}
+		}
+		return nil
+	})
+	return links, ok
+}
+
+// 遍历所有页面的出链
+func (db *IndexDB) ForEachDocumentLinks(fn func(url string, links []string)) {
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(BucketDocLinks).ForEach(func(k, v []byte) error {
+			var links []string
+			if len(v) > 0 {
+				links = strings.Split(string(v), "\n")
+			}
+			fn(string(k), links)
+			return nil
+		})
+	})
+}
+
+func encodeFloat(f float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(f))
+	return b
+}
+
+func decodeFloat(b []byte) float64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
+
+// 用新计算的结果替换所有文档的 PageRank，只保存本地有文档的 URL
+func (db *IndexDB) SetPageRanks(ranks map[string]float64) error {
+	docs := make(map[int]float64)
+	err := db.docDB.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(BucketDocRank); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(BucketDocRank)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(BucketUrlDoc).ForEach(func(k, v []byte) error {
+			rank, ok := ranks[string(k)]
+			if !ok {
+				return nil
+			}
+			docId, _ := strconv.Atoi(string(v))
+			docs[docId] = rank
+			return bucket.Put(k, encodeFloat(rank))
+		})
+	})
+	if err != nil {
+		return err
+	}
+	db.pageRanks.Lock()
+	db.pageRanks.docs, db.pageRanks.max = docs, maxPageRank(docs)
+	db.pageRanks.Unlock()
+	return nil
+}
+
+// 文档的 PageRank，平均值为 1，还没有计算过时返回 0
+func (db *IndexDB) GetPageRank(docId int) float64 {
+	db.pageRanks.RLock()
+	defer db.pageRanks.RUnlock()
+	return db.pageRanks.docs[docId]
+}
+
+func (db *IndexDB) loadPageRanks() {
+	docs := make(map[int]float64)
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		bucketRank := tx.Bucket(BucketDocRank)
+		return tx.Bucket(BucketUrlDoc).ForEach(func(k, v []byte) error {
+			if rank := decodeFloat(bucketRank.Get(k)); rank > 0 {
+				docId, _ := strconv.Atoi(string(v))
+				docs[docId] = rank
+			}
+			return nil
+		})
+	})
+	db.pageRanks.Lock()
+	db.pageRanks.docs, db.pageRanks.max = docs, maxPageRank(docs)
+	db.pageRanks.Unlock()
+}
+
+func maxPageRank(docs map[int]float64) float64 {
+	var max float64
+	for _, rank := range docs {
+		max = math.Max(max, rank)
+	}
+	return max
+}
+
+// 所有文档的 PageRank 的上界，用于计算分数的上界，还没有计算过时返回 0
+func (db *IndexDB) GetMaxPageRank() float64 {
+	db.pageRanks.RLock()
+	defer db.pageRanks.RUnlock()
+	return db.pageRanks.max
+}
+
+// 重新抓取的文档使用新的 ID，沿用 URL 原有的 PageRank
+func (db *IndexDB) inheritPageRank(url string, docId int) {
+	var rank float64
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		rank = decodeFloat(tx.Bucket(BucketDocRank).Get([]byte(url)))
+		return nil
+	})
+	if rank > 0 {
+		db.pageRanks.Lock()
+		db.pageRanks.docs[docId] = rank
+		db.pageRanks.max = math.Max(db.pageRanks.max, rank)
+		db.pageRanks.Unlock()
+	}
+}
@@ -5,7 +5,17 @@ import (
 	"search-engine/index/config"
 )
 
-var Redis = NewRedis()
+// 分布式模式下使用，嵌入模式下为 nil
+var Redis *redis.Client
+
+func init() {
+	if config.Embedded() {
+		Registry = NewMemoryRegistry()
+		return
+	}
+	Redis = NewRedis()
+	Registry = NewRedisRegistry(Redis)
+}
 
 func NewRedis() *redis.Client {
 	rdb := redis.NewClient(&redis.Options{
@@ -0,0 +1,107 @@
+// 服务注册中心，各服务定期报告自己的存活状态
+package db
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 全局的注册中心，嵌入模式下为进程内的实现，all-in-one 会替换成各服务共享的实例
+var Registry ServiceRegistry
+
+type ServiceRegistry interface {
+	// 注册服务地址，同时也是心跳
+	Register(service, addr string) error
+	// 移除服务地址
+	Deregister(service, addr string) error
+	// 获取服务的所有地址，addr->最近一次心跳的时间戳
+	List(service string) (map[string]int64, error)
+}
+
+// 使用 redis hash 保存服务地址，key 为 service，field 为 addr，value 为心跳时间戳
+type redisRegistry struct {
+	redis *redis.Client
+}
+
+func NewRedisRegistry(client *redis.Client) ServiceRegistry {
+	return &redisRegistry{redis: client}
+}
+
+func (r *redisRegistry) Register(service, addr string) error {
+	return r.redis.HSet(context.Background(), service, addr, time.Now().Unix()).Err()
+}
+
+func (r *redisRegistry) Deregister(service, addr string) error {
+	return r.redis.HDel(context.Background(), service, addr).Err()
+}
+
+func (r *redisRegistry) List(service string) (map[string]int64, error) {
+	m, err := r.redis.HGetAll(context.Background(), service).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]int64, len(m))
+	for addr, heartbeatTime := range m {
+		t, _ := strconv.ParseInt(heartbeatTime, 10, 64)
+		ret[addr] = t
+	}
+	return ret, nil
+}
+
+// 进程内的注册中心，可以添加静态地址，静态地址总是被认为存活
+type MemoryRegistry struct {
+	services map[string]map[string]int64
+	static   map[string]map[string]struct{}
+	lock     sync.Mutex
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		services: make(map[string]map[string]int64),
+		static:   make(map[string]map[string]struct{}),
+	}
+}
+
+// 添加静态地址，用于嵌入模式下多个进程分开部署的情况
+func (m *MemoryRegistry) AddStatic(service, addr string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.static[service] == nil {
+		m.static[service] = make(map[string]struct{})
+	}
+	m.static[service][addr] = struct{}{}
+}
+
+func (m *MemoryRegistry) Register(service, addr string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.services[service] == nil {
+		m.services[service] = make(map[string]int64)
+	}
+	m.services[service][addr] = time.Now().Unix()
+	return nil
+}
+
+func (m *MemoryRegistry) Deregister(service, addr string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.services[service], addr)
+	return nil
+}
+
+func (m *MemoryRegistry) List(service string) (map[string]int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ret := make(map[string]int64, len(m.services[service])+len(m.static[service]))
+	for addr, t := range m.services[service] {
+		ret[addr] = t
+	}
+	now := time.Now().Unix()
+	for addr := range m.static[service] {
+		ret[addr] = now
+	}
+	return ret, nil
+}
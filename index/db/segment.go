@@ -0,0 +1,99 @@
+// 倒排索引的段：每次刷新都写入一个新的段（bucket），段写入后不再修改，
+// 检索时合并所有段中的倒排列表，后台任务按大小分层合并段
+package db
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// 段的清单，段名 -> [大小, 最小文档 ID, 最大文档 ID, 倒排列表的格式]
+	BucketSegments = []byte("segments")
+)
+
+// 段名的前缀，旧版本的 token_postings 作为一个段
+const segmentPrefix = "segment_"
+
+type SegmentInfo struct {
+	Name     string
+	Size     int // 倒排列表的字节数
+	MinDocId int
+	MaxDocId int
+	Format   int // 倒排列表的编码格式，旧版本的段没有记录，为 0
+}
+
+// 一个段中某个词元的倒排列表
+type SegmentPostings struct {
+	Data   []byte
+	Format int
+}
+
+// 列出所有段
+func ListSegments(tx *bolt.Tx) []SegmentInfo {
+	var segments []SegmentInfo
+	_ = tx.Bucket(BucketSegments).ForEach(func(k, v []byte) error {
+		values := decodeVarInts(v)
+		if len(values) < 3 {
+			return nil
+		}
+		info := SegmentInfo{Name: string(k), Size: values[0], MinDocId: values[1], MaxDocId: values[2]}
+		if len(values) > 3 {
+			info.Format = values[3]
+		}
+		segments = append(segments, info)
+		return nil
+	})
+	return segments
+}
+
+// 创建一个新的段，写完倒排列表后要调用 PutSegmentInfo 登记到清单中，否则检索不到
+func NewSegment(tx *bolt.Tx) (*bolt.Bucket, string, error) {
+	seq, err := tx.Bucket(BucketSegments).NextSequence()
+	if err != nil {
+		return nil, "", err
+	}
+	name := fmt.Sprintf("%s%010d", segmentPrefix, seq)
+	bucket, err := tx.CreateBucket([]byte(name))
+	return bucket, name, err
+}
+
+func PutSegmentInfo(tx *bolt.Tx, info *SegmentInfo) error {
+	return tx.Bucket(BucketSegments).Put([]byte(info.Name),
+		encodeVarInts([]int{info.Size, info.MinDocId, info.MaxDocId, info.Format}))
+}
+
+// 删除段及其清单记录
+func DropSegment(tx *bolt.Tx, name string) error {
+	if err := tx.Bucket(BucketSegments).Delete([]byte(name)); err != nil {
+		return err
+	}
+	return tx.DeleteBucket([]byte(name))
+}
+
+// 将旧版本的 token_postings 登记为一个段
+func registerLegacyPostings(tx *bolt.Tx) error {
+	bucket := tx.Bucket(BucketTokenPostings)
+	if bucket == nil || tx.Bucket(BucketSegments).Get(BucketTokenPostings) != nil {
+		return nil
+	}
+	info := &SegmentInfo{Name: string(BucketTokenPostings), MaxDocId: int(^uint(0) >> 1)}
+	_ = bucket.ForEach(func(k, v []byte) error {
+		info.Size += len(v)
+		return nil
+	})
+	if info.Size == 0 {
+		return tx.DeleteBucket(BucketTokenPostings)
+	}
+	return PutSegmentInfo(tx, info)
+}
+
+// 所有段的信息
+func (db *IndexDB) Segments() []SegmentInfo {
+	var segments []SegmentInfo
+	_ = db.indexDB.View(func(tx *bolt.Tx) error {
+		segments = ListSegments(tx)
+		return nil
+	})
+	return segments
+}
@@ -0,0 +1,40 @@
+// 分片迁移：URL 按一致性哈希分配到各个索引服务器，不属于本机的 URL 迁移到其他索引服务器
+package db
+
+import (
+	"github.com/boltdb/bolt"
+	"strconv"
+)
+
+// 本机保存了文档或锚文本的所有 URL
+func (db *IndexDB) Urls() []string {
+	seen := make(map[string]struct{})
+	var urls []string
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{BucketUrlDoc, BucketAnchorText} {
+			_ = tx.Bucket(name).ForEach(func(k, v []byte) error {
+				if _, ok := seen[string(k)]; !ok {
+					seen[string(k)] = struct{}{}
+					urls = append(urls, string(k))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return urls
+}
+
+// 本机所有文档的 URL -> 文档 ID，副本同步前记录，同步后删除对方没有且期间没有更新的文档
+func (db *IndexDB) DocumentIds() map[string]int {
+	ids := make(map[string]int)
+	_ = db.docDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(BucketUrlDoc).ForEach(func(k, v []byte) error {
+			if docId, err := strconv.Atoi(string(v)); err == nil {
+				ids[string(k)] = docId
+			}
+			return nil
+		})
+	})
+	return ids
+}
@@ -0,0 +1,76 @@
+// 同义词表，由 web 后台管理，索引服务器定期加载：
+// 分布式模式下保存在 MySQL 的 synonym 表中，嵌入模式下保存在后台管理数据文件中
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+	"log"
+	"os"
+	"search-engine/index/config"
+)
+
+type SynonymSource interface {
+	// word->synonyms
+	LoadSynonyms() (map[string][]string, error)
+}
+
+func NewSynonymSource() SynonymSource {
+	if config.Embedded() {
+		return &fileSynonymSource{path: config.Get("embedded.adminDataPath")}
+	}
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8", config.Get("mysql.username"),
+		config.Get("mysql.password"), config.Get("mysql.host"), config.Get("mysql.port"), config.Get("mysql.dbname"))
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		log.Fatalln("初始化Mysql失败", err.Error())
+	}
+	return &mysqlSynonymSource{db: db}
+}
+
+// 没有建 synonym 表时加载失败，不影响检索
+type mysqlSynonymSource struct {
+	db *sql.DB
+}
+
+func (m *mysqlSynonymSource) LoadSynonyms() (map[string][]string, error) {
+	rows, err := m.db.Query("select `word`, `synonym` from `synonym`")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	synonyms := make(map[string][]string)
+	var word, synonym string
+	for rows.Next() {
+		if err = rows.Scan(&word, &synonym); err != nil {
+			return nil, err
+		}
+		synonyms[word] = append(synonyms[word], synonym)
+	}
+	return synonyms, rows.Err()
+}
+
+// 嵌入模式下只读取管理数据文件中的同义词
+type fileSynonymSource struct {
+	path string
+}
+
+func (f *fileSynonymSource) LoadSynonyms() (map[string][]string, error) {
+	var data struct {
+		Synonym map[string][]string `json:"synonym"`
+	}
+	b, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		// 后台还没有保存过数据
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data.Synonym, nil
+}
@@ -10,5 +10,7 @@ require (
     github.com/go-sql-driver/mysql v1.6.0
     github.com/shirou/gopsutil v3.21.3+incompatible
     github.com/tklauser/go-sysconf v0.3.5 // indirect
+    golang.org/x/net v0.0.0-20210324205630-d1beb07c2056
     golang.org/x/sys v0.0.0-20210403161142-5e06dd20ab57 // indirect
+    golang.org/x/text v0.3.3
 )
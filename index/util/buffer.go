@@ -43,8 +43,7 @@ func (b *Buffer) Get(key interface{}) interface{} {
 		b._add(key, v)
 		return v
 	}
-	b.list.Remove(e)
-	b.list.PushFront(e)
+	b.list.MoveToFront(e)
 	return e.Value.(*node).value
 }
 
@@ -0,0 +1,43 @@
+// web 服务的启动流程，main 和 all-in-one 共用
+package app
+
+import (
+	"net/http"
+	"path/filepath"
+	"search-engine/web/config"
+	"search-engine/web/service"
+)
+
+func Run() error {
+	service.Init()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", service.IndexHandler)
+	mux.HandleFunc("/search", service.SearchHandler)
+	mux.HandleFunc("/suggest", service.SuggestHandler)
+	mux.HandleFunc("/opensearch.xml", service.OpenSearchHandler)
+	mux.HandleFunc("/proxy", service.ProxyHandler)
+	// admin
+	staticDir := filepath.Join(config.Get("web.templateDir"), "static")
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticDir)))) // admin.js
+	mux.HandleFunc("/admin", service.AdminHandler)
+	mux.HandleFunc("/admin/login", service.AdminLoginHandler)
+	mux.HandleFunc("/admin/monitor", service.MonitorHandler)
+	mux.HandleFunc("/admin/include_domain", service.IncludeDomainHandler)
+	mux.HandleFunc("/admin/manage_illegal_keyword", service.ManageIllegalKeywordHandler)
+	mux.HandleFunc("/admin/manage_domain_blacklist", service.ManageDomainBlacklistHandler)
+	mux.HandleFunc("/admin/get_illegal_keyword", service.GetIllegalKeywordHandler)
+	mux.HandleFunc("/admin/get_domain_blacklist", service.GetDomainBlacklistHandler)
+	mux.HandleFunc("/admin/manage_synonym", service.ManageSynonymHandler)
+	mux.HandleFunc("/admin/get_synonym", service.GetSynonymHandler)
+	mux.HandleFunc("/admin/manage_domain_priority", service.ManageDomainPriorityHandler)
+	mux.HandleFunc("/admin/get_domain_priority", service.GetDomainPriorityHandler)
+	mux.HandleFunc("/admin/get_crawler_config", service.GetCrawlerConfigHandler)
+	mux.HandleFunc("/admin/update_crawler_config", service.UpdateCrawlerConfigHandler)
+	mux.HandleFunc("/admin/get_backups", service.GetBackupsHandler)
+	mux.HandleFunc("/admin/create_backup", service.CreateBackupHandler)
+	// 证书配置为空时使用 HTTP，方便本地开发
+	if config.Get("web.certFile") == "" {
+		return http.ListenAndServe(config.Get("web.listenAddr"), mux)
+	}
+	return http.ListenAndServeTLS(config.Get("web.listenAddr"), config.Get("web.certFile"), config.Get("web.keyFile"), mux)
+}
@@ -8,10 +8,30 @@ import (
 	"strings"
 )
 
+// 存储模式
+const (
+	// 分布式模式，后台数据存储在 MySQL 中，服务注册、缓存使用 Redis
+	ModeDistributed = "distributed"
+	// 嵌入模式，不依赖 MySQL 和 Redis，后台数据存储在本地文件中，缓存、服务注册使用进程内的实现
+	ModeEmbedded = "embedded"
+)
+
 // 本地配置项必须提供
-var localConfigItem = [...]string{"mysql.username", "mysql.password", "mysql.host",
+var localConfigItem = [...]string{"web.listenAddr"}
+
+// 分布式模式下必须提供的配置项
+var distributedConfigItem = [...]string{"mysql.username", "mysql.password", "mysql.host",
 	"mysql.port", "mysql.dbname", "redis.addr"}
 
+// 可选配置项的默认值
+var defaultConfig = map[string]string{
+	"storage.mode":           ModeDistributed,
+	"embedded.adminDataPath": "./data/admin.json",
+	"web.templateDir":        "./template",
+	"web.certFile":           "./cert.pem",
+	"web.keyFile":            "./private.pem",
+}
+
 var config map[string]string
 
 func init() {
@@ -27,6 +47,9 @@ func init() {
 	}
 
 	config = make(map[string]string)
+	for name, value := range defaultConfig {
+		config[name] = value
+	}
 	for _, line := range strings.Split(string(b), "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" || line[0] == '#' {
@@ -42,7 +65,15 @@ func init() {
 }
 
 func checkLocalConfig(config map[string]string) {
-	for _, name := range localConfigItem {
+	required := localConfigItem[:]
+	switch config["storage.mode"] {
+	case ModeDistributed:
+		required = append(required, distributedConfigItem[:]...)
+	case ModeEmbedded:
+	default:
+		panic(fmt.Sprintf("配置项[storage.mode]错误：%s", config["storage.mode"]))
+	}
+	for _, name := range required {
 		if _, ok := config[name]; !ok {
 			panic(fmt.Sprintf("缺少配置项[%s]", name))
 		}
@@ -60,3 +91,8 @@ func GetInt(name string) int {
 	}
 	return i
 }
+
+// 是否运行在嵌入模式下
+func Embedded() bool {
+	return config["storage.mode"] == ModeEmbedded
+}
@@ -0,0 +1,144 @@
+// 搜索结果缓存
+package db
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"time"
+)
+
+type SearchCache interface {
+	// 获取列表 key 中 [start, stop] 区间的元素及列表的长度，列表不存在时长度为 0
+	LRange(key string, start, stop int64) ([]string, int64, error)
+	// 用 values 替换列表 key 的内容，并设置过期时间。替换是原子的，同时写入同一个 key 时结果不会重复
+	SetList(key string, ttl time.Duration, values ...[]byte) error
+	Incr(key string) (int64, error)
+	// key 不存在时返回 0
+	GetInt64(key string) (int64, error)
+}
+
+var ctx = context.Background()
+
+type redisCache struct {
+	redis *redis.Client
+}
+
+func NewRedisCache(client *redis.Client) SearchCache {
+	return &redisCache{redis: client}
+}
+
+func (r *redisCache) LRange(key string, start, stop int64) ([]string, int64, error) {
+	pipeline := r.redis.Pipeline()
+	defer pipeline.Close()
+	pItems := pipeline.LRange(ctx, key, start, stop)
+	pLen := pipeline.LLen(ctx, key)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return nil, 0, err
+	}
+	return pItems.Val(), pLen.Val(), nil
+}
+
+func (r *redisCache) SetList(key string, ttl time.Duration, values ...[]byte) error {
+	list := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		list = append(list, v)
+	}
+	// MULTI/EXEC，删除和追加之间不会插入其他客户端的命令
+	pipeline := r.redis.TxPipeline()
+	defer pipeline.Close()
+	pipeline.Del(ctx, key)
+	pipeline.RPush(ctx, key, list...)
+	pipeline.Expire(ctx, key, ttl)
+	_, err := pipeline.Exec(ctx)
+	return err
+}
+
+func (r *redisCache) Incr(key string) (int64, error) {
+	return r.redis.Incr(ctx, key).Result()
+}
+
+func (r *redisCache) GetInt64(key string) (int64, error) {
+	i, err := r.redis.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return i, err
+}
+
+// 进程内的缓存，嵌入模式使用
+type memoryCache struct {
+	lists    map[string]*memoryCacheList
+	counters map[string]int64
+	lock     sync.Mutex
+}
+
+type memoryCacheList struct {
+	values   []string
+	expireAt time.Time
+}
+
+func NewMemoryCache() SearchCache {
+	c := &memoryCache{
+		lists:    make(map[string]*memoryCacheList),
+		counters: make(map[string]int64),
+	}
+	// 定期清除过期的列表
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			c.lock.Lock()
+			now := time.Now()
+			for key, l := range c.lists {
+				if now.After(l.expireAt) {
+					delete(c.lists, key)
+				}
+			}
+			c.lock.Unlock()
+		}
+	}()
+	return c
+}
+
+func (m *memoryCache) LRange(key string, start, stop int64) ([]string, int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l, ok := m.lists[key]
+	if !ok || time.Now().After(l.expireAt) {
+		return nil, 0, nil
+	}
+	length := int64(len(l.values))
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return nil, length, nil
+	}
+	values := make([]string, stop-start+1)
+	copy(values, l.values[start:stop+1])
+	return values, length, nil
+}
+
+func (m *memoryCache) SetList(key string, ttl time.Duration, values ...[]byte) error {
+	l := &memoryCacheList{values: make([]string, 0, len(values)), expireAt: time.Now().Add(ttl)}
+	for _, v := range values {
+		l.values = append(l.values, string(v))
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lists[key] = l
+	return nil
+}
+
+func (m *memoryCache) Incr(key string) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counters[key]++
+	return m.counters[key], nil
+}
+
+func (m *memoryCache) GetInt64(key string) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.counters[key], nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"search-engine/web/config"
+	"strings"
+)
+
+// 根据存储模式初始化的全局存储，all-in-one 会将 Registry 替换成各服务共享的实例
+var (
+	Admin    AdminStore
+	Cache    SearchCache
+	Registry ServiceRegistry
+)
+
+// 后台管理数据
+type AdminStore interface {
+	GetIllegalKeyWords() ([]string, error)
+	AddIllegalKeywords(keywords []string) error
+	DelIllegalKeyword(keyword string) error
+	GetDomainBlacklist() ([]string, error)
+	AddDomainBlacklist(domainList []string) error
+	DelDomainBlacklist(domain string) error
+	// 域名优先级 domain->priority，爬虫的调度器优先抓取优先级高的域名
+	GetDomainPriority() (map[string]int, error)
+	SetDomainPriority(domainList []string, priority int) error
+	DelDomainPriority(domain string) error
+	// password 是加盐哈希后的密码
+	Login(username, password string) (bool, error)
+	UpdateCrawlerConfig(name, value string) error
+	GetCrawlerConfig() (map[string]string, error)
+	// 同义词 word->synonyms，索引服务器检索时把关键词扩展成同义词的 OR
+	GetSynonyms() (map[string][]string, error)
+	AddSynonyms(word string, synonyms []string) error
+	// 删除 word 的所有同义词
+	DelSynonyms(word string) error
+}
+
+func init() {
+	if config.Embedded() {
+		Admin = NewFileAdminStore(config.Get("embedded.adminDataPath"))
+		Cache = NewMemoryCache()
+		// 嵌入模式下单独部署时，通过配置指定索引服务器、爬虫服务器地址
+		registry := NewMemoryRegistry()
+		for service, name := range map[string]string{
+			"indexer.addr": "embedded.indexerAddrs",
+			"crawler.addr": "embedded.crawlerAddrs",
+		} {
+			for _, addr := range strings.Split(config.Get(name), ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					registry.AddStatic(service, addr)
+				}
+			}
+		}
+		Registry = registry
+		return
+	}
+	Admin = NewMysqlDB(&MysqlDBOptions{
+		User:     config.Get("mysql.username"),
+		Password: config.Get("mysql.password"),
+		Host:     config.Get("mysql.host"),
+		Port:     config.GetInt("mysql.port"),
+		DBName:   config.Get("mysql.dbname"),
+	})
+	centerRedis := NewRedis()
+	Registry = NewRedisRegistry(centerRedis)
+	Cache = NewRedisCache(centerRedis) // todo 缓存应该使用一个单独的 redis
+}
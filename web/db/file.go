@@ -0,0 +1,219 @@
+// 嵌入模式下的后台管理数据，保存在本地 json 文件中，代替 MySQL
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// 和 service 中的 salt 保持一致
+	passwordSalt         = "QUT-SeArCh"
+	defaultAdminUsername = "admin"
+	defaultAdminPassword = "admin"
+)
+
+// 文件格式，爬虫、索引服务器在嵌入模式下也会读取这个文件中和自己有关的部分
+type adminData struct {
+	Admin           map[string]string   `json:"admin"` // username->加盐哈希后的密码
+	IllegalKeyword  []string            `json:"illegal_keyword"`
+	DomainBlacklist []string            `json:"domain_blacklist"`
+	DomainPriority  map[string]int      `json:"domain_priority"`
+	Crawler         map[string]string   `json:"crawler"`
+	Synonym         map[string][]string `json:"synonym"`
+}
+
+type FileAdminStore struct {
+	path string
+	data *adminData
+	lock sync.Mutex
+}
+
+func NewFileAdminStore(path string) *FileAdminStore {
+	f := &FileAdminStore{path: path, data: &adminData{}}
+	b, err := os.ReadFile(path)
+	if err == nil {
+		if err = json.Unmarshal(b, f.data); err != nil {
+			log.Fatalln("解析管理数据文件失败", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		log.Fatalln("读取管理数据文件失败", path, err)
+	}
+	if len(f.data.Admin) == 0 {
+		sum := sha256.Sum256([]byte(defaultAdminPassword + passwordSalt))
+		f.data.Admin = map[string]string{defaultAdminUsername: hex.EncodeToString(sum[:])}
+		log.Printf("已创建默认管理员 %s，密码 %s\n", defaultAdminUsername, defaultAdminPassword)
+	}
+	if f.data.Crawler == nil {
+		f.data.Crawler = make(map[string]string)
+	}
+	if f.data.DomainPriority == nil {
+		f.data.DomainPriority = make(map[string]int)
+	}
+	if f.data.Synonym == nil {
+		f.data.Synonym = make(map[string][]string)
+	}
+	if err = f.save(); err != nil {
+		log.Fatalln("写入管理数据文件失败", path, err)
+	}
+	return f
+}
+
+// 先写临时文件再重命名，避免其他进程读到写了一半的文件
+func (f *FileAdminStore) save() error {
+	b, err := json.MarshalIndent(f.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// 在锁内修改数据并保存
+func (f *FileAdminStore) update(fn func(data *adminData)) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	fn(f.data)
+	return f.save()
+}
+
+func appendUnique(list []string, items []string) []string {
+	set := make(map[string]struct{}, len(list))
+	for _, s := range list {
+		set[s] = struct{}{}
+	}
+	for _, s := range items {
+		if _, ok := set[s]; !ok {
+			set[s] = struct{}{}
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func remove(list []string, item string) []string {
+	putIdx := 0
+	for _, s := range list {
+		if s != item {
+			list[putIdx] = s
+			putIdx++
+		}
+	}
+	return list[:putIdx]
+}
+
+func (f *FileAdminStore) GetIllegalKeyWords() ([]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.data.IllegalKeyword...), nil
+}
+
+func (f *FileAdminStore) AddIllegalKeywords(keywords []string) error {
+	return f.update(func(data *adminData) {
+		data.IllegalKeyword = appendUnique(data.IllegalKeyword, keywords)
+	})
+}
+
+func (f *FileAdminStore) DelIllegalKeyword(keyword string) error {
+	return f.update(func(data *adminData) {
+		data.IllegalKeyword = remove(data.IllegalKeyword, keyword)
+	})
+}
+
+func (f *FileAdminStore) GetDomainBlacklist() ([]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.data.DomainBlacklist...), nil
+}
+
+func (f *FileAdminStore) AddDomainBlacklist(domainList []string) error {
+	return f.update(func(data *adminData) {
+		data.DomainBlacklist = appendUnique(data.DomainBlacklist, domainList)
+	})
+}
+
+func (f *FileAdminStore) DelDomainBlacklist(domain string) error {
+	return f.update(func(data *adminData) {
+		data.DomainBlacklist = remove(data.DomainBlacklist, domain)
+	})
+}
+
+func (f *FileAdminStore) GetDomainPriority() (map[string]int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	priority := make(map[string]int, len(f.data.DomainPriority))
+	for domain, p := range f.data.DomainPriority {
+		priority[domain] = p
+	}
+	return priority, nil
+}
+
+func (f *FileAdminStore) SetDomainPriority(domainList []string, priority int) error {
+	return f.update(func(data *adminData) {
+		for _, domain := range domainList {
+			data.DomainPriority[domain] = priority
+		}
+	})
+}
+
+func (f *FileAdminStore) DelDomainPriority(domain string) error {
+	return f.update(func(data *adminData) {
+		delete(data.DomainPriority, domain)
+	})
+}
+
+func (f *FileAdminStore) Login(username, password string) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	p, ok := f.data.Admin[username]
+	return ok && p == password, nil
+}
+
+func (f *FileAdminStore) UpdateCrawlerConfig(name, value string) error {
+	return f.update(func(data *adminData) {
+		data.Crawler[name] = value
+	})
+}
+
+func (f *FileAdminStore) GetCrawlerConfig() (map[string]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	conf := make(map[string]string, len(f.data.Crawler))
+	for name, value := range f.data.Crawler {
+		conf[name] = value
+	}
+	return conf, nil
+}
+
+func (f *FileAdminStore) GetSynonyms() (map[string][]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	synonyms := make(map[string][]string, len(f.data.Synonym))
+	for word, list := range f.data.Synonym {
+		synonyms[word] = append([]string(nil), list...)
+	}
+	return synonyms, nil
+}
+
+func (f *FileAdminStore) AddSynonyms(word string, synonyms []string) error {
+	return f.update(func(data *adminData) {
+		data.Synonym[word] = appendUnique(data.Synonym[word], synonyms)
+	})
+}
+
+func (f *FileAdminStore) DelSynonyms(word string) error {
+	return f.update(func(data *adminData) {
+		delete(data.Synonym, word)
+	})
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"log"
-	"search-engine/web/config"
 	"strings"
 )
 
@@ -16,9 +15,15 @@ type MysqlDB struct {
 	delIllegalKeyword   *sql.Stmt
 	getDomainBlackList  *sql.Stmt
 	delDomain           *sql.Stmt
+	getDomainPriority   *sql.Stmt
+	setDomainPriority   *sql.Stmt
+	delDomainPriority   *sql.Stmt
 	login               *sql.Stmt
 	updateCrawlerConfig *sql.Stmt
 	getCrawlerConfig    *sql.Stmt
+	getSynonyms         *sql.Stmt
+	addSynonym          *sql.Stmt
+	delSynonyms         *sql.Stmt
 }
 
 type MysqlDBOptions struct {
@@ -29,14 +34,6 @@ type MysqlDBOptions struct {
 	DBName   string
 }
 
-var Mysql = NewMysqlDB(&MysqlDBOptions{
-	User:     config.Get("mysql.username"),
-	Password: config.Get("mysql.password"),
-	Host:     config.Get("mysql.host"),
-	Port:     config.GetInt("mysql.port"),
-	DBName:   config.Get("mysql.dbname"),
-})
-
 func NewMysqlDB(options *MysqlDBOptions) *MysqlDB {
 	mysqlDB := &MysqlDB{}
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8",
@@ -64,6 +61,15 @@ func NewMysqlDB(options *MysqlDBOptions) *MysqlDB {
 	mysqlDB.delDomain, err = db.Prepare("delete from `domain_blacklist` where `domain` = ?")
 	checkDBInitError(err)
 
+	mysqlDB.getDomainPriority, err = db.Prepare("select `domain`, `priority` from `domain_priority`")
+	checkDBInitError(err)
+
+	mysqlDB.setDomainPriority, err = db.Prepare("replace into `domain_priority`(`domain`, `priority`) values(?, ?)")
+	checkDBInitError(err)
+
+	mysqlDB.delDomainPriority, err = db.Prepare("delete from `domain_priority` where `domain` = ?")
+	checkDBInitError(err)
+
 	mysqlDB.login, err = db.Prepare("select count(*) > 0 from admin where `username` = ? and `password` = ?")
 	checkDBInitError(err)
 
@@ -73,6 +79,15 @@ func NewMysqlDB(options *MysqlDBOptions) *MysqlDB {
 
 	mysqlDB.getCrawlerConfig, err = db.Prepare("select `name`, `value` from `crawler`")
 	checkDBInitError(err)
+
+	mysqlDB.getSynonyms, err = db.Prepare("select `word`, `synonym` from `synonym`")
+	checkDBInitError(err)
+
+	mysqlDB.addSynonym, err = db.Prepare("replace into `synonym`(`word`, `synonym`) values(?, ?)")
+	checkDBInitError(err)
+
+	mysqlDB.delSynonyms, err = db.Prepare("delete from `synonym` where `word` = ?")
+	checkDBInitError(err)
 	return mysqlDB
 }
 
@@ -149,6 +164,38 @@ func (db *MysqlDB) DelDomainBlacklist(domain string) error {
 	return err
 }
 
+func (db *MysqlDB) GetDomainPriority() (map[string]int, error) {
+	rows, err := db.getDomainPriority.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	priority := make(map[string]int)
+	var domain string
+	var p int
+	for rows.Next() {
+		if err = rows.Scan(&domain, &p); err != nil {
+			return nil, err
+		}
+		priority[domain] = p
+	}
+	return priority, nil
+}
+
+func (db *MysqlDB) SetDomainPriority(domainList []string, priority int) error {
+	for _, domain := range domainList {
+		if _, err := db.setDomainPriority.Exec(domain, priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *MysqlDB) DelDomainPriority(domain string) error {
+	_, err := db.delDomainPriority.Exec(domain)
+	return err
+}
+
 func (db *MysqlDB) Login(username, password string) (bool, error) {
 	row, err := db.login.Query(username, password)
 	if err != nil {
@@ -183,3 +230,34 @@ func (db *MysqlDB) GetCrawlerConfig() (map[string]string, error) {
 	}
 	return conf, nil
 }
+
+func (db *MysqlDB) GetSynonyms() (map[string][]string, error) {
+	rows, err := db.getSynonyms.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	synonyms := make(map[string][]string)
+	var word, synonym string
+	for rows.Next() {
+		if err = rows.Scan(&word, &synonym); err != nil {
+			return nil, err
+		}
+		synonyms[word] = append(synonyms[word], synonym)
+	}
+	return synonyms, nil
+}
+
+func (db *MysqlDB) AddSynonyms(word string, synonyms []string) error {
+	for _, synonym := range synonyms {
+		if _, err := db.addSynonym.Exec(word, synonym); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *MysqlDB) DelSynonyms(word string) error {
+	_, err := db.delSynonyms.Exec(word)
+	return err
+}
@@ -11,6 +11,7 @@ import (
 	"math/rand"
 	"net/http"
 	"search-engine/web/db"
+	"strconv"
 	"strings"
 )
 
@@ -74,7 +75,7 @@ func MonitorHandler(writer http.ResponseWriter, request *http.Request) {
 }
 
 func GetCrawlerConfigHandler(writer http.ResponseWriter, request *http.Request) {
-	conf, err := db.Mysql.GetCrawlerConfig()
+	conf, err := db.Admin.GetCrawlerConfig()
 	if err != nil {
 		writeJson(writer, http.StatusBadRequest, &response{
 			Code: codeFail,
@@ -100,7 +101,7 @@ func UpdateCrawlerConfigHandler(writer http.ResponseWriter, request *http.Reques
 		return
 	}
 
-	err := db.Mysql.UpdateCrawlerConfig(name, value)
+	err := db.Admin.UpdateCrawlerConfig(name, value)
 	if err != nil {
 		writeJson(writer, http.StatusBadRequest, &response{
 			Code: codeFail,
@@ -117,7 +118,7 @@ func GetIllegalKeywordHandler(writer http.ResponseWriter, request *http.Request)
 		writeJson(writer, http.StatusBadRequest, &response{Code: codeFail, Msg: "未登录"})
 		return
 	}
-	keywords, err := db.Mysql.GetIllegalKeyWords()
+	keywords, err := db.Admin.GetIllegalKeyWords()
 	if err != nil {
 		writeJson(writer, http.StatusInternalServerError, &response{
 			Code: codeFail,
@@ -158,23 +159,72 @@ func ManageIllegalKeywordHandler(writer http.ResponseWriter, request *http.Reque
 
 	// 访问数据库
 	if opType == "add" {
-		if err := db.Mysql.AddIllegalKeywords(keywordList); err != nil {
+		if err := db.Admin.AddIllegalKeywords(keywordList); err != nil {
 			writeJson(writer, http.StatusInternalServerError, &response{Code: codeFail, Msg: "操作失败"})
 			return
 		}
 	} else if opType == "del" {
-		if err := db.Mysql.DelIllegalKeyword(keyword); err != nil {
+		if err := db.Admin.DelIllegalKeyword(keyword); err != nil {
 			writeJson(writer, http.StatusInternalServerError, &response{Code: codeFail, Msg: "操作失败"})
 			return
 		}
 	}
+	onFilterListsChanged()
 	writeJson(writer, http.StatusOK, &response{
 		Code: codeSuccess,
 	})
 }
 
+// 获取同义词
+func GetSynonymHandler(writer http.ResponseWriter, request *http.Request) {
+	if !checkLogin(request) {
+		writeJson(writer, http.StatusBadRequest, &response{Code: codeFail, Msg: "未登录"})
+		return
+	}
+	synonyms, err := db.Admin.GetSynonyms()
+	if err != nil {
+		writeJson(writer, http.StatusInternalServerError, &response{Code: codeFail, Msg: "获取失败"})
+		return
+	}
+	writeJson(writer, http.StatusOK, &response{Code: codeSuccess, Data: synonyms})
+}
+
+// 管理同义词，添加时 synonyms 用 "|" 分隔，删除时删除 word 的所有同义词。
+// 索引服务器定期加载同义词表，不需要通知
+func ManageSynonymHandler(writer http.ResponseWriter, request *http.Request) {
+	if !checkLogin(request) {
+		writeJson(writer, http.StatusBadRequest, &response{Code: codeFail, Msg: "未登录"})
+		return
+	}
+	word := strings.TrimSpace(request.FormValue("word"))
+	opType := strings.TrimSpace(request.FormValue("opType"))
+	var synonyms []string
+	for _, s := range strings.Split(request.FormValue("synonyms"), "|") {
+		if s = strings.TrimSpace(s); s != "" && s != word {
+			synonyms = append(synonyms, s)
+		}
+	}
+	if word == "" || (opType != "add" && opType != "del") || (opType == "add" && len(synonyms) == 0) {
+		writeJson(writer, http.StatusBadRequest, &response{Code: codeFail, Msg: "参数错误"})
+		return
+	}
+
+	var err error
+	if opType == "add" {
+		err = db.Admin.AddSynonyms(word, synonyms)
+	} else {
+		err = db.Admin.DelSynonyms(word)
+	}
+	if err != nil {
+		log.Println(err)
+		writeJson(writer, http.StatusInternalServerError, &response{Code: codeFail, Msg: "操作失败"})
+		return
+	}
+	writeJson(writer, http.StatusOK, &response{Code: codeSuccess})
+}
+
 func GetDomainBlacklistHandler(writer http.ResponseWriter, request *http.Request) {
-	blacklist, err := db.Mysql.GetDomainBlacklist()
+	blacklist, err := db.Admin.GetDomainBlacklist()
 	if err != nil {
 		writeJson(writer, http.StatusInternalServerError, &response{
 			Code: codeFail,
@@ -216,7 +266,7 @@ func ManageDomainBlacklistHandler(writer http.ResponseWriter, request *http.Requ
 
 	// 访问数据库
 	if opType == "add" {
-		if err := db.Mysql.AddDomainBlacklist(domainList); err != nil {
+		if err := db.Admin.AddDomainBlacklist(domainList); err != nil {
 			writeJson(writer, http.StatusInternalServerError, &response{
 				Code: codeFail,
 				Msg:  "操作失败",
@@ -224,7 +274,7 @@ func ManageDomainBlacklistHandler(writer http.ResponseWriter, request *http.Requ
 			return
 		}
 	} else {
-		if err := db.Mysql.DelDomainBlacklist(domain); err != nil {
+		if err := db.Admin.DelDomainBlacklist(domain); err != nil {
 			writeJson(writer, http.StatusInternalServerError, &response{
 				Code: codeFail,
 				Msg:  "操作失败",
@@ -232,10 +282,85 @@ func ManageDomainBlacklistHandler(writer http.ResponseWriter, request *http.Requ
 			return
 		}
 	}
+	onFilterListsChanged()
+	writeJson(writer, http.StatusOK, &response{
+		Code: codeSuccess,
+	})
+
+}
+
+func GetDomainPriorityHandler(writer http.ResponseWriter, request *http.Request) {
+	priority, err := db.Admin.GetDomainPriority()
+	if err != nil {
+		writeJson(writer, http.StatusInternalServerError, &response{
+			Code: codeFail,
+			Msg:  "获取失败",
+		})
+		return
+	}
+	writeJson(writer, http.StatusOK, &response{
+		Code: codeSuccess,
+		Data: priority,
+	})
+}
+
+// 管理域名优先级，优先级范围 1~10，默认为 1，爬虫会在几秒内读取到新的优先级
+func ManageDomainPriorityHandler(writer http.ResponseWriter, request *http.Request) {
+	if !checkLogin(request) {
+		writeJson(writer, http.StatusBadRequest, &response{Code: codeFail, Msg: "未登录"})
+		return
+	}
+	// 处理参数
+	domain := strings.TrimSpace(request.FormValue("domain"))
+	opType := strings.TrimSpace(request.FormValue("opType"))
+	if domain == "" || (opType != "set" && opType != "del") {
+		writeJson(writer, http.StatusBadRequest, &response{
+			Code: codeFail,
+			Msg:  "参数错误",
+		})
+		return
+	}
+
+	// 访问数据库
+	var err error
+	if opType == "set" {
+		priority, convErr := strconv.Atoi(strings.TrimSpace(request.FormValue("priority")))
+		if convErr != nil || priority < minDomainPriority || priority > maxDomainPriority {
+			writeJson(writer, http.StatusBadRequest, &response{
+				Code: codeFail,
+				Msg:  fmt.Sprintf("优先级必须是 %d~%d 的整数", minDomainPriority, maxDomainPriority),
+			})
+			return
+		}
+		domainList := strings.Split(domain, "|")
+		putIdx := 0
+		for i := 0; i < len(domainList); i++ {
+			if d := strings.ToLower(strings.TrimSpace(domainList[i])); d != "" {
+				domainList[putIdx] = d
+				putIdx++
+			}
+		}
+		err = db.Admin.SetDomainPriority(domainList[:putIdx], priority)
+	} else {
+		err = db.Admin.DelDomainPriority(domain)
+	}
+	if err != nil {
+		writeJson(writer, http.StatusInternalServerError, &response{
+			Code: codeFail,
+			Msg:  "操作失败",
+		})
+		return
+	}
 	writeJson(writer, http.StatusOK, &response{
 		Code: codeSuccess,
 	})
+}
 
+// 非法关键词或域名黑名单改变后，立即更新本地列表，并使缓存的搜索结果失效，
+// 其他 web 服务器会在下次定时刷新时加载新的列表和缓存版本号
+func onFilterListsChanged() {
+	invalidateSearchCache()
+	refreshFilterLists()
 }
 
 // 收录域名
@@ -268,7 +393,8 @@ func IncludeDomainHandler(writer http.ResponseWriter, request *http.Request) {
 	b, _ := json.Marshal(map[string]interface{}{
 		"seed_urls": domainList,
 	})
-	resp, err := http.Post(addr+"/seedurl", "application/json", bytes.NewReader(b))
+	// 注册中心中的地址不带协议
+	resp, err := http.Post("http://"+addr+"/seedurl", "application/json", bytes.NewReader(b))
 	if err != nil {
 		writeJson(writer, http.StatusInternalServerError, &response{Code: codeFail, Msg: "收录失败"})
 		return
@@ -284,6 +410,12 @@ func IncludeDomainHandler(writer http.ResponseWriter, request *http.Request) {
 
 const salt = "QUT-SeArCh"
 
+// 域名优先级的范围，和爬虫保持一致
+const (
+	minDomainPriority = 1
+	maxDomainPriority = 10
+)
+
 func AdminLoginHandler(writer http.ResponseWriter, request *http.Request) {
 	username := strings.TrimSpace(request.FormValue("username"))
 	password := strings.TrimSpace(request.FormValue("password"))
@@ -296,7 +428,7 @@ func AdminLoginHandler(writer http.ResponseWriter, request *http.Request) {
 	}
 	sum := sha256.Sum256([]byte(password + salt))
 	password = hex.EncodeToString(sum[:])
-	if ok, err := db.Mysql.Login(username, password); err != nil || !ok {
+	if ok, err := db.Admin.Login(username, password); err != nil || !ok {
 		writeJson(writer, http.StatusOK, &response{
 			Code: codeFail,
 			Msg:  "用户名或密码错误",
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bitly/go-simplejson"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 备份整个数据库可能要很久
+var backupClient = &http.Client{Timeout: time.Minute * 30}
+
+// 获取各个索引服务器保存的备份
+func GetBackupsHandler(writer http.ResponseWriter, request *http.Request) {
+	if !checkLogin(request) {
+		writeJson(writer, http.StatusBadRequest, &response{Code: codeFail, Msg: "未登录"})
+		return
+	}
+	addrList := indexerAddrList.Load().([]string)
+	resultList := requestServerList(addrList, func(channel chan<- interface{}, addr string) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/backups", addr))
+		if err != nil {
+			log.Println(err)
+			channel <- nil
+			return
+		}
+		defer resp.Body.Close()
+
+		j, err := simplejson.NewFromReader(resp.Body)
+		if err != nil || j.Get("code").MustInt() != codeSuccess {
+			log.Println("获取备份失败", err)
+			channel <- nil
+			return
+		}
+		channel <- map[string]interface{}{
+			"addr":    addr,
+			"backups": j.Get("data").MustArray(),
+		}
+	})
+
+	result := make([]map[string]interface{}, 0, len(resultList))
+	for _, r := range resultList {
+		result = append(result, r.(map[string]interface{}))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i]["addr"].(string) < result[j]["addr"].(string)
+	})
+	writeJson(writer, http.StatusOK, &response{Code: codeSuccess, Data: result})
+}
+
+// 让索引服务器立即备份，addr 为空时备份所有存活的索引服务器
+func CreateBackupHandler(writer http.ResponseWriter, request *http.Request) {
+	if !checkLogin(request) {
+		writeJson(writer, http.StatusBadRequest, &response{Code: codeFail, Msg: "未登录"})
+		return
+	}
+	addrList := indexerAddrList.Load().([]string)
+	if addr := strings.TrimSpace(request.FormValue("addr")); addr != "" {
+		// 只能是注册中心中存活的索引服务器
+		found := false
+		for _, a := range addrList {
+			found = found || a == addr
+		}
+		if !found {
+			writeJson(writer, http.StatusBadRequest, &response{Code: codeFail, Msg: "参数错误"})
+			return
+		}
+		addrList = []string{addr}
+	}
+	if len(addrList) == 0 {
+		writeJson(writer, http.StatusOK, &response{Code: codeFail, Msg: "操作失败，无索引服务器在运行"})
+		return
+	}
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		failed []string
+	)
+	for _, addr := range addrList {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if err := createBackup(addr); err != nil {
+				log.Println(addr+" 备份失败", err)
+				lock.Lock()
+				failed = append(failed, addr)
+				lock.Unlock()
+			}
+		}(addr)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		writeJson(writer, http.StatusInternalServerError, &response{
+			Code: codeFail,
+			Msg:  "备份失败：" + strings.Join(failed, ", "),
+		})
+		return
+	}
+	writeJson(writer, http.StatusOK, &response{Code: codeSuccess})
+}
+
+func createBackup(addr string) error {
+	// 注册中心中的地址不带协议
+	req, _ := http.NewRequest(http.MethodPut, "http://"+addr+"/backups", nil)
+	resp, err := backupClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	j, err := simplejson.NewFromReader(resp.Body)
+	if err != nil {
+		return err
+	} else if j.Get("code").MustInt() != codeSuccess {
+		return errors.New(j.Get("msg").MustString())
+	}
+	return nil
+}
@@ -7,8 +7,10 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"path/filepath"
+	"search-engine/web/config"
 	"search-engine/web/db"
-	"strconv"
+	"search-engine/web/util"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,14 +22,18 @@ type response struct {
 	Data interface{} `json:"data"`
 }
 
-func init() {
+// 初始化定时任务、模板等，需要在处理请求前调用
+func Init() {
 	initCron()
+	initSuggest()
 	initTemplate()
 	initSessionCleaner()
 }
 
 var (
 	illegalKeywords     []string
+	domainBlacklist     []string
+	cacheGeneration     int64 // 搜索结果缓存的版本号，非法关键词、域名黑名单变化后递增，使旧缓存失效
 	indexerAddrList     atomic.Value
 	deadIndexerAddrList atomic.Value
 	crawlerAddrList     atomic.Value
@@ -36,21 +42,17 @@ var (
 )
 
 // 定时任务协程
-//     - 更新非法关键词
+//     - 更新非法关键词、域名黑名单及缓存版本号
 //     - 获取最新 indexer 服务器地址
 //       - 获取最新 crawler 服务器地址
 func initCron() {
 	wg := sync.WaitGroup{}
 	wg.Add(2)
-	// 定期更新非法关键词
+	// 定期更新非法关键词、域名黑名单
 	go func() {
 		initialized := false
 		for {
-			illegal, err := db.Mysql.GetIllegalKeyWords()
-			if err == nil {
-				// 实时性要求低，不用做并发安全处理
-				illegalKeywords = illegal
-			}
+			refreshFilterLists()
 			if !initialized {
 				wg.Done()
 				initialized = true
@@ -63,13 +65,12 @@ func initCron() {
 		initialized := false
 		for {
 			// 索引服务器地址
-			if r, err := db.CenterRedis.HGetAll(ctx, "indexer.addr").Result(); err == nil {
+			if r, err := db.Registry.List("indexer.addr"); err == nil {
 				addrList := make([]string, 0, len(r))
 				deadAddrList := make([]string, 0)
 				for addr, heartbeatTime := range r {
-					t, _ := strconv.Atoi(heartbeatTime)
 					// 40秒内认为存活
-					if time.Now().Unix()-int64(t) < 40 {
+					if time.Now().Unix()-heartbeatTime < 40 {
 						addrList = append(addrList, addr)
 					} else {
 						deadAddrList = append(deadAddrList, addr)
@@ -77,17 +78,17 @@ func initCron() {
 				}
 				indexerAddrList.Store(addrList)
 				deadIndexerAddrList.Store(deadAddrList)
+				refreshShardState(addrList)
 			} else {
 				log.Println("获取索引服务器地址失败：" + err.Error())
 			}
 			// 爬虫服务器地址
-			if r, err := db.CenterRedis.HGetAll(ctx, "crawler.addr").Result(); err == nil {
+			if r, err := db.Registry.List("crawler.addr"); err == nil {
 				addrList := make([]string, 0, len(r))
 				deadAddrList := make([]string, 0)
 				for addr, heartbeatTime := range r {
-					t, _ := strconv.Atoi(heartbeatTime)
 					// 40秒内认为存活
-					if time.Now().Unix()-int64(t) < 40 {
+					if time.Now().Unix()-heartbeatTime < 40 {
 						addrList = append(addrList, addr)
 					} else {
 						deadAddrList = append(deadAddrList, addr)
@@ -108,14 +109,28 @@ func initCron() {
 	wg.Wait()
 }
 
-func initTemplate() {
-	unescapeHTML := func(str string) template.HTML {
-		return template.HTML(str)
+// 重新加载非法关键词、域名黑名单，以及与之对应的缓存版本号
+func refreshFilterLists() {
+	if illegal, err := db.Admin.GetIllegalKeyWords(); err == nil {
+		// 实时性要求低，不用做并发安全处理
+		illegalKeywords = illegal
+	}
+	if blacklist, err := db.Admin.GetDomainBlacklist(); err == nil {
+		domainBlacklist = blacklist
+	}
+	if gen, err := db.Cache.GetInt64(cacheGenerationKey); err == nil {
+		atomic.StoreInt64(&cacheGeneration, gen)
 	}
-	maxPnToSlice := func(maxPn int) []int {
-		s := make([]int, maxPn)
-		for i := range s {
-			s[i] = i + 1
+}
+
+func initTemplate() {
+	// 当前页附近的页码，最多 10 个
+	pnRange := func(pn, maxPn int) []int {
+		start := util.MaxInt(1, util.MinInt(pn-5, maxPn-9))
+		end := util.MinInt(maxPn, start+9)
+		s := make([]int, 0, 10)
+		for i := start; i <= end; i++ {
+			s = append(s, i)
 		}
 		return s
 	}
@@ -124,11 +139,11 @@ func initTemplate() {
 	}
 	tmpl = template.New("tmpl")
 	tmpl.Funcs(template.FuncMap{
-		"unescapeHTML": unescapeHTML,
-		"maxPnToSlice": maxPnToSlice,
-		"add":          add,
+		"highlight": util.HighlightHTML,
+		"pnRange":   pnRange,
+		"add":       add,
 	})
-	t, err := tmpl.ParseGlob("./template/*html")
+	t, err := tmpl.ParseGlob(filepath.Join(config.Get("web.templateDir"), "*html"))
 	if err != nil {
 		log.Fatalln(err)
 	}
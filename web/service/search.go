@@ -1,7 +1,6 @@
 package service
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/bitly/go-simplejson"
@@ -14,11 +13,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-var ctx = context.Background()
-
 // 结果项的 url 都是绝对链接，所以为空即可
 var baseURL, _ = url.Parse("nil.com")
 
@@ -26,14 +24,34 @@ var baseURL, _ = url.Parse("nil.com")
 const (
 	codeSuccess = iota
 	codeFail
+	codeQueryError // 索引服务器返回的查询语法错误
+)
+
+// 搜索结果缓存版本号在 redis 中的 key
+const cacheGenerationKey = "search.cache.generation"
+
+const (
+	pageSize = 10 // 每页的结果数量
+	// 每次从索引服务器获取的结果数量，页码在同一个窗口内的请求共用一份缓存
+	searchWindowSize = 100
+	// 索引服务器最多返回排在前 1000 的结果
+	maxPn = 100
 )
 
+// 标题、摘要都是纯文本，由模板转义后再根据高亮区间加上高亮标签
 type searchResultItem struct {
-	Url          string  `json:"url"`
-	Title        string  `json:"title"`
-	Abstract     string  `json:"abstract"`
-	Score        float64 `json:"score"`
-	AnonymousUrl string  `json:"-"`
+	Url             string    `json:"url"`
+	Title           string    `json:"title"`
+	TitleHighlights [][2]int  `json:"title_highlights,omitempty"` // 高亮区间 [start, end)，字符下标
+	Snippets        []snippet `json:"snippets"`                   // 摘要片段，显示时用省略号连接
+	Anchors         []snippet `json:"anchors,omitempty"`          // 其他页面链接到这个页面时使用的文字
+	Score           float64   `json:"score"`
+	AnonymousUrl    string    `json:"-"`
+}
+
+type snippet struct {
+	Text       string   `json:"text"`
+	Highlights [][2]int `json:"highlights,omitempty"`
 }
 
 type searchResult struct {
@@ -41,8 +59,25 @@ type searchResult struct {
 	Items    []*searchResultItem
 	Info     string
 	Pn       int // 当前页码
-	MaxPn    int // 最大页码（<= 10）
+	MaxPn    int // 最大页码（<= maxPn）
+	Total    int // 结果总数，各个索引服务器的总数之和，没有去掉过滤掉的结果，是估计值
 	Duration float64
+	// 拼写纠错后的查询（您是不是要找），不需要纠正时为空
+	Suggestion string
+}
+
+// 索引服务器返回的结果
+type indexServerResult struct {
+	items      []*searchResultItem
+	total      int
+	queryError string // 查询语法错误
+	suggestion string // 拼写纠错后的查询
+}
+
+// 缓存的列表中的第一个元素
+type cacheHeader struct {
+	Total      int    `json:"total"`
+	Suggestion string `json:"suggestion,omitempty"`
 }
 
 func hasIllegalKeywords(query string) bool {
@@ -56,24 +91,97 @@ func hasIllegalKeywords(query string) bool {
 	return false
 }
 
-// 从缓存中获取搜索结果
+// 判断 rawUrl 是否属于黑名单中的域名（包括其子域名）
+func isBlacklistedUrl(rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	blacklist := domainBlacklist
+	for _, domain := range blacklist {
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "*.")
+		if domain == "" {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// 过滤掉黑名单域名下的结果，以及标题、摘要中含有非法关键词的结果
+func filterResultItems(items []*searchResultItem) []*searchResultItem {
+	putIdx := 0
+	for _, item := range items {
+		if isBlacklistedUrl(item.Url) || hasIllegalKeywords(item.Title) ||
+			snippetsHaveIllegalKeywords(item.Snippets) || snippetsHaveIllegalKeywords(item.Anchors) {
+			continue
+		}
+		items[putIdx] = item
+		putIdx++
+	}
+	return items[:putIdx]
+}
+
+func snippetsHaveIllegalKeywords(snippets []snippet) bool {
+	for _, s := range snippets {
+		if hasIllegalKeywords(s.Text) {
+			return true
+		}
+	}
+	return false
+}
+
+// 第 pn 页所在的窗口，需要从索引服务器获取排在前 window 的结果
+func windowOf(pn int) int {
+	return (pn*pageSize + searchWindowSize - 1) / searchWindowSize * searchWindowSize
+}
+
+// 最大页码
+func maxPnOf(total int) int {
+	return util.MinInt(int(math.Ceil(float64(total)/pageSize)), maxPn)
+}
+
+// 搜索结果在缓存中的 key，带上版本号，版本号改变后旧的缓存自然失效。
+// v2：结果项的标题、摘要改为纯文本加高亮区间，不再使用旧格式的缓存
+func cacheKey(query string, window int) string {
+	return fmt.Sprintf("search:v2:%d:%d:%s", atomic.LoadInt64(&cacheGeneration), window, query)
+}
+
+// 使所有缓存的搜索结果失效，非法关键词或域名黑名单改变时调用
+func invalidateSearchCache() {
+	gen, err := db.Cache.Incr(cacheGenerationKey)
+	if err != nil {
+		log.Println("更新缓存版本号时发生错误", err)
+		return
+	}
+	atomic.StoreInt64(&cacheGeneration, gen)
+}
+
+// 从缓存中获取搜索结果，缓存的列表中第一个元素是 cacheHeader，后面是窗口内的结果
 func getFromCache(query string, pn int) (*searchResult, error) {
 	result := new(searchResult)
-	// 因为 lrange 命令无法不存在的 key 返回 redis.Nil，所以要判断一下 key 是否存在
-	pipeline := db.CacheRedis.Pipeline()
-	defer pipeline.Close()
-	pExists := pipeline.Exists(ctx, query)
-	pItems := pipeline.LRange(ctx, query, int64((pn-1)*10), int64((pn-1)*10+9))
-	pItemsTotalLen := pipeline.LLen(ctx, query)
-	_, err := pipeline.Exec(ctx)
-	if err != nil || pExists.Err() != nil || pItems.Err() != nil || pItemsTotalLen.Err() != nil {
+	key := cacheKey(query, windowOf(pn))
+	start := int64((pn-1)*pageSize + 1)
+	itemStrList, totalLen, err := db.Cache.LRange(key, start, start+pageSize-1)
+	if err != nil {
 		return nil, err
-	} else if pExists.Val() == 0 {
-		// 从索引服务器中检索
+	} else if totalLen == 0 {
+		// 缓存中不存在，从索引服务器中检索
 		return nil, nil
 	}
+	headerStr, _, err := db.Cache.LRange(key, 0, 0)
+	if err != nil {
+		return nil, err
+	} else if len(headerStr) > 0 {
+		header := new(cacheHeader)
+		_ = json.Unmarshal([]byte(headerStr[0]), header)
+		result.Total, result.Suggestion = header.Total, header.Suggestion
+	}
 
-	for _, itemStr := range pItems.Val() {
+	for _, itemStr := range itemStrList {
 		item := new(searchResultItem)
 		err = json.Unmarshal([]byte(itemStr), item)
 		if err != nil {
@@ -84,34 +192,33 @@ func getFromCache(query string, pn int) (*searchResult, error) {
 	}
 	result.Query = query
 	result.Pn = pn
-	result.MaxPn = int(math.Ceil(float64(pItemsTotalLen.Val()) / 10))
+	result.MaxPn = maxPnOf(result.Total)
 	return result, nil
 }
 
-// 将搜索结果添加到缓存
-func addToCache(query string, items []*searchResultItem) {
-	if len(items) == 0 {
+// 将搜索结果添加到缓存，同一个查询同时未命中缓存时会写入多次，后写入的替换先写入的
+func addToCache(query string, window int, r *indexServerResult) {
+	if len(r.items) == 0 {
 		return
 	}
-	itemStrList := make([]interface{}, 0, len(items))
-	for _, item := range items {
+	itemStrList := make([][]byte, 0, len(r.items)+1)
+	header, _ := json.Marshal(&cacheHeader{Total: r.total, Suggestion: r.suggestion})
+	itemStrList = append(itemStrList, header)
+	for _, item := range r.items {
 		j, _ := json.Marshal(item)
 		itemStrList = append(itemStrList, j)
 	}
 
-	pipeline := db.CacheRedis.Pipeline()
-	pipeline.RPush(ctx, query, itemStrList...)
-	pipeline.Expire(ctx, query, time.Hour*12)
-	if _, err := pipeline.Exec(ctx); err != nil {
+	if err := db.Cache.SetList(cacheKey(query, window), time.Hour*12, itemStrList...); err != nil {
 		log.Println("添加搜索结果到缓存时发生错误", err)
 	}
 }
 
-// 从索引服务器中检索
-func getFromIndexServer(query string) []*searchResultItem {
-	addrList := indexerAddrList.Load().([]string)
-	resultList := requestServerList(addrList, func(channel chan<- interface{}, addr string) {
-		resp, err := http.Get(fmt.Sprintf("http://%s/search?query=%s", addr, url.QueryEscape(query)))
+// 从索引服务器中检索，每个分片的一个副本返回排在前 window 的结果，合并后按 score 降序排序并按 URL 去重，
+// total 为各个分片的结果总数之和，suggestion 取第一个不为空的；查询有语法错误时只返回 queryError
+func getFromIndexServer(query string, window int) *indexServerResult {
+	resultList := requestShardList(searchShardList(), func(channel chan<- interface{}, addr string) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/search?query=%s&limit=%d", addr, url.QueryEscape(query), window))
 		if err != nil {
 			channel <- nil
 			log.Println(err)
@@ -124,31 +231,51 @@ func getFromIndexServer(query string) []*searchResultItem {
 			channel <- nil
 			log.Println(err)
 			return
-		} else if j.Get("code").MustInt() != codeSuccess {
+		} else if code := j.Get("code").MustInt(); code == codeQueryError {
+			// 各个索引服务器的解析结果都一样
+			channel <- &indexServerResult{queryError: j.Get("msg").MustString()}
+			return
+		} else if code != codeSuccess {
+			channel <- nil
 			log.Println(j.Get("msg").MustString())
 			return
 		}
 
-		var items []*searchResultItem
-		for _, item := range j.Get("data").Get("items").MustArray() {
-			it := item.(map[string]interface{})
-			score, _ := it["score"].(json.Number).Float64()
-			t := &searchResultItem{
-				Url:      it["url"].(string),
-				Title:    it["title"].(string),
-				Abstract: it["abstract"].(string),
-				Score:    score,
-			}
-			items = append(items, t)
+		r := &indexServerResult{
+			total:      j.Get("data").Get("total").MustInt(),
+			suggestion: j.Get("data").Get("suggestion").MustString(),
 		}
-		channel <- items
+		items, _ := j.Get("data").Get("items").MarshalJSON()
+		if err = json.Unmarshal(items, &r.items); err != nil {
+			channel <- nil
+			log.Println(err)
+			return
+		}
+		channel <- r
 	})
 
-	retItems := make([]*searchResultItem, 0, 100)
-	for _, items := range resultList {
-		retItems = append(retItems, items.([]*searchResultItem)...)
+	ret := &indexServerResult{items: make([]*searchResultItem, 0, window)}
+	for _, r := range resultList {
+		r := r.(*indexServerResult)
+		if r.queryError != "" {
+			return r
+		}
+		ret.items = append(ret.items, r.items...)
+		ret.total += r.total
+		if ret.suggestion == "" {
+			ret.suggestion = r.suggestion
+		}
 	}
-	return retItems
+	// 按 score 降序排序
+	sort.Slice(ret.items, func(i, j int) bool {
+		return ret.items[i].Score > ret.items[j].Score
+	})
+	// 迁移文档期间同一个 URL 可能同时在两个分片上
+	var duplicates int
+	ret.items, duplicates = dedupResultItems(ret.items)
+	ret.total -= duplicates
+	ret.items = filterResultItems(ret.items[:util.MinInt(window, len(ret.items))])
+	return ret
 }
 
 func SearchHandler(writer http.ResponseWriter, request *http.Request) {
@@ -162,11 +289,14 @@ func SearchHandler(writer http.ResponseWriter, request *http.Request) {
 		})
 		return
 	}
-	if i, err := strconv.Atoi(request.FormValue("pn")); err == nil {
+	if i, err := strconv.Atoi(request.FormValue("pn")); err == nil && i >= 1 {
 		pn = i
 	}
-	if pn >= 11 {
-		servePage(writer, "search-result.gohtml", http.StatusOK, &searchResult{Info: "查询内容为空"})
+	if pn > maxPn {
+		servePage(writer, "search-result.gohtml", http.StatusOK, &searchResult{
+			Query: query,
+			Info:  fmt.Sprintf("最多只能查看前 %d 页结果", maxPn),
+		})
 		return
 	}
 
@@ -182,25 +312,41 @@ func SearchHandler(writer http.ResponseWriter, request *http.Request) {
 	if r, err := getFromCache(query, pn); err != nil {
 		log.Println("从缓存获取结果时错误", err)
 	} else if r != nil {
+		// 翻页不算新的查询
+		if pn == 1 && len(r.Items) > 0 {
+			recordQuery(query, clientIp(request))
+		}
 		r.Duration = time.Now().Sub(begin).Seconds()
 		servePage(writer, "search-result.gohtml", http.StatusOK, r)
 		return
 	}
 
 	// 访问索引服务器检索
-	items := getFromIndexServer(query)
-	// 按 score 降序排序
-	sort.Slice(items, func(i, j int) bool {
-		return items[i].Score > items[j].Score
-	})
+	window := windowOf(pn)
+	r := getFromIndexServer(query, window)
+	if r.queryError != "" {
+		servePage(writer, "search-result.gohtml", http.StatusOK, &searchResult{
+			Query: query,
+			Info:  "查询语法错误：" + r.queryError,
+		})
+		return
+	}
 	// 异步添加到缓存
-	go addToCache(query, items)
+	go addToCache(query, window, r)
+	// 有结果的查询才用于搜索建议，翻页不算新的查询
+	if pn == 1 && len(r.items) > 0 {
+		recordQuery(query, clientIp(request))
+	}
 
 	result := &searchResult{
-		Items: items[(pn-1)*10 : util.MinInt((pn-1)*10+10, len(items))],
-		Query: query,
-		Pn:    pn,
-		MaxPn: int(math.Ceil(float64(len(items)) / 10)),
+		Query:      query,
+		Pn:         pn,
+		MaxPn:      maxPnOf(r.total),
+		Total:      r.total,
+		Suggestion: r.suggestion,
+	}
+	if start := (pn - 1) * pageSize; start < len(r.items) {
+		result.Items = r.items[start:util.MinInt(start+pageSize, len(r.items))]
 	}
 	for _, item := range result.Items {
 		item.AnonymousUrl, _ = convertToProxyURL(baseURL, item.Url)
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"search-engine/web/db"
+	"testing"
+)
+
+// 同一个查询同时未命中缓存时会写入两次，缓存中只能有一份结果
+func TestAddToCache(t *testing.T) {
+	cache := db.Cache
+	defer func() {
+		db.Cache = cache
+	}()
+	db.Cache = db.NewMemoryCache()
+
+	r := &indexServerResult{total: 12, suggestion: "搜索"}
+	for i := 0; i < 12; i++ {
+		r.items = append(r.items, &searchResultItem{Url: "http://a.com/" + string(rune('a'+i))})
+	}
+	addToCache("搜锁", searchWindowSize, r)
+	addToCache("搜锁", searchWindowSize, r)
+
+	_, length, err := db.Cache.LRange(cacheKey("搜锁", searchWindowSize), 0, 0)
+	if err != nil || length != int64(len(r.items)+1) {
+		t.Fatal(length, err)
+	}
+	result, err := getFromCache("搜锁", 2)
+	if err != nil || result == nil {
+		t.Fatal(result, err)
+	}
+	if result.Total != 12 || result.Suggestion != "搜索" || result.MaxPn != 2 || len(result.Items) != 2 ||
+		result.Items[0].Url != "http://a.com/k" {
+		t.Error(result.Total, result.Suggestion, result.MaxPn, result.Items)
+	}
+}
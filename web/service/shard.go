@@ -0,0 +1,182 @@
+package service
+
+import (
+	"log"
+	"math/rand"
+	"search-engine/web/db"
+	"sync/atomic"
+	"time"
+)
+
+// 索引服务器按 URL 的一致性哈希分片，每个分片可以有多个副本（indexer.shard:<分片名>），检索时每个分片只查询一个
+// 健康的副本，失败时换下一个副本。正在下线的分片（所有副本都在下线）把文档迁移给其他分片后就没有文档了，
+// 检索时不用查询。迁移文档期间（注册中心中有 indexer.migrating）文档还在下线的分片上，并且同一个 URL
+// 可能同时在两个分片上，这时也查询下线的分片，合并结果时按 URL 去重
+var (
+	shardList      atomic.Value // []*indexShard
+	shardMigrating int32        // 是否有索引服务器正在迁移文档
+)
+
+type indexShard struct {
+	name string
+	// 按优先级分组的副本：同步完成且不在下线的、同步完成但在下线的、正在从其他副本同步数据的（数据可能不完整）
+	replicas [3][]string
+	drained  bool // 所有副本都在下线
+}
+
+// 依次尝试的副本，每组内的顺序随机，使请求平均分配到各个副本
+func (s *indexShard) candidates() []string {
+	var addrs []string
+	for _, group := range s.replicas {
+		for _, i := range rand.Perm(len(group)) {
+			addrs = append(addrs, group[i])
+		}
+	}
+	return addrs
+}
+
+// 注册中心中存活的地址
+func aliveAddrs(service string) (map[string]bool, error) {
+	r, err := db.Registry.List(service)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make(map[string]bool, len(r))
+	for addr, heartbeatTime := range r {
+		// 40秒内认为存活
+		if time.Now().Unix()-heartbeatTime < 40 {
+			addrs[addr] = true
+		}
+	}
+	return addrs, nil
+}
+
+// 根据注册中心更新分片状态，indexerList 为存活的索引服务器地址
+func refreshShardState(indexerList []string) {
+	shards, err := loadShards(indexerList)
+	if err != nil {
+		log.Println("获取索引服务器分片状态失败：" + err.Error())
+	}
+	shardList.Store(shards)
+	migrating, err2 := aliveAddrs("indexer.migrating")
+	if err2 != nil {
+		log.Println("获取索引服务器迁移状态失败：" + err2.Error())
+	}
+	// 不确定时按正在迁移处理
+	if err != nil || err2 != nil || len(migrating) > 0 {
+		atomic.StoreInt32(&shardMigrating, 1)
+	} else {
+		atomic.StoreInt32(&shardMigrating, 0)
+	}
+}
+
+// 把存活的索引服务器按分片分组，没有登记分片的索引服务器单独是一个分片。
+// 出错时返回的分片仍然包括所有的索引服务器，只是状态可能不准确
+func loadShards(indexerList []string) ([]*indexShard, error) {
+	var firstErr error
+	alive := func(service string) map[string]bool {
+		addrs, err := aliveAddrs(service)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return addrs
+	}
+	draining := alive("indexer.draining")
+	recovering := alive("indexer.recovering")
+	isIndexer := make(map[string]bool, len(indexerList))
+	for _, addr := range indexerList {
+		isIndexer[addr] = true
+	}
+
+	var shards []*indexShard
+	grouped := make(map[string]bool)
+	add := func(shard *indexShard, addr string) {
+		grouped[addr] = true
+		switch {
+		case recovering[addr]:
+			shard.replicas[2] = append(shard.replicas[2], addr)
+		case draining[addr]:
+			shard.replicas[1] = append(shard.replicas[1], addr)
+		default:
+			shard.replicas[0] = append(shard.replicas[0], addr)
+		}
+	}
+	for name := range alive("indexer.shards") {
+		shard := &indexShard{name: name}
+		for addr := range alive("indexer.shard:" + name) {
+			if isIndexer[addr] && !grouped[addr] {
+				add(shard, addr)
+			}
+		}
+		shards = append(shards, shard)
+	}
+	for _, addr := range indexerList {
+		if !grouped[addr] {
+			shard := &indexShard{name: addr}
+			add(shard, addr)
+			shards = append(shards, shard)
+		}
+	}
+
+	ret := shards[:0]
+	for _, shard := range shards {
+		addrs := shard.candidates()
+		shard.drained = true
+		for _, addr := range addrs {
+			shard.drained = shard.drained && draining[addr]
+		}
+		if len(addrs) > 0 {
+			ret = append(ret, shard)
+		}
+	}
+	return ret, firstErr
+}
+
+// 检索时查询的分片，迁移文档期间包括正在下线的分片
+func searchShardList() []*indexShard {
+	shards, _ := shardList.Load().([]*indexShard)
+	if atomic.LoadInt32(&shardMigrating) == 1 {
+		return shards
+	}
+	ret := make([]*indexShard, 0, len(shards))
+	for _, shard := range shards {
+		if !shard.drained {
+			ret = append(ret, shard)
+		}
+	}
+	return ret
+}
+
+// 和 requestServerList 一样，但每个分片只请求一个副本，f 失败（发送 nil）时换下一个副本
+func requestShardList(shards []*indexShard, f func(channel chan<- interface{}, addr string)) []interface{} {
+	names := make([]string, 0, len(shards))
+	byName := make(map[string]*indexShard, len(shards))
+	for _, shard := range shards {
+		names = append(names, shard.name)
+		byName[shard.name] = shard
+	}
+	return requestServerList(names, func(channel chan<- interface{}, name string) {
+		for _, addr := range byName[name].candidates() {
+			c := make(chan interface{}, 1)
+			f(c, addr)
+			if r := <-c; r != nil {
+				channel <- r
+				return
+			}
+		}
+		channel <- nil
+	})
+}
+
+// 去掉 URL 重复的结果，保留第一个（items 已按分数降序排列），返回去掉的数量
+func dedupResultItems(items []*searchResultItem) ([]*searchResultItem, int) {
+	seen := make(map[string]bool, len(items))
+	ret := items[:0]
+	for _, item := range items {
+		if !seen[item.Url] {
+			seen[item.Url] = true
+			ret = append(ret, item)
+		}
+	}
+	return ret, len(items) - len(ret)
+}
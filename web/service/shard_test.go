@@ -0,0 +1,72 @@
+package service
+
+import (
+	"reflect"
+	"search-engine/web/db"
+	"sort"
+	"testing"
+)
+
+func TestLoadShards(t *testing.T) {
+	registry := db.Registry
+	defer func() {
+		db.Registry = registry
+	}()
+	db.Registry = db.NewMemoryRegistry()
+	_ = db.Registry.Register("indexer.shards", "s1")
+	_ = db.Registry.Register("indexer.shards", "s2")
+	_ = db.Registry.Register("indexer.shard:s1", "a:1")
+	_ = db.Registry.Register("indexer.shard:s1", "b:1")
+	_ = db.Registry.Register("indexer.shard:s1", "c:1")
+	_ = db.Registry.Register("indexer.shard:s2", "d:1")
+	_ = db.Registry.Register("indexer.recovering", "b:1")
+	_ = db.Registry.Register("indexer.draining", "c:1")
+	_ = db.Registry.Register("indexer.draining", "d:1")
+
+	shards, err := loadShards([]string{"a:1", "b:1", "c:1", "d:1", "e:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]*indexShard)
+	for _, shard := range shards {
+		got[shard.name] = shard
+	}
+	if len(got) != 3 {
+		t.Fatal(got)
+	}
+	// 没有登记分片的 e:1 单独是一个分片
+	if s := got["s1"]; s.drained || !reflect.DeepEqual(s.replicas, [3][]string{{"a:1"}, {"c:1"}, {"b:1"}}) {
+		t.Errorf("%+v", s)
+	}
+	if s := got["s2"]; !s.drained {
+		t.Errorf("%+v", s)
+	}
+	if s := got["e:1"]; s.drained || !reflect.DeepEqual(s.candidates(), []string{"e:1"}) {
+		t.Errorf("%+v", s)
+	}
+}
+
+func TestRequestShardList(t *testing.T) {
+	shards := []*indexShard{
+		{name: "s1", replicas: [3][]string{{"a:1", "b:1"}}},
+		{name: "s2", replicas: [3][]string{{"c:1"}, nil, {"d:1"}}},
+		{name: "s3", replicas: [3][]string{{"e:1"}}},
+	}
+	// a:1、c:1 和 e:1 不可用，s1 和 s2 换到其他副本，s3 没有结果
+	down := map[string]bool{"a:1": true, "c:1": true, "e:1": true}
+	results := requestShardList(shards, func(channel chan<- interface{}, addr string) {
+		if down[addr] {
+			channel <- nil
+			return
+		}
+		channel <- addr
+	})
+	var addrs []string
+	for _, r := range results {
+		addrs = append(addrs, r.(string))
+	}
+	sort.Strings(addrs)
+	if !reflect.DeepEqual(addrs, []string{"b:1", "d:1"}) {
+		t.Error(addrs)
+	}
+}
@@ -0,0 +1,213 @@
+// 搜索建议（自动补全）：前缀树由索引服务器中的标题和热门查询建立，定期重建。
+// 只统计首次提交的查询（不包括输入过程中的请求和翻页），只记录查询本身和次数，不记录用户、时间。
+// 至少被 minPopularQueryClients 个不同的客户端检索过的查询才会出现在建议中，一个客户端不能单独把查询变成热门，
+// 为此在达到这个数量之前暂时保存检索过的客户端 IP 的哈希（进程内随机种子，无法还原 IP），达到后即删除。
+// 没有成为热门的查询每 queryStatsPruneInterval 清除一次，否则记录的查询达到上限后就不能再记录新的查询
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bitly/go-simplejson"
+	"hash/maphash"
+	"log"
+	"net"
+	"net/http"
+	"search-engine/web/util"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	suggestionCount         = 10               // 每次返回的建议数量
+	suggestRebuildInterval  = time.Minute * 10 // 重建前缀树的间隔
+	titleLimit              = 10000            // 从每个索引服务器获取的标题数量
+	maxSuggestionLength     = 50               // 太长的标题、查询不作为建议
+	minPopularQueryClients  = 3                // 不同的客户端数量达到该值的查询才作为建议
+	popularQueryWeight      = 10               // 查询检索一次的权重，标题每个文档的权重为 1
+	maxRecordedQueries      = 100000           // 记录的查询数量上限，超过后到下次清除前不再记录新的查询
+	queryStatsPruneInterval = time.Hour        // 清除没有成为热门的查询的间隔
+)
+
+var (
+	suggestTrie atomic.Value // *util.Trie
+
+	queryStatsLock sync.Mutex
+	queryStats     = make(map[string]*queryStat) // 规范化后的查询 -> 检索统计
+	clientSeed     = maphash.MakeSeed()
+)
+
+type queryStat struct {
+	count   int                 // 检索次数
+	clients map[uint64]struct{} // 检索过的客户端 IP 的哈希，成为热门查询后为 nil
+}
+
+// 规范化：转为小写，合并空白，建议和查询的前缀都要规范化
+func normalizeSuggestion(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// 记录一次提交的查询，client 是客户端的 IP
+func recordQuery(query, client string) {
+	query = normalizeSuggestion(query)
+	if query == "" || len([]rune(query)) > maxSuggestionLength {
+		return
+	}
+	var h maphash.Hash
+	h.SetSeed(clientSeed)
+	_, _ = h.WriteString(client)
+
+	queryStatsLock.Lock()
+	defer queryStatsLock.Unlock()
+	stat, ok := queryStats[query]
+	if !ok {
+		if len(queryStats) >= maxRecordedQueries {
+			return
+		}
+		stat = &queryStat{clients: make(map[uint64]struct{})}
+		queryStats[query] = stat
+	}
+	stat.count++
+	if stat.clients != nil {
+		stat.clients[h.Sum64()] = struct{}{}
+		if len(stat.clients) >= minPopularQueryClients {
+			stat.clients = nil
+		}
+	}
+}
+
+// 热门查询及其检索次数
+func popularQueries() map[string]int {
+	queryStatsLock.Lock()
+	defer queryStatsLock.Unlock()
+	ret := make(map[string]int)
+	for query, stat := range queryStats {
+		if stat.clients == nil {
+			ret[query] = stat.count
+		}
+	}
+	return ret
+}
+
+// 清除还没有成为热门的查询，一个查询需要在一个清除间隔内被足够多的客户端检索才能成为热门
+func pruneQueryStats() {
+	queryStatsLock.Lock()
+	defer queryStatsLock.Unlock()
+	for query, stat := range queryStats {
+		if stat.clients != nil {
+			delete(queryStats, query)
+		}
+	}
+}
+
+// 客户端的 IP，只有来自本机（反向代理）的请求才使用 X-Real-IP、X-Forwarded-For，否则客户端可以伪造
+func clientIp(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return host
+	}
+	if realIp := strings.TrimSpace(request.Header.Get("X-Real-IP")); realIp != "" {
+		return realIp
+	}
+	if forwarded := request.Header.Get("X-Forwarded-For"); forwarded != "" {
+		// 最后一个是反向代理看到的地址，前面的可能是客户端伪造的
+		parts := strings.Split(forwarded, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	return host
+}
+
+// 定期重建前缀树，需要在获取到索引服务器地址后调用
+func initSuggest() {
+	suggestTrie.Store(util.NewTrie(nil, suggestionCount))
+	go func() {
+		lastPrune := time.Now()
+		for {
+			rebuildSuggestTrie()
+			time.Sleep(suggestRebuildInterval)
+			if time.Since(lastPrune) >= queryStatsPruneInterval {
+				pruneQueryStats()
+				lastPrune = time.Now()
+			}
+		}
+	}()
+}
+
+func rebuildSuggestTrie() {
+	words := make(map[string]int)
+	// 同一分片的副本的标题相同，只请求一个副本，否则次数会重复计算
+	resultList := requestShardList(searchShardList(), func(channel chan<- interface{}, addr string) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/titles?limit=%d", addr, titleLimit))
+		if err != nil {
+			channel <- nil
+			log.Println(err)
+			return
+		}
+		defer resp.Body.Close()
+
+		j, err := simplejson.NewFromReader(resp.Body)
+		if err != nil || j.Get("code").MustInt() != codeSuccess {
+			channel <- nil
+			log.Println("获取标题失败", err)
+			return
+		}
+		channel <- j.Get("data").MustArray()
+	})
+	for _, r := range resultList {
+		for _, item := range r.([]interface{}) {
+			it := item.(map[string]interface{})
+			title := normalizeSuggestion(it["title"].(string))
+			count, _ := it["count"].(json.Number).Int64()
+			if len([]rune(title)) <= maxSuggestionLength {
+				words[title] += int(count)
+			}
+		}
+	}
+	for query, count := range popularQueries() {
+		words[query] += count * popularQueryWeight
+	}
+	suggestTrie.Store(util.NewTrie(words, suggestionCount))
+}
+
+// GET /suggest?q=xxx，返回 OpenSearch 的建议格式：["前缀", ["建议1", "建议2", ...]]。
+// 输入过程中的请求，不记录日志
+func SuggestHandler(writer http.ResponseWriter, request *http.Request) {
+	prefix := request.FormValue("q")
+	suggestions := make([]string, 0, suggestionCount)
+	if p := normalizeSuggestion(prefix); p != "" {
+		for _, entry := range suggestTrie.Load().(*util.Trie).Search(p) {
+			if !hasIllegalKeywords(entry.Text) {
+				suggestions = append(suggestions, entry.Text)
+			}
+		}
+	}
+	j, _ := json.Marshal([]interface{}{prefix, suggestions})
+	writer.Header().Set("Content-Type", "application/x-suggestions+json; charset=utf-8")
+	writer.Header().Set("Cache-Control", "max-age=600")
+	_, _ = writer.Write(j)
+}
+
+// OpenSearch 描述文件，浏览器可以据此把本站添加为搜索引擎并使用搜索建议
+const openSearchDescription = `<?xml version="1.0" encoding="UTF-8"?>
+<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+    <ShortName>QUT Search</ShortName>
+    <Description>不追踪你的隐私的搜索引擎</Description>
+    <InputEncoding>UTF-8</InputEncoding>
+    <Url type="text/html" method="get" template="%[1]s/search?query={searchTerms}"/>
+    <Url type="application/x-suggestions+json" method="get" template="%[1]s/suggest?q={searchTerms}"/>
+</OpenSearchDescription>
+`
+
+func OpenSearchHandler(writer http.ResponseWriter, request *http.Request) {
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	writer.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=utf-8")
+	_, _ = fmt.Fprintf(writer, openSearchDescription, scheme+"://"+request.Host)
+}
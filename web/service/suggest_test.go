@@ -0,0 +1,71 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestPopularQueries(t *testing.T) {
+	queryStatsLock.Lock()
+	queryStats = make(map[string]*queryStat)
+	queryStatsLock.Unlock()
+
+	// 一个客户端检索很多次也不算热门
+	for i := 0; i < 10; i++ {
+		recordQuery("搜索引擎", "1.1.1.1")
+	}
+	if q := popularQueries(); len(q) != 0 {
+		t.Fatal(q)
+	}
+	recordQuery("搜索引擎", "2.2.2.2")
+	recordQuery(" 搜索引擎 ", "3.3.3.3")
+	recordQuery("倒排索引", "1.1.1.1")
+	if q := popularQueries(); len(q) != 1 || q["搜索引擎"] != 12 {
+		t.Fatal(q)
+	}
+	if queryStats["搜索引擎"].clients != nil {
+		t.Error("成为热门查询后没有删除客户端")
+	}
+
+	// 清除后只保留热门查询，达到上限后也能记录新的查询
+	for i := len(queryStats); i < maxRecordedQueries; i++ {
+		recordQuery(fmt.Sprint("查询", i), "1.1.1.1")
+	}
+	recordQuery("新的查询", "1.1.1.1")
+	if _, ok := queryStats["新的查询"]; ok {
+		t.Error("超过上限")
+	}
+	pruneQueryStats()
+	if len(queryStats) != 1 || queryStats["搜索引擎"] == nil {
+		t.Fatal(len(queryStats))
+	}
+	recordQuery("新的查询", "1.1.1.1")
+	if _, ok := queryStats["新的查询"]; !ok {
+		t.Error("清除后没有记录新的查询")
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	cases := []struct {
+		remoteAddr, realIp, forwarded, want string
+	}{
+		{"1.2.3.4:5678", "", "", "1.2.3.4"},
+		{"1.2.3.4:5678", "9.9.9.9", "8.8.8.8", "1.2.3.4"},
+		{"127.0.0.1:5678", "9.9.9.9", "", "9.9.9.9"},
+		{"127.0.0.1:5678", "", "8.8.8.8, 7.7.7.7", "7.7.7.7"},
+		{"[::1]:5678", "", "", "::1"},
+	}
+	for _, c := range cases {
+		request := &http.Request{RemoteAddr: c.remoteAddr, Header: http.Header{}}
+		if c.realIp != "" {
+			request.Header.Set("X-Real-IP", c.realIp)
+		}
+		if c.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := clientIp(request); got != c.want {
+			t.Errorf("%+v: %s", c, got)
+		}
+	}
+}
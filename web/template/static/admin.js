@@ -25,12 +25,19 @@ $(function () {
                         info.dead = "<span style='color: red;font-weight: bold;'>死亡</span>"
                         info.mem_total = info.mem_percent = info.cpu_percent = info.running_time = ""
                         info.crawled_count = info.failure_count = info.failure_rate = ""
+                        info.feed_count = info.trap_hosts = ""
                     } else {
                         info.dead = "<span style='color: limegreen; font-weight: bold'>存活</span>"
                         info.mem_percent = (info.mem_percent * 100).toFixed(2) + "%"
                         info.cpu_percent = info.cpu_percent.toFixed(2) + "%"
                         info.failure_rate = (info.failure_rate.toFixed(2) * 100) + "%"
                         info.mem_total = humanReadable(info.mem_total)
+                        let trapHosts = ""
+                        for (let j in info.trap_hosts) {
+                            let trap = info.trap_hosts[j]
+                            trapHosts += trap.host + "(" + trap.count + ")<br>"
+                        }
+                        info.trap_hosts = trapHosts
                     }
                     html += "<tr>" +
                         "<td>" + info.addr + "</td>" +
@@ -42,6 +49,8 @@ $(function () {
                         "<td>" + info.crawled_count + "</td>" +
                         "<td>" + info.failure_count + "</td>" +
                         "<td>" + info.failure_rate + "</td>" +
+                        "<td>" + info.feed_count + "</td>" +
+                        "<td>" + info.trap_hosts + "</td>" +
                         "</tr>"
                 }
                 $("#table_crawler tbody").html(html)
@@ -59,9 +68,16 @@ $(function () {
                     if (info.dead === true) {
                         info.dead = "<span style='color: red; font-weight: bold'>死亡</span>"
                         info.mem_total = info.mem_percent = info.cpu_percent = info.running_time = ""
-                        info.index_size = info.indexed_doc_count = info.token_count = ""
+                        info.index_size = info.indexed_doc_count = info.token_count = info.segment_count = ""
+                        info.shard = ""
                     } else {
-                        info.dead = "<span style='color: limegreen; font-weight: bold'>存活</span>"
+                        if (info.recovering === true) {
+                            info.dead = "<span style='color: orange; font-weight: bold'>同步中</span>"
+                        } else if (info.draining === true) {
+                            info.dead = "<span style='color: gray; font-weight: bold'>下线中</span>"
+                        } else {
+                            info.dead = "<span style='color: limegreen; font-weight: bold'>存活</span>"
+                        }
                         info.cpu_percent = info.cpu_percent.toFixed(2) + "%"
                         info.mem_percent = (info.mem_percent * 100).toFixed(2) + "%"
                         info.mem_total = humanReadable(info.mem_total)
@@ -69,6 +85,7 @@ $(function () {
                     }
                     html += "<tr>" +
                         "<td>" + info.addr + "</td>" +
+                        "<td>" + info.shard + "</td>" +
                         "<td>" + info.dead + "</td>" +
                         "<td>" + info.mem_total + "</td>" +
                         "<td>" + info.mem_percent + "</td>" +
@@ -77,6 +94,7 @@ $(function () {
                         "<td>" + info.index_size + "</td>" +
                         "<td>" + info.indexed_doc_count + "</td>" +
                         "<td>" + info.token_count + "</td>" +
+                        "<td>" + info.segment_count + "</td>" +
                         "</tr>"
                 }
                 $("#table_indexer tbody").html(html)
@@ -111,6 +129,20 @@ $(function () {
             $("#domain").val("")
         })
     })
+    $("#btn_priority").click(function () {
+        let domainList = $("#domain").val().trim()
+        let priority = $("#domain_priority").val().trim()
+        $.post("/admin/manage_domain_priority", {domain:domainList, priority:priority, opType:"set"}, function (data, status){
+            const json = JSON.parse(data)
+            if (status !== "success" || json.code !== 0) {
+                alert("设置优先级失败" + (json.msg ? "：" + json.msg : ""))
+                return
+            }
+            alert("设置优先级成功")
+            $("#domain").val("")
+            $("#refresh_domain_priority").click()
+        })
+    })
     $("#btn_keyword").click(function () {
         let keywords = $("#illegal_keyword").val().trim()
         $.post("/admin/manage_illegal_keyword", {keyword:keywords, opType:"add"}, function (data, status){
@@ -158,6 +190,42 @@ $(function () {
             })
         })
     })
+    $("#refresh_domain_priority").click(function () {
+        $.get("/admin/get_domain_priority", function (data, status) {
+            if (status !== "success") {
+                alert("操作失败")
+                return
+            }
+            const json = JSON.parse(data)
+            if (json.code !== 0) {
+                alert("操作失败")
+                return
+            }
+            let html = ""
+            for (let d in json.data) {
+                html += "<tr>" +
+                    "<td>" + d + "</td>" +
+                    "<td>" + json.data[d] + "</td>" +
+                    "<td>" +
+                        "<button class='btn btn-sm btn-primary del-priority' data-domain='" + d + "'>删除</button>" +
+                    "</td>" +
+                    "</tr>"
+            }
+            $("#table_domain_priority tbody").html(html)
+
+            $(".del-priority").click(function (){
+                const domain = $(this).attr("data-domain")
+                $.post("/admin/manage_domain_priority", {domain:domain, opType:"del"}, function (data, status) {
+                    if (status !== "success" || JSON.parse(data).code !== 0) {
+                        alert("操作失败")
+                        return
+                    }
+                    alert("操作成功")
+                    $("#refresh_domain_priority").click()
+                })
+            })
+        })
+    })
     $("#refresh_illegal_keyword").click(function () {
         $.get("/admin/get_illegal_keyword", function (data, status) {
             if (status !== "success") {
@@ -195,6 +263,55 @@ $(function () {
         })
     })
 
+    ///////////////////同义词管理////////////////////
+    $("#btn_synonym").click(function () {
+        const word = $("#synonym_word").val().trim()
+        const synonyms = $("#synonym_list").val().trim()
+        $.post("/admin/manage_synonym", {word:word, synonyms:synonyms, opType:"add"}, function (data, status) {
+            if (status !== "success" || JSON.parse(data).code !== 0) {
+                alert("添加同义词失败")
+                return
+            }
+            alert("添加同义词成功")
+            $("#synonym_word").val("")
+            $("#synonym_list").val("")
+            $("#refresh_synonym").click()
+        })
+    })
+    $("#nav_synonym").click(function () {
+        $("#refresh_synonym").click()
+    })
+    $("#refresh_synonym").click(function () {
+        $.get("/admin/get_synonym", function (data, status) {
+            if (status !== "success") {
+                alert("操作失败")
+                return
+            }
+            const json = JSON.parse(data)
+            if (json.code !== 0) {
+                alert("操作失败")
+                return
+            }
+            const tbody = $("#table_synonym tbody").empty()
+            for (let word in json.data) {
+                const button = $("<button class='btn btn-sm btn-primary'>删除</button>").click(function () {
+                    $.post("/admin/manage_synonym", {word:word, opType:"del"}, function (data, status) {
+                        if (status !== "success" || JSON.parse(data).code !== 0) {
+                            alert("操作失败")
+                            return
+                        }
+                        alert("操作成功")
+                        $("#refresh_synonym").click()
+                    })
+                })
+                tbody.append($("<tr>")
+                    .append($("<td>").text(word))
+                    .append($("<td>").text(json.data[word].join(" | ")))
+                    .append($("<td>").append(button)))
+            }
+        })
+    })
+
     ///////////////////爬虫管理////////////////////
     let btnCrawlerSuspend = $("#btn_crawler_suspend")
     let btnRandomInterval = $("#btn_random_interval")
@@ -213,6 +330,11 @@ $(function () {
             $("#timeout").val(json.data.timeout)
             $("#useragent").val(json.data.useragent)
             $("#retry_count").val(json.data.retry_count)
+            $("#max_url_length").val(json.data.max_url_length)
+            $("#max_repeated_segments").val(json.data.max_repeated_segments)
+            $("#max_param_values").val(json.data.max_param_values)
+            $("#max_pattern_urls").val(json.data.max_pattern_urls)
+            $("#feed_poll_interval").val(json.data.feed_poll_interval)
             if (json.data.suspend === "true") {
                 btnCrawlerSuspend.text("开").attr("class", "btn btn-primary")
             } else {
@@ -269,4 +391,67 @@ $(function () {
     $("#btn_timeout").click(function () {
         manageCrawler("timeout", $("#timeout").val())
     })
+    for (let name of ["max_url_length", "max_repeated_segments", "max_param_values", "max_pattern_urls", "feed_poll_interval"]) {
+        $("#btn_" + name).click(function () {
+            manageCrawler(name, $("#" + name).val())
+        })
+    }
+
+    ///////////////////备份管理////////////////////
+    function createBackup(addr, button) {
+        const text = button.text()
+        button.prop("disabled", true).text("备份中...")
+        $.post("/admin/create_backup", {addr: addr}, function (data, status) {
+            alert("备份成功")
+        }).fail(function (xhr) {
+            let msg = "备份失败"
+            try {
+                msg = JSON.parse(xhr.responseText).msg
+            } catch (e) {
+            }
+            alert(msg)
+        }).always(function () {
+            button.prop("disabled", false).text(text)
+            $("#refresh_backup").click()
+        })
+    }
+    $("#btn_backup_all").click(function () {
+        createBackup("", $(this))
+    })
+    $("#nav_backup").click(function () {
+        $("#refresh_backup").click()
+    })
+    $("#refresh_backup").click(function () {
+        $.get("/admin/get_backups", function (data, status) {
+            const json = JSON.parse(data)
+            if (json.code !== 0) {
+                alert("获取备份失败")
+                return
+            }
+            const tbody = $("#table_backup tbody").empty()
+            for (let server of json.data) {
+                const button = $("<button class='btn btn-sm btn-primary'>立即备份</button>").click(function () {
+                    createBackup(server.addr, $(this))
+                })
+                if (server.backups.length === 0) {
+                    tbody.append($("<tr>")
+                        .append($("<td>").text(server.addr))
+                        .append($("<td>").text("无备份"))
+                        .append($("<td>"))
+                        .append($("<td>"))
+                        .append($("<td>").append(button)))
+                    continue
+                }
+                for (let i = 0; i < server.backups.length; i++) {
+                    const backup = server.backups[i]
+                    tbody.append($("<tr>")
+                        .append($("<td>").text(i === 0 ? server.addr : ""))
+                        .append($("<td>").text(backup.name))
+                        .append($("<td>").text(humanReadable(backup.size)))
+                        .append($("<td>").text(new Date(backup.time * 1000).toLocaleString()))
+                        .append($("<td>").append(i === 0 ? button : "")))
+                }
+            }
+        })
+    })
 })
\ No newline at end of file
@@ -0,0 +1,23 @@
+// 搜索框的自动补全：输入时请求 /suggest，把建议填到搜索框的 datalist 中
+$(function () {
+    let timer = null
+    $("input[name=query]").on("input", function () {
+        const input = $(this)
+        const list = $("#" + input.attr("list"))
+        clearTimeout(timer)
+        // 停止输入一会儿再请求，减少请求数量
+        timer = setTimeout(function () {
+            const q = input.val()
+            if ($.trim(q) === "") {
+                list.empty()
+                return
+            }
+            $.getJSON("/suggest", {q: q}, function (data) {
+                list.empty()
+                $.each(data[1], function (i, suggestion) {
+                    list.append($("<option>").attr("value", suggestion))
+                })
+            })
+        }, 200)
+    })
+})
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"html/template"
+	"strings"
+)
+
+const (
+	highlightPrefix = `<span class="highlight">`
+	highlightSuffix = `</span>`
+)
+
+// 转义纯文本，并给高亮区间加上标签。highlights 是字符下标的区间 [start, end)，
+// 由索引服务器返回，已排序且互不相交，越界或顺序错误的区间被忽略
+func HighlightHTML(text string, highlights [][2]int) template.HTML {
+	chars := []rune(text)
+	builder := &strings.Builder{}
+	pos := 0
+	for _, h := range highlights {
+		start, end := h[0], MinInt(h[1], len(chars))
+		if start < pos || start >= end {
+			continue
+		}
+		builder.WriteString(template.HTMLEscapeString(string(chars[pos:start])))
+		builder.WriteString(highlightPrefix)
+		builder.WriteString(template.HTMLEscapeString(string(chars[start:end])))
+		builder.WriteString(highlightSuffix)
+		pos = end
+	}
+	builder.WriteString(template.HTMLEscapeString(string(chars[pos:])))
+	return template.HTML(builder.String())
+}
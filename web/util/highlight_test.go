@@ -0,0 +1,24 @@
+package util
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestHighlightHTML(t *testing.T) {
+	for _, c := range []struct {
+		text       string
+		highlights [][2]int
+		want       template.HTML
+	}{
+		{"搜索引擎", [][2]int{{0, 2}}, `<span class="highlight">搜索</span>引擎`},
+		{"<b>search</b>", [][2]int{{3, 9}}, `&lt;b&gt;<span class="highlight">search</span>&lt;/b&gt;`},
+		{"<script>alert(1)</script>", nil, `&lt;script&gt;alert(1)&lt;/script&gt;`},
+		// 越界、相交的区间
+		{"abcdef", [][2]int{{1, 3}, {2, 4}, {5, 100}, {-1, 2}}, `a<span class="highlight">bc</span>de<span class="highlight">f</span>`},
+	} {
+		if got := HighlightHTML(c.text, c.highlights); got != c.want {
+			t.Errorf("%q: %s", c.text, got)
+		}
+	}
+}
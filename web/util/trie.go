@@ -0,0 +1,121 @@
+package util
+
+import "sort"
+
+// 压缩前缀树（基数树），没有分支的一串节点合并成一条边，用于搜索建议。
+// 建好后只读，每个节点保存以它为前缀的权重最高的 topK 个词，查询时不用遍历子树
+type Trie struct {
+	root *trieNode
+	topK int
+}
+
+type TrieEntry struct {
+	Text   string
+	Weight int
+}
+
+type trieNode struct {
+	label    []rune      // 父节点到这个节点的边
+	children []*trieNode // 按 label 的第一个字符升序排列
+	entry    *TrieEntry  // 有词在这个节点结束时不为 nil
+	top      []TrieEntry // 以这个节点为前缀的词中权重最高的 topK 个，权重降序
+}
+
+// 由词及其权重建立前缀树，空字符串和权重不大于 0 的词被忽略
+func NewTrie(words map[string]int, topK int) *Trie {
+	t := &Trie{root: &trieNode{}, topK: topK}
+	for text, weight := range words {
+		if text != "" && weight > 0 {
+			t.root.insert([]rune(text), &TrieEntry{Text: text, Weight: weight})
+		}
+	}
+	t.root.collectTop(topK)
+	return t
+}
+
+func commonPrefixLength(a, b []rune) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// 第一个字符为 ch 的子节点的下标，不存在时返回应该插入的位置和 false
+func (n *trieNode) child(ch rune) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool {
+		return n.children[i].label[0] >= ch
+	})
+	return i, i < len(n.children) && n.children[i].label[0] == ch
+}
+
+func (n *trieNode) insert(key []rune, entry *TrieEntry) {
+	for {
+		i, ok := n.child(key[0])
+		if !ok {
+			n.children = append(n.children, nil)
+			copy(n.children[i+1:], n.children[i:])
+			n.children[i] = &trieNode{label: key, entry: entry}
+			return
+		}
+		child := n.children[i]
+		common := commonPrefixLength(child.label, key)
+		if common < len(child.label) {
+			// 拆分边：公共前缀作为新的中间节点
+			mid := &trieNode{label: child.label[:common], children: []*trieNode{child}}
+			child.label = child.label[common:]
+			n.children[i] = mid
+			child = mid
+		}
+		if key = key[common:]; len(key) == 0 {
+			child.entry = entry
+			return
+		}
+		n = child
+	}
+}
+
+// 自底向上计算每个节点的 top
+func (n *trieNode) collectTop(k int) []TrieEntry {
+	var candidates []TrieEntry
+	if n.entry != nil {
+		candidates = append(candidates, *n.entry)
+	}
+	for _, child := range n.children {
+		candidates = append(candidates, child.collectTop(k)...)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Weight != candidates[j].Weight {
+			return candidates[i].Weight > candidates[j].Weight
+		}
+		return candidates[i].Text < candidates[j].Text
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	n.top = candidates
+	return n.top
+}
+
+// 以 prefix 为前缀的权重最高的词，最多 topK 个，按权重降序排列
+func (t *Trie) Search(prefix string) []TrieEntry {
+	key := []rune(prefix)
+	n := t.root
+	for len(key) > 0 {
+		i, ok := n.child(key[0])
+		if !ok {
+			return nil
+		}
+		child := n.children[i]
+		common := commonPrefixLength(child.label, key)
+		if common == len(key) {
+			// prefix 在这条边的中间（或末尾）结束
+			return child.top
+		} else if common < len(child.label) {
+			return nil
+		}
+		key = key[common:]
+		n = child
+	}
+	return n.top
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrie_Search(t *testing.T) {
+	trie := NewTrie(map[string]int{
+		"search":        5,
+		"search engine": 9,
+		"searching":     1,
+		"sea":           3,
+		"go":            4,
+		"搜索引擎":          7,
+		"搜索":            2,
+		"ignored":       0,
+	}, 3)
+	texts := func(prefix string) []string {
+		var ret []string
+		for _, e := range trie.Search(prefix) {
+			ret = append(ret, e.Text)
+		}
+		return ret
+	}
+	for prefix, want := range map[string][]string{
+		"se":       {"search engine", "search", "sea"},
+		"sea":      {"search engine", "search", "sea"},
+		"searchi":  {"searching"},
+		"search e": {"search engine"},
+		"搜":        {"搜索引擎", "搜索"},
+		"g":        {"go"},
+		"":         {"search engine", "搜索引擎", "search"},
+		"x":        nil,
+		"seb":      nil,
+		"ignored":  nil,
+	} {
+		if got := texts(prefix); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: %v", prefix, got)
+		}
+	}
+}
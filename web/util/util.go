@@ -6,3 +6,10 @@ func MinInt(a, b int) int {
 	}
 	return b
 }
+
+func MaxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}